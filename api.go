@@ -0,0 +1,452 @@
+// File: api.go
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Paging limits for the listing API
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// maxCachedListings bounds the number of sorted result sets kept per index version
+const maxCachedListings = 32
+
+// FileListResponse is the JSON body returned by /api/files
+type FileListResponse struct {
+	Total  int            `json:"total"`  // Files matching the query
+	Offset int            `json:"offset"` // Position of the first returned file
+	Limit  int            `json:"limit"`  // Requested page size
+	Counts map[string]int `json:"counts"` // Files per type across the whole index
+	Files  []FileInfo     `json:"files"`
+}
+
+// fileQuery holds the parsed parameters of a listing request
+type fileQuery struct {
+	Type      string
+	Dir       string // Web directory to list, empty for the whole index
+	Recursive bool   // Include the subdirectories of Dir
+	Camera    string // Lowercase text the camera make or model must contain
+	Search    string // Lowercase text the name or descriptive metadata must contain
+	GPS       bool   // Only files with a GPS position
+	SHA256    string // Lowercase hex content hash, to find the copies of a file
+
+	// Place filters, matched case-insensitively against the place of a file
+	Country string // ISO country code
+	Region  string
+	City    string
+
+	// Sidecar filters: the lowest rating from 1 to 5, a color label and a keyword.
+	// Files without a sidecar count as unrated.
+	MinRating int
+	Label     string
+	Keyword   string
+
+	// Size and video length filters, 0 when unset. Files of unknown size or length never match them.
+	MinWidth    int
+	MinHeight   int
+	MinDuration float64
+	MaxDuration float64
+	Offset      int
+	Limit       int
+	Sort        string
+	Desc        bool
+}
+
+// fileSorters compare two entries for each supported sort key
+var fileSorters = map[string]func(a, b *FileInfo) int{
+	"name": func(a, b *FileInfo) int { return strings.Compare(a.Name, b.Name) },
+	"path": func(a, b *FileInfo) int { return strings.Compare(a.Path, b.Path) },
+	"size": func(a, b *FileInfo) int { return compareInt64(a.Size, b.Size) },
+	"modified": func(a, b *FileInfo) int {
+		return a.Modified.Compare(b.Modified)
+	},
+	"type":  func(a, b *FileInfo) int { return strings.Compare(a.Type, b.Type) },
+	"album": compareAlbumTrack,
+	"duration": func(a, b *FileInfo) int {
+		return cmp.Compare(mediaDuration(a), mediaDuration(b))
+	},
+	"taken": func(a, b *FileInfo) int {
+		return a.Taken.Compare(b.Taken)
+	},
+	"rating": func(a, b *FileInfo) int {
+		return cmp.Compare(fileRating(a), fileRating(b))
+	},
+}
+
+// fileRating returns the sidecar rating of a file, 0 without a sidecar
+func fileRating(f *FileInfo) int {
+	if f.XMP == nil {
+		return 0
+	}
+	return f.XMP.Rating
+}
+
+// compareAlbumTrack orders audio files by album and track number; files without
+// tags come last
+func compareAlbumTrack(a, b *FileInfo) int {
+	switch {
+	case a.Audio == nil && b.Audio == nil:
+		return strings.Compare(a.Name, b.Name)
+	case a.Audio == nil:
+		return 1
+	case b.Audio == nil:
+		return -1
+	}
+	if c := strings.Compare(a.Audio.Album, b.Audio.Album); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Audio.Track, b.Audio.Track); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// mediaDuration returns the duration of a video or audio file, 0 when unknown
+func mediaDuration(f *FileInfo) float64 {
+	switch {
+	case f.Video != nil:
+		return f.Video.Duration
+	case f.Audio != nil:
+		return f.Audio.Duration
+	}
+	return 0
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parseFileQuery validates the query string of a listing request
+func parseFileQuery(r *http.Request) (fileQuery, error) {
+	values := r.URL.Query()
+	q := fileQuery{
+		Type:  values.Get("type"),
+		Limit: defaultPageSize,
+		Sort:  "name",
+	}
+
+	if v := values.Get("dir"); v != "" {
+		if !strings.HasPrefix(v, "/") {
+			return q, fmt.Errorf("invalid dir %q", v)
+		}
+		q.Dir = path.Clean(v)
+	}
+
+	switch values.Get("recursive") {
+	case "", "false", "0":
+	case "true", "1":
+		q.Recursive = true
+	default:
+		return q, fmt.Errorf("invalid recursive %q", values.Get("recursive"))
+	}
+
+	q.Camera = strings.ToLower(strings.TrimSpace(values.Get("camera")))
+	q.Search = strings.ToLower(strings.TrimSpace(values.Get("q")))
+	q.SHA256 = strings.ToLower(strings.TrimSpace(values.Get("sha256")))
+
+	q.Country = strings.TrimSpace(values.Get("country"))
+	q.Region = strings.TrimSpace(values.Get("region"))
+	q.City = strings.TrimSpace(values.Get("city"))
+
+	if v := values.Get("min_rating"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 5 {
+			return q, fmt.Errorf("invalid min_rating %q", v)
+		}
+		q.MinRating = n
+	}
+	q.Label = strings.TrimSpace(values.Get("label"))
+	q.Keyword = strings.TrimSpace(values.Get("keyword"))
+
+	switch values.Get("gps") {
+	case "", "false", "0":
+	case "true", "1":
+		q.GPS = true
+	default:
+		return q, fmt.Errorf("invalid gps %q", values.Get("gps"))
+	}
+
+	for name, dst := range map[string]*int{"min_width": &q.MinWidth, "min_height": &q.MinHeight} {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+
+	for name, dst := range map[string]*float64{"min_duration": &q.MinDuration, "max_duration": &q.MaxDuration} {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid offset %q", v)
+		}
+		q.Offset = n
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+		q.Limit = min(n, maxPageSize)
+	}
+
+	if v := values.Get("sort"); v != "" {
+		if _, ok := fileSorters[v]; !ok {
+			return q, fmt.Errorf("unknown sort field %q", v)
+		}
+		q.Sort = v
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("invalid order %q", values.Get("order"))
+	}
+
+	return q, nil
+}
+
+// matches reports whether an entry passes the query filters
+func (q fileQuery) matches(f *FileInfo) bool {
+	if q.Type != "" && f.Type != q.Type {
+		return false
+	}
+	if q.Dir != "" && f.Dir != q.Dir {
+		if !q.Recursive || !strings.HasPrefix(f.Dir, strings.TrimSuffix(q.Dir, "/")+"/") {
+			return false
+		}
+	}
+	if q.GPS && (f.Exif == nil || f.Exif.GPS == nil) {
+		return false
+	}
+	if q.Country != "" || q.Region != "" || q.City != "" {
+		p := f.Place
+		if p == nil || (q.Country != "" && !strings.EqualFold(p.CountryCode, q.Country)) ||
+			(q.Region != "" && !strings.EqualFold(p.Region, q.Region)) || (q.City != "" && !strings.EqualFold(p.City, q.City)) {
+			return false
+		}
+	}
+	if q.MinRating > 0 && fileRating(f) < q.MinRating {
+		return false
+	}
+	if q.Label != "" && (f.XMP == nil || !strings.EqualFold(f.XMP.Label, q.Label)) {
+		return false
+	}
+	if q.Keyword != "" && (f.XMP == nil || !slices.ContainsFunc(f.XMP.Keywords, func(k string) bool {
+		return strings.EqualFold(k, q.Keyword)
+	})) {
+		return false
+	}
+	if f.Width < q.MinWidth || f.Height < q.MinHeight {
+		return false
+	}
+	if q.MinDuration > 0 || q.MaxDuration > 0 {
+		v := f.Video
+		if v == nil || v.Duration < q.MinDuration || (q.MaxDuration > 0 && v.Duration > q.MaxDuration) {
+			return false
+		}
+	}
+	if q.SHA256 != "" && f.SHA256 != q.SHA256 {
+		return false
+	}
+	if q.Search != "" && !matchesSearch(f, q.Search) {
+		return false
+	}
+	if q.Camera != "" {
+		if f.Exif == nil || !strings.Contains(strings.ToLower(f.Exif.Make+" "+f.Exif.Model), q.Camera) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesSearch reports whether the name, document information, audio tags or
+// sidecar keywords of a file contain the lowercase text s
+func matchesSearch(f *FileInfo, s string) bool {
+	fields := []string{f.Name}
+	if f.PDF != nil {
+		fields = append(fields, f.PDF.Title, f.PDF.Author, f.PDF.Subject, f.PDF.Keywords)
+	}
+	if f.Audio != nil {
+		fields = append(fields, f.Audio.Title, f.Audio.Artist, f.Audio.Album)
+	}
+	if f.XMP != nil {
+		fields = append(fields, f.XMP.Keywords...)
+	}
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheKey identifies the sorted result set of a query, ignoring paging
+func (q fileQuery) cacheKey() string {
+	return fmt.Sprintf("%s|%s|%t|%s|%s|%s|%t|%s|%s|%s|%d|%s|%s|%d|%d|%g|%g|%s|%t", q.Type, q.Dir, q.Recursive, q.Camera, q.Search, q.SHA256, q.GPS,
+		q.Country, q.Region, q.City, q.MinRating, q.Label, q.Keyword, q.MinWidth, q.MinHeight, q.MinDuration, q.MaxDuration,
+		q.Sort, q.Desc)
+}
+
+// listingCache keeps the most recently used sorted result sets for one index version
+type listingCache struct {
+	mu      sync.Mutex
+	version uint64
+	results map[string][]FileInfo
+	counts  map[string]int
+}
+
+// reset drops cached results when the index has changed; callers hold c.mu
+func (c *listingCache) reset(version uint64) {
+	if c.version != version || c.results == nil {
+		c.version = version
+		c.results = make(map[string][]FileInfo)
+		c.counts = nil
+	}
+}
+
+// typeCounts returns the per-type counts of the current index version
+func (c *listingCache) typeCounts(index *MediaIndex) map[string]int {
+	files, version := index.Snapshot()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reset(version)
+	if c.counts == nil {
+		c.counts = typeCounts(files)
+	}
+	return c.counts
+}
+
+// get returns the filtered and sorted files for a query, reusing earlier work when possible
+func (c *listingCache) get(index *MediaIndex, q fileQuery) []FileInfo {
+	files, version := index.Snapshot()
+	key := q.cacheKey()
+
+	c.mu.Lock()
+	c.reset(version)
+	result, ok := c.results[key]
+	c.mu.Unlock()
+
+	if ok {
+		return result
+	}
+
+	result = make([]FileInfo, 0)
+	for i := range files {
+		if q.matches(&files[i]) {
+			result = append(result, files[i])
+		}
+	}
+
+	less := fileSorters[q.Sort]
+	sort.SliceStable(result, func(i, j int) bool {
+		cmp := less(&result[i], &result[j])
+		if cmp == 0 {
+			cmp = strings.Compare(result[i].Path, result[j].Path)
+		}
+		if q.Desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	c.mu.Lock()
+	if c.version == version {
+		if len(c.results) >= maxCachedListings {
+			c.results = make(map[string][]FileInfo)
+		}
+		c.results[key] = result
+	}
+	c.mu.Unlock()
+
+	return result
+}
+
+// typeCounts returns the number of files per type, including empty types
+func typeCounts(files []FileInfo) map[string]int {
+	names := categories.Names()
+	counts := make(map[string]int, len(names))
+	for _, typ := range names {
+		counts[typ] = 0
+	}
+	for i := range files {
+		counts[files[i].Type]++
+	}
+	return counts
+}
+
+// writeJSON sends v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// FileListHandler serves paginated listings from the in-memory index
+func FileListHandler(index *MediaIndex) http.HandlerFunc {
+	cache := &listingCache{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q, err := parseFileQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result := cache.get(index, q)
+
+		start := min(q.Offset, len(result))
+		end := min(start+q.Limit, len(result))
+
+		writeJSON(w, FileListResponse{
+			Total:  len(result),
+			Offset: q.Offset,
+			Limit:  q.Limit,
+			Counts: cache.typeCounts(index),
+			Files:  result[start:end],
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// File: api_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testListing is an index of a few files in nested directories
+func testListing() *MediaIndex {
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	file := func(webPath, typ string, size int64, age int) FileInfo {
+		return FileInfo{Name: path.Base(webPath), Path: webPath, Dir: path.Dir(webPath), Size: size, Type: typ, Modified: day.AddDate(0, 0, -age)}
+	}
+	return NewMediaIndex([]FileInfo{
+		file("/media/a.jpg", "image", 300, 1),
+		file("/media/b.mp4", "video", 100, 2),
+		file("/media/x/c.jpg", "image", 200, 3),
+		file("/media/x/y/d.png", "image", 400, 4),
+		file("/media/z/e.txt", "text", 50, 5),
+	})
+}
+
+// getListing runs a listing request and decodes the response
+func getListing(t *testing.T, handler http.Handler, query string) (int, FileListResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files?"+query, nil))
+	var resp FileListResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid response for %q: %v", query, err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestFileListHandler(t *testing.T) {
+	handler := FileListHandler(testListing())
+
+	tests := []struct {
+		query    string
+		total    int
+		expected []string // Names of the returned page
+	}{
+		{"", 5, []string{"a.jpg", "b.mp4", "c.jpg", "d.png", "e.txt"}},
+		{"type=image", 3, []string{"a.jpg", "c.jpg", "d.png"}},
+		{"type=audio", 0, []string{}},
+		{"dir=/media/x", 1, []string{"c.jpg"}},
+		{"dir=/media/x/&recursive=true", 2, []string{"c.jpg", "d.png"}},
+		{"dir=/media&recursive=1&type=image", 3, []string{"a.jpg", "c.jpg", "d.png"}},
+		{"sort=size", 5, []string{"e.txt", "b.mp4", "c.jpg", "a.jpg", "d.png"}},
+		{"sort=size&order=desc", 5, []string{"d.png", "a.jpg", "c.jpg", "b.mp4", "e.txt"}},
+		{"sort=modified&limit=2", 5, []string{"e.txt", "d.png"}},
+		{"limit=2&offset=2", 5, []string{"c.jpg", "d.png"}},
+		{"limit=2&offset=4", 5, []string{"e.txt"}},
+		{"offset=99", 5, []string{}},
+		{"limit=0", 5, []string{}},
+		{"q=.JPG", 2, []string{"a.jpg", "c.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			code, resp := getListing(t, handler, tt.query)
+			if code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", code)
+			}
+			names := []string{}
+			for _, f := range resp.Files {
+				names = append(names, f.Name)
+			}
+			if resp.Total != tt.total || !slices.Equal(names, tt.expected) {
+				t.Errorf("got total %d, %v, want %d, %v", resp.Total, names, tt.total, tt.expected)
+			}
+			// Counts always describe the whole index
+			if resp.Counts["image"] != 3 || resp.Counts["video"] != 1 || resp.Counts["audio"] != 0 {
+				t.Errorf("counts = %v", resp.Counts)
+			}
+		})
+	}
+}
+
+func TestFileListHandlerPaging(t *testing.T) {
+	handler := FileListHandler(testListing())
+
+	_, resp := getListing(t, handler, "")
+	if resp.Offset != 0 || resp.Limit != defaultPageSize {
+		t.Errorf("default paging = %d, %d, want 0, %d", resp.Offset, resp.Limit, defaultPageSize)
+	}
+	_, resp = getListing(t, handler, "limit=100000&offset=3")
+	if resp.Offset != 3 || resp.Limit != maxPageSize || len(resp.Files) != 2 {
+		t.Errorf("paging = %d, %d with %d files, want 3, %d with 2", resp.Offset, resp.Limit, len(resp.Files), maxPageSize)
+	}
+}
+
+func TestFileListHandlerInvalid(t *testing.T) {
+	handler := FileListHandler(testListing())
+
+	for _, query := range []string{
+		"dir=media", "recursive=yes", "gps=maybe", "min_rating=6", "min_rating=x",
+		"min_width=-1", "min_duration=abc", "offset=-1", "limit=x", "sort=color", "order=up",
+	} {
+		if code, _ := getListing(t, handler, query); code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/files", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want 405", rec.Code)
+	}
+}
+
+func TestFileListHandlerIndexChanges(t *testing.T) {
+	index := testListing()
+	handler := FileListHandler(index)
+
+	// The cached result set and counts must follow the index
+	getListing(t, handler, "type=image")
+	index.Put(FileInfo{Name: "f.jpg", Path: "/media/f.jpg", Dir: "/media", Type: "image"})
+	_, resp := getListing(t, handler, "type=image")
+	if resp.Total != 4 || resp.Counts["image"] != 4 {
+		t.Errorf("after adding: total %d, counts %v, want 4", resp.Total, resp.Counts)
+	}
+
+	index.Remove("/media/a.jpg")
+	index.Remove("/media/f.jpg")
+	_, resp = getListing(t, handler, "type=image")
+	if resp.Total != 2 || resp.Counts["image"] != 2 {
+		t.Errorf("after removing: total %d, counts %v, want 2", resp.Total, resp.Counts)
+	}
+}
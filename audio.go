@@ -0,0 +1,875 @@
+// File: audio.go
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// AudioInfo is the tag data of an audio file
+type AudioInfo struct {
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Track    int     `json:"track,omitempty"`
+	Year     int     `json:"year,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // Seconds
+	Cover    bool    `json:"cover,omitempty"`    // Embedded cover art, served at /thumbnail/
+}
+
+// audioCover is embedded cover art
+type audioCover struct {
+	MimeType string
+	Data     []byte
+}
+
+// Limits for reading tags
+const (
+	maxTagSize     = 32 << 20 // Larger tags are corrupt or not worth reading
+	mp3SyncSearch  = 64 << 10 // Bytes searched for the first MPEG frame
+	oggTailSize    = 64 << 10 // Bytes read from the end to find the last Ogg page
+	coverFrontType = 3        // Picture type of the front cover in ID3 and FLAC
+)
+
+// errNoTags is returned for audio files in formats without supported tags
+var errNoTags = errors.New("no audio tags")
+
+// audioReader collects the tags of one file. The cover is only kept when wanted,
+// scans just note that there is one.
+type audioReader struct {
+	f         *os.File
+	size      int64
+	info      *AudioInfo
+	wantCover bool
+	cover     *audioCover
+}
+
+// readAudioTags reads the tags and duration of an audio file
+func readAudioTags(fullPath string) (*AudioInfo, error) {
+	r, err := readAudio(fullPath, false)
+	if err != nil {
+		return nil, err
+	}
+	return r.info, nil
+}
+
+// readAudioCover returns the embedded cover art of an audio file
+func readAudioCover(fullPath string) (*audioCover, error) {
+	r, err := readAudio(fullPath, true)
+	if err != nil {
+		return nil, err
+	}
+	if r.cover == nil {
+		return nil, errNoTags
+	}
+	return r.cover, nil
+}
+
+// readAudio parses an audio file in any supported format
+func readAudio(fullPath string, wantCover bool) (*audioReader, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := &audioReader{f: file, size: stat.Size(), info: &AudioInfo{}, wantCover: wantCover}
+
+	var head [12]byte
+	n, _ := file.ReadAt(head[:], 0)
+	switch {
+	case n >= 3 && string(head[:3]) == "ID3", n >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		err = r.readMP3()
+	case n >= 4 && string(head[:4]) == "fLaC":
+		err = r.readFLAC()
+	case n >= 4 && string(head[:4]) == "OggS":
+		err = r.readOgg()
+	case n >= 8 && string(head[4:8]) == "ftyp":
+		err = r.readMP4()
+	case n >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		err = r.readWAV()
+	default:
+		return nil, errNoTags
+	}
+	if err != nil {
+		return nil, err
+	}
+	if *r.info == (AudioInfo{}) {
+		return nil, errNoTags
+	}
+	return r, nil
+}
+
+// setCover records a picture, preferring the front cover over other pictures
+func (r *audioReader) setCover(pictureType int, mimeType string, data []byte) {
+	if len(data) == 0 || (r.info.Cover && pictureType != coverFrontType) {
+		return
+	}
+	r.info.Cover = true
+	if r.wantCover {
+		if mimeType == "" || !strings.Contains(mimeType, "/") {
+			mimeType = sniffContent(data, "")
+		}
+		r.cover = &audioCover{MimeType: mimeType, Data: data}
+	}
+}
+
+// setText applies a tag value by its normalized name
+func (r *audioReader) setText(field, value string) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if value == "" {
+		return
+	}
+	switch field {
+	case "title":
+		r.info.Title = value
+	case "artist":
+		r.info.Artist = value
+	case "album":
+		r.info.Album = value
+	case "track":
+		num, _, _ := strings.Cut(value, "/") // "3/12"
+		r.info.Track, _ = strconv.Atoi(strings.TrimSpace(num))
+	case "year":
+		if len(value) >= 4 {
+			r.info.Year, _ = strconv.Atoi(value[:4]) // "2019" or "2019-05-03"
+		}
+	}
+}
+
+// readBlock reads size bytes at offset, refusing sizes no tag would have
+func (r *audioReader) readBlock(offset, size int64) ([]byte, error) {
+	if size < 0 || size > maxTagSize || offset+size > r.size {
+		return nil, fmt.Errorf("invalid block of %d bytes at %d", size, offset)
+	}
+	data := make([]byte, size)
+	_, err := r.f.ReadAt(data, offset)
+	return data, err
+}
+
+// ID3v2 frame IDs of the fields we use, for version 2.3/2.4 and 2.2
+var id3Frames = map[string]string{
+	"TIT2": "title", "TPE1": "artist", "TALB": "album", "TRCK": "track", "TYER": "year", "TDRC": "year",
+	"TT2": "title", "TP1": "artist", "TAL": "album", "TRK": "track", "TYE": "year",
+}
+
+// readMP3 reads the ID3v2 tag, the ID3v1 tag as a fallback and the duration
+func (r *audioReader) readMP3() error {
+	audioStart := int64(0)
+
+	var header [10]byte
+	if _, err := r.f.ReadAt(header[:], 0); err == nil && string(header[:3]) == "ID3" {
+		size := int64(syncsafe(header[6:10]))
+		audioStart = 10 + size
+		if header[5]&0x10 != 0 {
+			audioStart += 10 // Footer
+		}
+		tag, err := r.readBlock(10, size)
+		if err != nil {
+			return err
+		}
+		r.parseID3v2(header[3], header[5], tag)
+	}
+
+	audioEnd := r.size
+	var v1 [128]byte
+	if r.size >= 128 {
+		if _, err := r.f.ReadAt(v1[:], r.size-128); err == nil && string(v1[:3]) == "TAG" {
+			audioEnd -= 128
+			if r.info.Title == "" && r.info.Artist == "" {
+				r.parseID3v1(v1[:])
+			}
+		}
+	}
+
+	r.info.Duration = r.mp3Duration(audioStart, audioEnd)
+	return nil
+}
+
+// syncsafe decodes a 28 bit integer stored in 7 bits per byte
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// removeUnsync undoes ID3 unsynchronisation, which inserts 0x00 after every 0xFF
+func removeUnsync(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+// parseID3v2 reads the frames of an ID3v2.2, 2.3 or 2.4 tag
+func (r *audioReader) parseID3v2(version, flags byte, tag []byte) {
+	if version < 2 || version > 4 {
+		return
+	}
+	if flags&0x80 != 0 && version < 4 {
+		tag = removeUnsync(tag) // Version 2.4 marks unsynchronisation per frame
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && version >= 3 && len(tag) >= 4 {
+		// Skip the extended header
+		if version == 4 {
+			pos = int(syncsafe(tag[:4]))
+		} else {
+			pos = int(binary.BigEndian.Uint32(tag[:4])) + 4
+		}
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	for pos+headerLen <= len(tag) {
+		id := string(tag[pos : pos+idLen])
+		if id[0] == 0 {
+			break // Padding
+		}
+
+		var size int
+		var frameFlags byte
+		switch version {
+		case 2:
+			size = int(tag[pos+3])<<16 | int(tag[pos+4])<<8 | int(tag[pos+5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(tag[pos+4:]))
+			frameFlags = tag[pos+9]
+		case 4:
+			size = int(syncsafe(tag[pos+4:]))
+			frameFlags = tag[pos+9]
+		}
+		pos += headerLen
+		if size < 0 || pos+size > len(tag) {
+			break
+		}
+		data := tag[pos : pos+size]
+		pos += size
+
+		// Compressed and encrypted frames are not supported
+		if (version == 3 && frameFlags&0xC0 != 0) || (version == 4 && frameFlags&0x0C != 0) {
+			continue
+		}
+		if version == 4 {
+			if frameFlags&0x01 != 0 && len(data) >= 4 {
+				data = data[4:] // Data length indicator
+			}
+			if frameFlags&0x02 != 0 {
+				data = removeUnsync(data)
+			}
+		}
+
+		switch {
+		case id == "APIC" || id == "PIC":
+			r.parseID3Picture(id == "PIC", data)
+		case id3Frames[id] != "" && len(data) > 0:
+			r.setText(id3Frames[id], decodeID3Text(data[0], data[1:]))
+		}
+	}
+}
+
+// parseID3Picture reads an APIC frame, or a PIC frame of ID3v2.2
+func (r *audioReader) parseID3Picture(v22 bool, data []byte) {
+	if len(data) < 2 {
+		return
+	}
+	enc := data[0]
+	data = data[1:]
+
+	var mimeType string
+	if v22 {
+		if len(data) < 3 {
+			return
+		}
+		mimeType = "image/" + strings.ToLower(string(data[:3])) // "JPG" or "PNG"
+		if mimeType == "image/jpg" {
+			mimeType = "image/jpeg"
+		}
+		data = data[3:]
+	} else {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return
+		}
+		mimeType = strings.ToLower(string(data[:end]))
+		data = data[end+1:]
+	}
+	if len(data) < 1 {
+		return
+	}
+	pictureType := int(data[0])
+	_, rest := splitID3Text(enc, data[1:]) // Description
+	r.setCover(pictureType, mimeType, rest)
+}
+
+// splitID3Text splits a terminated string in the given encoding from the data after it
+func splitID3Text(enc byte, data []byte) (text, rest []byte) {
+	if enc == 1 || enc == 2 {
+		// UTF-16 strings end with two zero bytes at an even offset
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return data[:i], data[i+2:]
+			}
+		}
+		return data, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return data[:i], data[i+1:]
+	}
+	return data, nil
+}
+
+// decodeID3Text decodes a text frame. Version 2.4 separates multiple values with
+// zero bytes; only the first is used.
+func decodeID3Text(enc byte, data []byte) string {
+	text, _ := splitID3Text(enc, data)
+	switch enc {
+	case 1: // UTF-16 with byte order mark
+		if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+			return decodeUTF16(text[2:], binary.BigEndian)
+		}
+		if len(text) >= 2 && text[0] == 0xFF && text[1] == 0xFE {
+			text = text[2:]
+		}
+		return decodeUTF16(text, binary.LittleEndian)
+	case 2: // UTF-16BE
+		return decodeUTF16(text, binary.BigEndian)
+	case 3: // UTF-8
+		return string(text)
+	default: // ISO-8859-1
+		return decodeLatin1(text)
+	}
+}
+
+// decodeUTF16 decodes UTF-16 text in the given byte order
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// decodeLatin1 decodes ISO-8859-1 text, whose bytes are the first 256 code points
+func decodeLatin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// parseID3v1 reads the fixed size tag at the end of MP3 files
+func (r *audioReader) parseID3v1(tag []byte) {
+	field := func(b []byte) string {
+		return decodeLatin1(bytes.TrimRight(b, "\x00 "))
+	}
+	r.setText("title", field(tag[3:33]))
+	r.setText("artist", field(tag[33:63]))
+	r.setText("album", field(tag[63:93]))
+	r.setText("year", field(tag[93:97]))
+	if tag[125] == 0 && tag[126] != 0 {
+		r.info.Track = int(tag[126]) // ID3v1.1
+	}
+}
+
+// MPEG audio bitrates in kbit/s by version (1 or 2/2.5), layer and index
+var mpegBitrates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// MPEG audio sample rates by version (1, 2, 2.5) and index
+var mpegSampleRates = [3][3]int{{44100, 48000, 32000}, {22050, 24000, 16000}, {11025, 12000, 8000}}
+
+// mp3Duration finds the first MPEG frame and computes the duration from its Xing or
+// VBRI header, or from the bitrate for constant bitrate files
+func (r *audioReader) mp3Duration(start, end int64) float64 {
+	buf := make([]byte, min(mp3SyncSearch, max(end-start, 0)))
+	n, _ := r.f.ReadAt(buf, start)
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		h := binary.BigEndian.Uint32(buf[i:])
+		versionBits := (h >> 19) & 3 // 0: 2.5, 2: 2, 3: 1
+		layerBits := (h >> 17) & 3   // 1: III, 2: II, 3: I
+		bitrateIndex := (h >> 12) & 15
+		rateIndex := (h >> 10) & 3
+		if versionBits == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		version := 0 // Index into the tables: MPEG 1, 2 or 2.5
+		switch versionBits {
+		case 2:
+			version = 1
+		case 0:
+			version = 2
+		}
+		layer := 3 - int(layerBits) // 0: I, 1: II, 2: III
+		bitrate := mpegBitrates[min(version, 1)][layer][bitrateIndex] * 1000
+		sampleRate := mpegSampleRates[version][rateIndex]
+
+		samplesPerFrame := 1152
+		switch {
+		case layer == 0:
+			samplesPerFrame = 384
+		case layer == 2 && version > 0:
+			samplesPerFrame = 576
+		}
+
+		// The Xing/Info header follows the side information of the first frame
+		mono := (h>>6)&3 == 3
+		sideInfo := 32
+		switch {
+		case version == 0 && mono:
+			sideInfo = 17
+		case version > 0 && !mono:
+			sideInfo = 17
+		case version > 0 && mono:
+			sideInfo = 9
+		}
+		frame := buf[i:]
+		if x := 4 + sideInfo; len(frame) >= x+12 {
+			tag := string(frame[x : x+4])
+			if (tag == "Xing" || tag == "Info") && binary.BigEndian.Uint32(frame[x+4:])&1 != 0 {
+				frames := binary.BigEndian.Uint32(frame[x+8:])
+				return float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+			}
+		}
+		if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+			frames := binary.BigEndian.Uint32(frame[36+14:])
+			return float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+		}
+
+		return float64(end-start-int64(i)) * 8 / float64(bitrate)
+	}
+	return 0
+}
+
+// readFLAC reads the metadata blocks of a FLAC file
+func (r *audioReader) readFLAC() error {
+	pos := int64(4)
+	for {
+		var header [4]byte
+		if _, err := r.f.ReadAt(header[:], pos); err != nil {
+			return nil
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		pos += 4
+
+		switch blockType {
+		case 0: // STREAMINFO
+			block, err := r.readBlock(pos, size)
+			if err != nil || len(block) < 18 {
+				return err
+			}
+			bits := binary.BigEndian.Uint64(block[10:18])
+			sampleRate := bits >> 44
+			samples := bits & (1<<36 - 1)
+			if sampleRate > 0 {
+				r.info.Duration = float64(samples) / float64(sampleRate)
+			}
+		case 4: // VORBIS_COMMENT
+			block, err := r.readBlock(pos, size)
+			if err != nil {
+				return err
+			}
+			r.parseVorbisComments(block)
+		case 6: // PICTURE
+			if !r.wantCover && r.info.Cover {
+				break
+			}
+			block, err := r.readBlock(pos, size)
+			if err != nil {
+				return err
+			}
+			r.parseFLACPicture(block)
+		}
+
+		pos += size
+		if last || blockType == 127 {
+			return nil
+		}
+	}
+}
+
+// parseFLACPicture reads a FLAC picture block, also used for Ogg cover art
+func (r *audioReader) parseFLACPicture(block []byte) {
+	next := func() (uint32, bool) {
+		if len(block) < 4 {
+			return 0, false
+		}
+		v := binary.BigEndian.Uint32(block)
+		block = block[4:]
+		return v, true
+	}
+	field := func() ([]byte, bool) {
+		n, ok := next()
+		if !ok || int(n) > len(block) {
+			return nil, false
+		}
+		v := block[:n]
+		block = block[n:]
+		return v, true
+	}
+
+	pictureType, ok := next()
+	if !ok {
+		return
+	}
+	mimeType, ok1 := field()
+	_, ok2 := field() // Description
+	if !ok1 || !ok2 || len(block) < 16 {
+		return
+	}
+	block = block[16:] // Width, height, depth and colors
+	data, ok := field()
+	if !ok {
+		return
+	}
+	r.setCover(int(pictureType), string(mimeType), data)
+}
+
+// vorbisFields maps Vorbis comment names to our fields
+var vorbisFields = map[string]string{
+	"TITLE": "title", "ARTIST": "artist", "ALBUM": "album", "TRACKNUMBER": "track", "DATE": "year", "YEAR": "year",
+}
+
+// parseVorbisComments reads a Vorbis comment block as used by FLAC, Vorbis and Opus
+func (r *audioReader) parseVorbisComments(block []byte) {
+	next := func() ([]byte, bool) {
+		if len(block) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(block)
+		if int64(n) > int64(len(block)-4) {
+			return nil, false
+		}
+		v := block[4 : 4+n]
+		block = block[4+n:]
+		return v, true
+	}
+
+	if _, ok := next(); !ok { // Vendor
+		return
+	}
+	if len(block) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(block)
+	block = block[4:]
+
+	var coverArt, coverMime string
+	for i := uint32(0); i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+		key, value, ok := strings.Cut(string(comment), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToUpper(key)
+
+		switch key {
+		case "METADATA_BLOCK_PICTURE":
+			if !r.wantCover && r.info.Cover {
+				continue
+			}
+			if data, err := base64.StdEncoding.DecodeString(value); err == nil {
+				r.parseFLACPicture(data)
+			}
+		case "COVERART": // Older, unofficial form
+			coverArt = value
+		case "COVERARTMIME":
+			coverMime = value
+		default:
+			// The first value wins when a field is repeated, e.g. several artists
+			if field := vorbisFields[key]; field != "" && !r.hasField(field) {
+				r.setText(field, value)
+			}
+		}
+	}
+
+	if coverArt != "" && !r.info.Cover {
+		if data, err := base64.StdEncoding.DecodeString(coverArt); err == nil {
+			r.setCover(coverFrontType, coverMime, data)
+		}
+	}
+}
+
+// hasField reports whether a field already has a value
+func (r *audioReader) hasField(field string) bool {
+	switch field {
+	case "title":
+		return r.info.Title != ""
+	case "artist":
+		return r.info.Artist != ""
+	case "album":
+		return r.info.Album != ""
+	case "track":
+		return r.info.Track != 0
+	case "year":
+		return r.info.Year != 0
+	}
+	return false
+}
+
+// readOgg reads the identification and comment headers of an Ogg Vorbis or Opus
+// stream and the duration from the granule position of the last page
+func (r *audioReader) readOgg() error {
+	var packets [][]byte
+	var packet []byte
+	pos := int64(0)
+
+	for len(packets) < 2 {
+		var header [27]byte
+		if _, err := r.f.ReadAt(header[:], pos); err != nil || string(header[:4]) != "OggS" {
+			break
+		}
+		segments := make([]byte, header[26])
+		if _, err := r.f.ReadAt(segments, pos+27); err != nil {
+			break
+		}
+		pos += 27 + int64(len(segments))
+
+		for _, seg := range segments {
+			data, err := r.readBlock(pos, int64(seg))
+			if err != nil {
+				return err
+			}
+			pos += int64(seg)
+			if len(packet)+len(data) > maxTagSize {
+				return fmt.Errorf("Ogg header packet too large")
+			}
+			packet = append(packet, data...)
+			if seg < 255 {
+				packets = append(packets, packet)
+				packet = nil
+				if len(packets) == 2 {
+					break
+				}
+			}
+		}
+	}
+	if len(packets) < 2 {
+		return errNoTags
+	}
+
+	var sampleRate, preSkip int64
+	ident, comments := packets[0], packets[1]
+	switch {
+	case len(ident) >= 16 && string(ident[:7]) == "\x01vorbis":
+		sampleRate = int64(binary.LittleEndian.Uint32(ident[12:]))
+		if bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			r.parseVorbisComments(comments[7:])
+		}
+	case len(ident) >= 12 && string(ident[:8]) == "OpusHead":
+		sampleRate = 48000 // Opus granule positions always count 48 kHz samples
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:]))
+		if bytes.HasPrefix(comments, []byte("OpusTags")) {
+			r.parseVorbisComments(comments[8:])
+		}
+	default:
+		return errNoTags
+	}
+
+	// The granule position of the last page is the number of samples
+	tailSize := min(oggTailSize, r.size)
+	tail := make([]byte, tailSize)
+	if _, err := r.f.ReadAt(tail, r.size-tailSize); err == nil && sampleRate > 0 {
+		if i := bytes.LastIndex(tail, []byte("OggS")); i >= 0 && i+14 <= len(tail) {
+			granule := int64(binary.LittleEndian.Uint64(tail[i+6:]))
+			if granule > preSkip {
+				r.info.Duration = float64(granule-preSkip) / float64(sampleRate)
+			}
+		}
+	}
+	return nil
+}
+
+// mp4Atom is the position of an atom's payload in the file
+type mp4Atom struct {
+	typ   string
+	start int64 // First byte after the header
+	end   int64
+}
+
+// mp4Children lists the atoms inside a payload
+func (r *audioReader) mp4Children(start, end int64) []mp4Atom {
+	var atoms []mp4Atom
+	for pos := start; pos+8 <= end; {
+		var header [16]byte
+		if _, err := r.f.ReadAt(header[:8], pos); err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerLen := int64(8)
+		switch size {
+		case 0: // Extends to the end of the parent
+			size = end - pos
+		case 1: // 64 bit size
+			if _, err := r.f.ReadAt(header[8:16], pos+8); err != nil {
+				return atoms
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen || pos+size > end {
+			break
+		}
+		atoms = append(atoms, mp4Atom{typ: string(header[4:8]), start: pos + headerLen, end: pos + size})
+		pos += size
+	}
+	return atoms
+}
+
+// mp4Find returns the first child atom of a type
+func (r *audioReader) mp4Find(start, end int64, typ string) (mp4Atom, bool) {
+	for _, a := range r.mp4Children(start, end) {
+		if a.typ == typ {
+			return a, true
+		}
+	}
+	return mp4Atom{}, false
+}
+
+// mp4Items maps iTunes metadata atoms to our fields
+var mp4Items = map[string]string{
+	"\xa9nam": "title", "\xa9ART": "artist", "\xa9alb": "album", "\xa9day": "year",
+}
+
+// readMP4 reads the iTunes metadata and duration of an MP4 audio file
+func (r *audioReader) readMP4() error {
+	moov, ok := r.mp4Find(0, r.size, "moov")
+	if !ok {
+		return errNoTags
+	}
+
+	if mvhd, ok := r.mp4Find(moov.start, moov.end, "mvhd"); ok {
+		if data, err := r.readBlock(mvhd.start, min(mvhd.end-mvhd.start, 32)); err == nil && len(data) >= 20 {
+			var timescale, duration uint64
+			if data[0] == 1 && len(data) >= 32 {
+				timescale = uint64(binary.BigEndian.Uint32(data[20:]))
+				duration = binary.BigEndian.Uint64(data[24:])
+			} else {
+				timescale = uint64(binary.BigEndian.Uint32(data[12:]))
+				duration = uint64(binary.BigEndian.Uint32(data[16:]))
+			}
+			if timescale > 0 {
+				r.info.Duration = float64(duration) / float64(timescale)
+			}
+		}
+	}
+
+	udta, ok := r.mp4Find(moov.start, moov.end, "udta")
+	if !ok {
+		return nil
+	}
+	meta, ok := r.mp4Find(udta.start, udta.end, "meta")
+	if !ok {
+		return nil
+	}
+	// meta is a full box with 4 bytes of version and flags, except in some QuickTime files
+	var probe [8]byte
+	if _, err := r.f.ReadAt(probe[:], meta.start); err == nil && string(probe[4:8]) != "hdlr" {
+		meta.start += 4
+	}
+	ilst, ok := r.mp4Find(meta.start, meta.end, "ilst")
+	if !ok {
+		return nil
+	}
+
+	for _, item := range r.mp4Children(ilst.start, ilst.end) {
+		field := mp4Items[item.typ]
+		switch {
+		case item.typ == "trkn":
+		case item.typ == "covr":
+			if !r.wantCover && r.info.Cover {
+				continue
+			}
+		case field == "":
+			continue
+		}
+
+		data, ok := r.mp4Find(item.start, item.end, "data")
+		if !ok || data.end-data.start < 8 {
+			continue
+		}
+		value, err := r.readBlock(data.start, data.end-data.start)
+		if err != nil {
+			continue
+		}
+		dataType := binary.BigEndian.Uint32(value[:4]) & 0xFFFFFF
+		value = value[8:] // Type and locale
+
+		switch item.typ {
+		case "trkn":
+			if len(value) >= 4 {
+				r.info.Track = int(binary.BigEndian.Uint16(value[2:]))
+			}
+		case "covr":
+			mimeType := ""
+			switch dataType {
+			case 13:
+				mimeType = "image/jpeg"
+			case 14:
+				mimeType = "image/png"
+			}
+			r.setCover(coverFrontType, mimeType, value)
+		default:
+			r.setText(field, string(value))
+		}
+	}
+	return nil
+}
+
+// readWAV computes the duration of a WAV file from its format and data chunks
+func (r *audioReader) readWAV() error {
+	var byteRate, dataSize int64
+	for pos := int64(12); pos+8 <= r.size; {
+		var header [8]byte
+		if _, err := r.f.ReadAt(header[:], pos); err != nil {
+			break
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+		switch string(header[:4]) {
+		case "fmt ":
+			var format [12]byte
+			if _, err := r.f.ReadAt(format[:], pos+8); err == nil {
+				byteRate = int64(binary.LittleEndian.Uint32(format[8:]))
+			}
+		case "data":
+			dataSize = min(size, r.size-pos-8)
+		}
+		pos += 8 + size + size%2 // Chunks are padded to even sizes
+	}
+	if byteRate > 0 {
+		r.info.Duration = float64(dataSize) / float64(byteRate)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// File: audio_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+// id3v23 builds an ID3v2.3 tag from frame IDs and Latin-1 texts
+func id3v23(frames ...string) []byte {
+	var body bytes.Buffer
+	for i := 0; i+1 < len(frames); i += 2 {
+		body.WriteString(frames[i])
+		binary.Write(&body, binary.BigEndian, uint32(len(frames[i+1])+1))
+		body.Write([]byte{0, 0, 0}) // Flags and Latin-1 encoding
+		body.WriteString(frames[i+1])
+	}
+	size := body.Len()
+	tag := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(tag, body.Bytes()...)
+}
+
+// flacFile builds a FLAC file with a Vorbis comment block
+func flacFile(comments ...string) []byte {
+	var block bytes.Buffer
+	binary.Write(&block, binary.LittleEndian, uint32(4))
+	block.WriteString("test")
+	binary.Write(&block, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(&block, binary.LittleEndian, uint32(len(c)))
+		block.WriteString(c)
+	}
+	size := block.Len()
+	data := []byte{'f', 'L', 'a', 'C', 0x84, byte(size >> 16), byte(size >> 8), byte(size)}
+	return append(data, block.Bytes()...)
+}
+
+func TestReadAudioTags(t *testing.T) {
+	id3 := id3v23("TIT2", "Song", "TPE1", "Band", "TRCK", "3/12", "TYER", "2019")
+	flac := flacFile("TITLE=Song", "ARTIST=Band", "ARTIST=Other", "TRACKNUMBER=3", "DATE=2019-05-03")
+	expected := AudioInfo{Title: "Song", Artist: "Band", Track: 3, Year: 2019}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected *AudioInfo // nil when an error is expected
+	}{
+		{"id3v2", id3, &expected},
+		{"flac", flac, &expected},
+		{"empty", nil, nil},
+		{"unknown format", []byte("not an audio file"), nil},
+		{"id3 header only", id3[:10], nil},
+		{"id3 size past end", id3[:len(id3)-3], nil},
+		{"id3 huge size", []byte{'I', 'D', '3', 3, 0, 0, 0x7F, 0x7F, 0x7F, 0x7F}, nil},
+		{"id3 unknown version", append([]byte{'I', 'D', '3', 9}, id3[4:]...), nil},
+		{"id3 frame size past tag", append(id3[:10:10], 'T', 'I', 'T', '2', 0x7F, 0, 0, 0, 0, 0), nil},
+		{"flac magic only", []byte("fLaC"), nil},
+		{"flac block past end", flac[:len(flac)-5], nil},
+		{"flac comment count too large", flacFile()[:len(flacFile())-4], nil},
+		{"ogg magic only", []byte("OggS"), nil},
+		{"mp4 atom only", []byte("\x00\x00\x00\x08ftyp"), nil},
+		{"wav header only", []byte("RIFF\x04\x00\x00\x00WAVE"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := readAudioTags(writeTestFile(t, "audio", tt.data))
+			if tt.expected == nil {
+				if err == nil {
+					t.Errorf("readAudioTags() = %+v, want an error", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readAudioTags() error = %v", err)
+			}
+			if *info != *tt.expected {
+				t.Errorf("readAudioTags() = %+v, want %+v", *info, *tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadAudioTruncated(t *testing.T) {
+	// Every prefix of a valid file must be read without panicking
+	for _, data := range [][]byte{
+		id3v23("TIT2", "Song", "APIC", "image/png\x00\x03\x00cover"),
+		flacFile("TITLE=Song", "METADATA_BLOCK_PICTURE=AAAAAw=="),
+	} {
+		p := writeTestFile(t, "audio", nil)
+		for n := range len(data) {
+			if err := os.WriteFile(p, data[:n], 0644); err != nil {
+				t.Fatal(err)
+			}
+			readAudioTags(p)
+			readAudioCover(p)
+		}
+	}
+}
+
+func TestReadAudioCover(t *testing.T) {
+	p := writeTestFile(t, "a.mp3", id3v23("TIT2", "Song", "APIC", "image/png\x00\x03\x00cover"))
+	cover, err := readAudioCover(p)
+	if err != nil {
+		t.Fatalf("readAudioCover() error = %v", err)
+	}
+	if cover.MimeType != "image/png" || string(cover.Data) != "cover" {
+		t.Errorf("readAudioCover() = %q %q, want image/png cover", cover.MimeType, cover.Data)
+	}
+
+	info, err := readAudioTags(p)
+	if err != nil || !info.Cover {
+		t.Errorf("readAudioTags() = %+v, %v, want a cover", info, err)
+	}
+
+	if _, err := readAudioCover(writeTestFile(t, "b.mp3", id3v23("TIT2", "Song"))); err == nil {
+		t.Error("readAudioCover() without a picture returned no error")
+	}
+}
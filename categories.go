@@ -0,0 +1,249 @@
+// File: categories.go
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Category views understood by the frontend
+const (
+	ViewCards = "cards" // A grid of previews
+	ViewTable = "table" // A sortable table
+)
+
+// fallbackCategory receives every file no other category claims
+const fallbackCategory = "other"
+
+// Category describes one file type shown in the navigation
+type Category struct {
+	Name         string   `json:"name"`                    // Identifier used in the API and the URL
+	Label        string   `json:"label"`                   // Text shown in the navigation
+	Icon         string   `json:"icon,omitempty"`          // Emoji or short text shown before the label
+	Extensions   []string `json:"extensions,omitempty"`    // File extensions without the dot
+	MimePrefixes []string `json:"mime_prefixes,omitempty"` // Detected MIME types, matched by prefix
+	MimeFallback bool     `json:"mime_fallback,omitempty"` // Only use MimePrefixes for files without an extension
+	View         string   `json:"view,omitempty"`          // "cards" or "table" (default)
+}
+
+// DefaultCategories returns the built-in categories in navigation order
+func DefaultCategories() []Category {
+	return []Category{
+		{
+			Name:         "image",
+			Label:        "Images",
+			Icon:         "🖼️",
+			Extensions:   []string{"jpg", "jpeg", "png", "gif", "bmp", "webp", "svg", "ico", "jfif", "heic", "heif", "avif", "tif", "tiff"},
+			MimePrefixes: []string{"image/"},
+			View:         ViewCards,
+		},
+		{
+			Name:         "video",
+			Label:        "Videos",
+			Icon:         "🎬",
+			Extensions:   []string{"mp4", "webm", "mkv", "mpeg", "3gp", "mov", "avi", "m4v"},
+			MimePrefixes: []string{"video/"},
+			View:         ViewCards,
+		},
+		{
+			Name:         "audio",
+			Label:        "Audio",
+			Icon:         "🎵",
+			Extensions:   []string{"mp3", "wav", "ogg", "flac", "aac", "opus", "m4a"},
+			MimePrefixes: []string{"audio/"},
+			View:         ViewTable,
+		},
+		{
+			Name:         "text",
+			Label:        "Text Docs",
+			Icon:         "📄",
+			Extensions:   []string{"txt", "md", "log"},
+			MimePrefixes: []string{"text/"},
+			MimeFallback: true,
+			View:         ViewCards,
+		},
+		{
+			Name:       "code",
+			Label:      "Code Files",
+			Icon:       "💻",
+			Extensions: []string{"go", "py", "c", "cpp", "h", "js", "ts", "html", "css", "sh", "java", "rs", "gd"},
+			View:       ViewCards,
+		},
+		{
+			Name:         "pdf",
+			Label:        "PDFs",
+			Icon:         "📕",
+			Extensions:   []string{"pdf"},
+			MimePrefixes: []string{"application/pdf"},
+			View:         ViewCards,
+		},
+		{
+			Name:         "archive",
+			Label:        "Archives",
+			Icon:         "📦",
+			Extensions:   []string{"zip", "rar", "7z", "gz", "tgz"},
+			MimePrefixes: []string{"application/zip", "application/x-gzip", "application/x-rar-compressed", "application/x-7z-compressed"},
+			MimeFallback: true,
+			View:         ViewTable,
+		},
+		{
+			Name:  fallbackCategory,
+			Label: "Other",
+			Icon:  "📁",
+			View:  ViewTable,
+		},
+	}
+}
+
+// validCategoryName limits names to what is safe in URLs, CSS selectors and inline handlers
+var validCategoryName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// CategoryRegistry maps extensions and MIME types to categories
+type CategoryRegistry struct {
+	list   []Category
+	byName map[string]*Category
+	byExt  map[string]string // Lowercase extension -> category name
+}
+
+// categories is the registry used to categorize files, replaced by SetCategories at startup
+var categories = mustCategories(DefaultCategories())
+
+// NewCategoryRegistry validates a list of categories. The fallback category "other"
+// is appended when it is missing.
+func NewCategoryRegistry(list []Category) (*CategoryRegistry, error) {
+	reg := &CategoryRegistry{
+		byName: make(map[string]*Category),
+		byExt:  make(map[string]string),
+	}
+
+	hasFallback := false
+	for _, c := range list {
+		if c.Name == fallbackCategory {
+			hasFallback = true
+		}
+	}
+	if !hasFallback {
+		for _, c := range DefaultCategories() {
+			if c.Name == fallbackCategory {
+				list = append(list, c)
+			}
+		}
+	}
+
+	reg.list = make([]Category, 0, len(list))
+	for _, c := range list {
+		if !validCategoryName.MatchString(c.Name) {
+			return nil, fmt.Errorf("invalid category name %q: use lowercase letters, digits, '-' and '_'", c.Name)
+		}
+		if reg.byName[c.Name] != nil {
+			return nil, fmt.Errorf("duplicate category %q", c.Name)
+		}
+		switch c.View {
+		case "":
+			c.View = ViewTable
+		case ViewCards, ViewTable:
+		default:
+			return nil, fmt.Errorf("category %q: unknown view %q", c.Name, c.View)
+		}
+		if c.Label == "" {
+			c.Label = c.Name
+		}
+
+		c.Extensions = slices.Clone(c.Extensions)
+		for i, ext := range c.Extensions {
+			ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+			c.Extensions[i] = ext
+			if other, ok := reg.byExt[ext]; ok {
+				log.Printf("Extension %q is in categories %q and %q, using %q", ext, other, c.Name, other)
+				continue
+			}
+			reg.byExt[ext] = c.Name
+		}
+
+		reg.list = append(reg.list, c)
+		reg.byName[c.Name] = &reg.list[len(reg.list)-1]
+	}
+
+	return reg, nil
+}
+
+func mustCategories(list []Category) *CategoryRegistry {
+	reg, err := NewCategoryRegistry(list)
+	if err != nil {
+		panic(err)
+	}
+	return reg
+}
+
+// SetCategories replaces the registry used to categorize files
+func SetCategories(list []Category) error {
+	reg, err := NewCategoryRegistry(list)
+	if err != nil {
+		return err
+	}
+	categories = reg
+	return nil
+}
+
+// List returns the categories in navigation order
+func (reg *CategoryRegistry) List() []Category {
+	return reg.list
+}
+
+// Names returns the category names in navigation order
+func (reg *CategoryRegistry) Names() []string {
+	names := make([]string, len(reg.list))
+	for i, c := range reg.list {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Has reports whether a category exists
+func (reg *CategoryRegistry) Has(name string) bool {
+	return reg.byName[name] != nil
+}
+
+// ForExtension returns the category for a file extension
+func (reg *CategoryRegistry) ForExtension(ext string) string {
+	if name, ok := reg.byExt[strings.ToLower(ext)]; ok {
+		return name
+	}
+	return fallbackCategory
+}
+
+// ForMimeType returns the category whose longest MIME prefix matches, or nil
+func (reg *CategoryRegistry) ForMimeType(mimeType string) *Category {
+	var best *Category
+	bestLen := 0
+	for i := range reg.list {
+		for _, prefix := range reg.list[i].MimePrefixes {
+			if len(prefix) > bestLen && strings.HasPrefix(mimeType, prefix) {
+				best, bestLen = &reg.list[i], len(prefix)
+			}
+		}
+	}
+	return best
+}
+
+// Fingerprint identifies the mapping, so stored entries are recategorized when it changes.
+// Labels, icons and views only affect the interface and are left out.
+func (reg *CategoryRegistry) Fingerprint() string {
+	type mapping struct {
+		Name         string
+		Extensions   []string
+		MimePrefixes []string
+		MimeFallback bool
+	}
+	mappings := make([]mapping, len(reg.list))
+	for i, c := range reg.list {
+		mappings[i] = mapping{c.Name, c.Extensions, c.MimePrefixes, c.MimeFallback}
+	}
+	data, _ := json.Marshal(mappings)
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
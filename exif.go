@@ -0,0 +1,459 @@
+// File: exif.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// ExifInfo is the camera metadata of an image
+type ExifInfo struct {
+	Taken       *time.Time `json:"taken,omitempty"`        // DateTimeOriginal, falling back to DateTime
+	Make        string     `json:"make,omitempty"`         // Camera manufacturer
+	Model       string     `json:"model,omitempty"`        // Camera model
+	Lens        string     `json:"lens,omitempty"`         // Lens model
+	Exposure    float64    `json:"exposure,omitempty"`     // Exposure time in seconds
+	FNumber     float64    `json:"fnumber,omitempty"`      // Aperture
+	ISO         int        `json:"iso,omitempty"`          // ISO speed
+	FocalLength float64    `json:"focal_length,omitempty"` // Focal length in mm
+	Orientation int        `json:"orientation,omitempty"`  // 1-8 as defined by EXIF, 0 when unknown
+	GPS         *GPSInfo   `json:"gps,omitempty"`
+}
+
+// GPSInfo is a position in decimal degrees
+type GPSInfo struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	Alt float64 `json:"alt,omitempty"` // Meters above sea level
+}
+
+// TIFF tags read by the parser
+const (
+	tagMake              = 0x010F
+	tagModel             = 0x0110
+	tagOrientation       = 0x0112
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagExposureTime      = 0x829A
+	tagFNumber           = 0x829D
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagOffsetTimeOrig    = 0x9011
+	tagFocalLength       = 0x920A
+	tagLensModel         = 0xA434
+	tagGPSLatitudeRef    = 0x0001
+	tagGPSLatitude       = 0x0002
+	tagGPSLongitudeRef   = 0x0003
+	tagGPSLongitude      = 0x0004
+	tagGPSAltitudeRef    = 0x0005
+	tagGPSAltitude       = 0x0006
+	maxTIFFValueSize     = 64 << 10 // Larger values are never metadata we use
+	maxTIFFEntries       = 1000     // Directories with more entries are corrupt
+	maxJPEGSegmentSearch = 64       // Segments inspected before giving up on a JPEG
+)
+
+// errNoExif is returned for files without EXIF data
+var errNoExif = errors.New("no EXIF data")
+
+// readExif extracts EXIF metadata from a JPEG, TIFF based RAW, PNG or WebP file
+func readExif(fullPath string) (*ExifInfo, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var head [16]byte
+	n, _ := io.ReadFull(file, head[:])
+
+	var tiff io.ReaderAt
+	switch {
+	case n >= 3 && bytes.HasPrefix(head[:], []byte{0xFF, 0xD8, 0xFF}):
+		data, err := jpegExif(file)
+		if err != nil {
+			return nil, err
+		}
+		tiff = bytes.NewReader(data)
+	case n >= 4 && (string(head[:2]) == "II" || string(head[:2]) == "MM"):
+		// TIFF and the RAW formats built on it (CR2, NEF, ARW, DNG, ORF, RW2)
+		tiff = file
+	case n >= 8 && bytes.HasPrefix(head[:], []byte("\x89PNG\r\n\x1a\n")):
+		data, err := pngExif(file)
+		if err != nil {
+			return nil, err
+		}
+		tiff = bytes.NewReader(data)
+	case n >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		data, err := webpExif(file)
+		if err != nil {
+			return nil, err
+		}
+		tiff = bytes.NewReader(data)
+	default:
+		return nil, errNoExif
+	}
+
+	return parseTIFF(tiff)
+}
+
+// jpegExif returns the TIFF block of the APP1 Exif segment
+func jpegExif(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(2, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var marker [4]byte
+	for i := 0; i < maxJPEGSegmentSearch; i++ {
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, errNoExif
+		}
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker %x", marker[:2])
+		}
+		// Metadata segments come before the image data
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, errNoExif
+		}
+		size := int64(binary.BigEndian.Uint16(marker[2:])) - 2
+		if size < 0 {
+			return nil, fmt.Errorf("invalid JPEG segment size")
+		}
+
+		if marker[1] == 0xE1 && size > 6 {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+				return data[6:], nil
+			}
+			continue // XMP or another APP1 segment
+		}
+		if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errNoExif
+}
+
+// pngExif returns the contents of the eXIf chunk
+func pngExif(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(8, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, errNoExif
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:]) {
+		case "eXIf":
+			if size > maxTIFFValueSize*4 {
+				return nil, fmt.Errorf("eXIf chunk too large")
+			}
+			data := make([]byte, size)
+			_, err := io.ReadFull(r, data)
+			return data, err
+		case "IDAT", "IEND":
+			// eXIf must precede the image data
+			return nil, errNoExif
+		}
+		if _, err := r.Seek(size+4, io.SeekCurrent); err != nil { // Data and CRC
+			return nil, err
+		}
+	}
+}
+
+// webpExif returns the contents of the EXIF chunk of an extended WebP file
+func webpExif(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, errNoExif
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+		if string(header[:4]) == "EXIF" {
+			if size > maxTIFFValueSize*4 {
+				return nil, fmt.Errorf("EXIF chunk too large")
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			// Some writers keep the JPEG style prefix
+			return bytes.TrimPrefix(data, []byte("Exif\x00\x00")), nil
+		}
+		if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil { // Chunks are padded to even sizes
+			return nil, err
+		}
+	}
+}
+
+// tiffEntry is one directory entry with its raw value
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiffReader reads directories from a TIFF structure
+type tiffReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+}
+
+// typeSize returns the size in bytes of one value of a TIFF type
+func typeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	}
+	return 0
+}
+
+// readIFD returns the entries of the directory at offset
+func (t *tiffReader) readIFD(offset uint32) (map[uint16]tiffEntry, error) {
+	var countBuf [2]byte
+	if _, err := t.r.ReadAt(countBuf[:], int64(offset)); err != nil {
+		return nil, err
+	}
+	count := int(t.order.Uint16(countBuf[:]))
+	if count > maxTIFFEntries {
+		return nil, fmt.Errorf("too many TIFF entries")
+	}
+
+	raw := make([]byte, count*12)
+	if _, err := t.r.ReadAt(raw, int64(offset)+2); err != nil {
+		return nil, err
+	}
+
+	entries := make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		e := raw[i*12 : i*12+12]
+		tag := t.order.Uint16(e[0:2])
+		entry := tiffEntry{typ: t.order.Uint16(e[2:4]), count: t.order.Uint32(e[4:8])}
+
+		size := int64(typeSize(entry.typ)) * int64(entry.count)
+		switch {
+		case size == 0 || size > maxTIFFValueSize:
+			continue
+		case size <= 4:
+			entry.value = e[8 : 8+size]
+		default:
+			entry.value = make([]byte, size)
+			if _, err := t.r.ReadAt(entry.value, int64(t.order.Uint32(e[8:12]))); err != nil {
+				continue
+			}
+		}
+		entries[tag] = entry
+	}
+	return entries, nil
+}
+
+// str returns an ASCII value without padding
+func (t *tiffReader) str(e tiffEntry) string {
+	s, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(s)
+}
+
+// uint returns the i-th integer of a BYTE, SHORT or LONG value
+func (t *tiffReader) uint(e tiffEntry, i int) (uint32, bool) {
+	switch e.typ {
+	case 1, 7:
+		if i < len(e.value) {
+			return uint32(e.value[i]), true
+		}
+	case 3:
+		if 2*i+2 <= len(e.value) {
+			return uint32(t.order.Uint16(e.value[2*i:])), true
+		}
+	case 4:
+		if 4*i+4 <= len(e.value) {
+			return t.order.Uint32(e.value[4*i:]), true
+		}
+	}
+	return 0, false
+}
+
+// rational returns the i-th value of a RATIONAL or SRATIONAL value
+func (t *tiffReader) rational(e tiffEntry, i int) (float64, bool) {
+	if (e.typ != 5 && e.typ != 10) || 8*i+8 > len(e.value) {
+		return 0, false
+	}
+	num, den := t.order.Uint32(e.value[8*i:]), t.order.Uint32(e.value[8*i+4:])
+	if den == 0 {
+		return 0, false
+	}
+	if e.typ == 10 {
+		return float64(int32(num)) / float64(int32(den)), true
+	}
+	return float64(num) / float64(den), true
+}
+
+// parseTIFF reads the metadata from a TIFF structure starting at offset 0 of r
+func parseTIFF(r io.ReaderAt) (*ExifInfo, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, errNoExif
+	}
+
+	t := &tiffReader{r: r}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errNoExif
+	}
+
+	ifd0, err := t.readIFD(t.order.Uint32(header[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ExifInfo{}
+	var dateTime, dateTimeOriginal, offsetTime string
+
+	if e, ok := ifd0[tagMake]; ok {
+		info.Make = t.str(e)
+	}
+	if e, ok := ifd0[tagModel]; ok {
+		info.Model = t.str(e)
+	}
+	if e, ok := ifd0[tagOrientation]; ok {
+		if v, ok := t.uint(e, 0); ok && v >= 1 && v <= 8 {
+			info.Orientation = int(v)
+		}
+	}
+	if e, ok := ifd0[tagDateTime]; ok {
+		dateTime = t.str(e)
+	}
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if offset, ok := t.uint(e, 0); ok {
+			if exif, err := t.readIFD(offset); err == nil {
+				if e, ok := exif[tagDateTimeOriginal]; ok {
+					dateTimeOriginal = t.str(e)
+				}
+				if e, ok := exif[tagOffsetTimeOrig]; ok {
+					offsetTime = t.str(e)
+				}
+				if e, ok := exif[tagExposureTime]; ok {
+					info.Exposure, _ = t.rational(e, 0)
+				}
+				if e, ok := exif[tagFNumber]; ok {
+					info.FNumber, _ = t.rational(e, 0)
+				}
+				if e, ok := exif[tagISO]; ok {
+					if v, ok := t.uint(e, 0); ok {
+						info.ISO = int(v)
+					}
+				}
+				if e, ok := exif[tagFocalLength]; ok {
+					info.FocalLength, _ = t.rational(e, 0)
+				}
+				if e, ok := exif[tagLensModel]; ok {
+					info.Lens = t.str(e)
+				}
+			}
+		}
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if offset, ok := t.uint(e, 0); ok {
+			if gps, err := t.readIFD(offset); err == nil {
+				info.GPS = t.gps(gps)
+			}
+		}
+	}
+
+	taken := dateTimeOriginal
+	if taken == "" {
+		taken = dateTime
+	}
+	if ts, ok := parseExifTime(taken, offsetTime); ok {
+		info.Taken = &ts
+	}
+
+	if *info == (ExifInfo{}) {
+		return nil, errNoExif
+	}
+	return info, nil
+}
+
+// gps converts the GPS directory to decimal degrees
+func (t *tiffReader) gps(entries map[uint16]tiffEntry) *GPSInfo {
+	coord := func(valueTag, refTag uint16, negative string) (float64, bool) {
+		e, ok := entries[valueTag]
+		if !ok {
+			return 0, false
+		}
+		deg, ok1 := t.rational(e, 0)
+		min, ok2 := t.rational(e, 1)
+		sec, ok3 := t.rational(e, 2)
+		if !ok1 || !ok2 || !ok3 {
+			return 0, false
+		}
+		v := deg + min/60 + sec/3600
+		if ref, ok := entries[refTag]; ok && strings.EqualFold(t.str(ref), negative) {
+			v = -v
+		}
+		return v, true
+	}
+
+	lat, ok1 := coord(tagGPSLatitude, tagGPSLatitudeRef, "S")
+	lon, ok2 := coord(tagGPSLongitude, tagGPSLongitudeRef, "W")
+	if !ok1 || !ok2 || math.Abs(lat) > 90 || math.Abs(lon) > 180 || (lat == 0 && lon == 0) {
+		return nil
+	}
+
+	gps := &GPSInfo{Lat: lat, Lon: lon}
+	if e, ok := entries[tagGPSAltitude]; ok {
+		gps.Alt, _ = t.rational(e, 0)
+		if ref, ok := entries[tagGPSAltitudeRef]; ok {
+			if v, _ := t.uint(ref, 0); v == 1 {
+				gps.Alt = -gps.Alt // Below sea level
+			}
+		}
+	}
+	return gps
+}
+
+// parseExifTime parses an EXIF timestamp. Without an offset the camera's wall clock
+// is interpreted in the server's time zone.
+func parseExifTime(value, offset string) (time.Time, bool) {
+	if value == "" || strings.HasPrefix(value, "0000") {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if ts, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return ts, true
+		}
+	}
+	ts, err := time.ParseInLocation("2006:01:02 15:04:05", value, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
@@ -0,0 +1,156 @@
+// File: exif_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestFile writes data to a file in a temporary directory and returns its path
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// testTIFF is a little-endian TIFF block with Make "Canon" and orientation 6
+var testTIFF = func() []byte {
+	var b bytes.Buffer
+	b.WriteString("II*\x00")
+	binary.Write(&b, binary.LittleEndian, uint32(8)) // IFD0
+	binary.Write(&b, binary.LittleEndian, uint16(2))
+	binary.Write(&b, binary.LittleEndian, []uint16{tagMake, 2})
+	binary.Write(&b, binary.LittleEndian, []uint32{6, 38}) // Value after the directory
+	binary.Write(&b, binary.LittleEndian, []uint16{tagOrientation, 3})
+	binary.Write(&b, binary.LittleEndian, []uint32{1, 6})
+	binary.Write(&b, binary.LittleEndian, uint32(0)) // No next IFD
+	b.WriteString("Canon\x00")
+	return b.Bytes()
+}()
+
+// withTIFF replaces bytes of testTIFF at an offset
+func withTIFF(offset int, patch ...byte) []byte {
+	data := bytes.Clone(testTIFF)
+	copy(data[offset:], patch)
+	return data
+}
+
+// jpegWithExif wraps a TIFF block in an APP1 segment
+func jpegWithExif(tiff []byte) []byte {
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(tiff)+8))
+	data = append(data, "Exif\x00\x00"...)
+	data = append(data, tiff...)
+	return append(data, 0xFF, 0xD9)
+}
+
+// pngWithExif puts a TIFF block in an eXIf chunk
+func pngWithExif(tiff []byte) []byte {
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tiff)))
+	data = append(data, "eXIf"...)
+	data = append(data, tiff...)
+	data = append(data, 0, 0, 0, 0) // CRC, not checked
+	return append(data, "\x00\x00\x00\x00IEND\xAE\x42\x60\x82"...)
+}
+
+// webpWithExif puts a TIFF block in the EXIF chunk of an extended WebP file
+func webpWithExif(tiff []byte) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBPEXIF")
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(tiff)))
+	return append(data, tiff...)
+}
+
+func TestParseTIFF(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		make        string // Empty with orientation 0 when an error is expected
+		orientation int
+	}{
+		{"valid", testTIFF, "Canon", 6},
+		{"big endian", []byte("MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x03\x00\x00"), "", 3},
+		{"empty", nil, "", 0},
+		{"header only", testTIFF[:8], "", 0},
+		{"bad byte order", withTIFF(0, 'X', 'X'), "", 0},
+		{"IFD past end", withTIFF(4, 0xFF, 0xFF), "", 0},
+		{"too many entries", withTIFF(8, 0xFF, 0xFF), "", 0},
+		{"entries past end", withTIFF(8, 0x10), "", 0},
+		{"make value past end", withTIFF(18, 0xFF, 0xFF)[:38], "", 6},
+		{"unknown type", withTIFF(12, 0x63), "", 6},
+		{"huge count", withTIFF(14, 0xFF, 0xFF, 0xFF, 0xFF), "", 6},
+		{"orientation out of range", withTIFF(30, 9), "Canon", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseTIFF(bytes.NewReader(tt.data))
+			if tt.make == "" && tt.orientation == 0 {
+				if err == nil {
+					t.Errorf("parseTIFF() = %+v, want an error", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTIFF() error = %v", err)
+			}
+			if info.Make != tt.make || info.Orientation != tt.orientation {
+				t.Errorf("parseTIFF() = %+v, want Make %q and orientation %d", info, tt.make, tt.orientation)
+			}
+		})
+	}
+}
+
+func TestReadExif(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{"jpeg", jpegWithExif(testTIFF), true},
+		{"tiff", testTIFF, true},
+		{"png", pngWithExif(testTIFF), true},
+		{"webp", webpWithExif(testTIFF), true},
+		{"unknown format", []byte("GIF89a"), false},
+		{"jpeg without exif", []byte{0xFF, 0xD8, 0xFF, 0xDA, 0, 2}, false},
+		{"jpeg bad marker", []byte{0xFF, 0xD8, 0x00, 0xE1, 0, 2}, false},
+		{"jpeg bad segment size", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 1}, false},
+		{"jpeg segment past end", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f', 0, 0}, false},
+		{"png image before exif", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00IDAT"), false},
+		{"png chunk too large", []byte("\x89PNG\r\n\x1a\n\xFF\xFF\xFF\xFFeXIf"), false},
+		{"webp chunk too large", []byte("RIFF\x00\x00\x00\x00WEBPEXIF\xFF\xFF\xFF\xFF"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := readExif(writeTestFile(t, "image", tt.data))
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("readExif() = %+v, want an error", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readExif() error = %v", err)
+			}
+			if info.Make != "Canon" || info.Orientation != 6 {
+				t.Errorf("readExif() = %+v, want Canon with orientation 6", info)
+			}
+		})
+	}
+}
+
+func TestReadExifTruncated(t *testing.T) {
+	// Every prefix of a valid file must be read without panicking
+	for _, data := range [][]byte{jpegWithExif(testTIFF), pngWithExif(testTIFF), webpWithExif(testTIFF)} {
+		for n := range len(data) {
+			readExif(writeTestFile(t, "image", data[:n]))
+		}
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+// File: fileid_unix.go
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file independent of the path used to reach it
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// fileIdentity returns the device and inode of a file
+func fileIdentity(fullPath string, info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+// File: fileid_windows.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileID identifies a file independent of the path used to reach it
+type fileID struct {
+	path string
+}
+
+// fileIdentity uses the fully resolved path, since os.FileInfo carries no file index on Windows
+func fileIdentity(fullPath string, info os.FileInfo) (fileID, bool) {
+	real, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return fileID{}, false
+	}
+	return fileID{path: strings.ToLower(real)}, true
+}
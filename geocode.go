@@ -0,0 +1,288 @@
+// File: geocode.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The built-in gazetteer: about two thousand cities from every continent, and the
+// country names for ISO codes
+//
+//go:embed geodata/cities.tsv geodata/countries.tsv
+var geoFS embed.FS
+
+// Reverse geocoding settings
+const (
+	maxPlaceDistance = 150.0  // Kilometers from the nearest city beyond which a position has no place
+	earthRadius      = 6371.0 // Kilometers
+	kmPerDegree      = earthRadius * math.Pi / 180
+)
+
+// Place is the city nearest to the GPS position of a file
+type Place struct {
+	City        string  `json:"city"`
+	Region      string  `json:"region,omitempty"` // State, province or similar
+	Country     string  `json:"country"`
+	CountryCode string  `json:"country_code"` // ISO 3166 alpha-2
+	Distance    float64 `json:"distance_km"`  // From the city center
+}
+
+// gazetteerCity is one entry of the gazetteer
+type gazetteerCity struct {
+	name    string
+	region  string
+	country string // ISO code
+	lat     float64
+	lon     float64
+}
+
+// gridCell is a square of one degree latitude and longitude
+type gridCell struct {
+	lat, lon int
+}
+
+// Gazetteer maps positions to the nearest city without any network access.
+// Cities are bucketed in one-degree cells, so a lookup only checks the cells
+// within maxPlaceDistance of the position.
+type Gazetteer struct {
+	source    string // Identifies the city list, see Fingerprint
+	cities    []gazetteerCity
+	grid      map[gridCell][]int32
+	countries map[string]string // ISO code -> name
+}
+
+// gazetteer names the places of geotagged files, set by SetGazetteer at startup.
+// Files get no place while it is nil.
+var gazetteer *Gazetteer
+
+// SetGazetteer loads the city list used for reverse geocoding, see LoadGazetteer
+func SetGazetteer(citiesFile string) error {
+	g, err := LoadGazetteer(citiesFile)
+	if err != nil {
+		return err
+	}
+	gazetteer = g
+	return nil
+}
+
+// LoadGazetteer loads the built-in city list, or citiesFile when it is set. The file
+// is either a GeoNames dump such as cities1000.txt, whose region names are read from
+// admin1CodesASCII.txt next to it if present, or a list in the built-in format:
+// city, region, country code, latitude and longitude separated by tabs.
+func LoadGazetteer(citiesFile string) (*Gazetteer, error) {
+	countries, err := geoFS.ReadFile("geodata/countries.tsv")
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gazetteer{
+		grid:      make(map[gridCell][]int32),
+		countries: make(map[string]string),
+	}
+	if err := readTSV(bytes.NewReader(countries), func(fields []string) error {
+		if len(fields) < 2 {
+			return fmt.Errorf("expected code and name")
+		}
+		g.countries[fields[0]] = fields[1]
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("built-in countries: %w", err)
+	}
+
+	if citiesFile == "" {
+		data, err := geoFS.ReadFile("geodata/cities.tsv")
+		if err != nil {
+			return nil, err
+		}
+		g.source = fmt.Sprintf("builtin:%x", md5.Sum(data))
+		if err := g.readCities(bytes.NewReader(data), nil); err != nil {
+			return nil, fmt.Errorf("built-in cities: %w", err)
+		}
+		return g, nil
+	}
+
+	file, err := os.Open(citiesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gazetteer: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gazetteer: %w", err)
+	}
+	absPath, err := filepath.Abs(citiesFile)
+	if err != nil {
+		absPath = citiesFile
+	}
+	g.source = fmt.Sprintf("%s|%d|%d", absPath, info.Size(), info.ModTime().UnixNano())
+
+	regions, err := readAdmin1Codes(filepath.Join(filepath.Dir(citiesFile), "admin1CodesASCII.txt"))
+	if err != nil {
+		return nil, err
+	}
+	if err := g.readCities(file, regions); err != nil {
+		return nil, fmt.Errorf("gazetteer %s: %w", citiesFile, err)
+	}
+	if len(g.cities) == 0 {
+		return nil, fmt.Errorf("gazetteer %s contains no cities", citiesFile)
+	}
+	debugLog("Loaded %d cities from %s", len(g.cities), citiesFile)
+	return g, nil
+}
+
+// readCities adds the cities of a list in the built-in or the GeoNames format.
+// regions maps GeoNames admin1 codes like "DE.02" to names.
+func (g *Gazetteer) readCities(r io.Reader, regions map[string]string) error {
+	return readTSV(r, func(fields []string) error {
+		var c gazetteerCity
+		var lat, lon string
+		switch {
+		case len(fields) >= 11: // GeoNames: name, lat, lon, country and admin1 code in columns 1, 4, 5, 8 and 10
+			c.name, lat, lon, c.country = fields[1], fields[4], fields[5], fields[8]
+			c.region = regions[c.country+"."+fields[10]]
+		case len(fields) == 5:
+			c.name, c.region, c.country, lat, lon = fields[0], fields[1], fields[2], fields[3], fields[4]
+		default:
+			return fmt.Errorf("expected 5 columns or the GeoNames format, got %d columns", len(fields))
+		}
+
+		var err1, err2 error
+		c.lat, err1 = strconv.ParseFloat(lat, 64)
+		c.lon, err2 = strconv.ParseFloat(lon, 64)
+		if err1 != nil || err2 != nil || math.Abs(c.lat) > 90 || math.Abs(c.lon) > 180 {
+			return fmt.Errorf("invalid position %q, %q", lat, lon)
+		}
+
+		cell := cellOf(c.lat, c.lon)
+		g.grid[cell] = append(g.grid[cell], int32(len(g.cities)))
+		g.cities = append(g.cities, c)
+		return nil
+	})
+}
+
+// readAdmin1Codes reads the region names of a GeoNames admin1CodesASCII.txt file.
+// A missing file is not an error, regions are then left empty.
+func readAdmin1Codes(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open region names: %w", err)
+	}
+	defer file.Close()
+
+	regions := make(map[string]string)
+	err = readTSV(file, func(fields []string) error {
+		if len(fields) >= 2 {
+			regions[fields[0]] = fields[1]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("region names %s: %w", path, err)
+	}
+	return regions, nil
+}
+
+// readTSV calls fn with the fields of every line, skipping empty lines and # comments
+func readTSV(r io.Reader, fn func(fields []string) error) error {
+	scanner := bufio.NewScanner(r)
+	// GeoNames lines include every alternate name of a city and can get long
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := fn(strings.Split(text, "\t")); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// cellOf returns the grid cell of a position
+func cellOf(lat, lon float64) gridCell {
+	return gridCell{int(math.Floor(lat)), wrapLon(int(math.Floor(lon)))}
+}
+
+// wrapLon maps a cell longitude into -180..179
+func wrapLon(lon int) int {
+	return ((lon+180)%360+360)%360 - 180
+}
+
+// Nearest returns the city closest to a position, or nil when there is none within
+// maxPlaceDistance
+func (g *Gazetteer) Nearest(lat, lon float64) *Place {
+	if g == nil {
+		return nil
+	}
+
+	// Degrees of longitude shrink towards the poles, so more cells are needed there
+	dLat := int(math.Ceil(maxPlaceDistance / kmPerDegree))
+	dLon := 180
+	if edge := math.Abs(lat) + float64(dLat); edge < 89 {
+		dLon = min(int(math.Ceil(maxPlaceDistance/(kmPerDegree*math.Cos(edge*math.Pi/180)))), 180)
+	}
+
+	center := cellOf(lat, lon)
+	best, bestDist := -1, maxPlaceDistance
+	for y := center.lat - dLat; y <= center.lat+dLat; y++ {
+		for x := center.lon - dLon; x <= center.lon+dLon; x++ {
+			for _, i := range g.grid[gridCell{y, wrapLon(x)}] {
+				c := &g.cities[i]
+				if d := distanceKm(lat, lon, c.lat, c.lon); d <= bestDist {
+					best, bestDist = int(i), d
+				}
+			}
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	c := &g.cities[best]
+	country := g.countries[c.country]
+	if country == "" {
+		country = c.country
+	}
+	return &Place{
+		City:        c.name,
+		Region:      c.region,
+		Country:     country,
+		CountryCode: c.country,
+		Distance:    math.Round(bestDist*10) / 10,
+	}
+}
+
+// Fingerprint identifies the city list, so stored places are recomputed when it changes
+func (g *Gazetteer) Fingerprint() string {
+	if g == nil {
+		return ""
+	}
+	return g.source
+}
+
+// distanceKm returns the great-circle distance between two positions
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,271 @@
+// File: hash.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Content hashing settings
+const (
+	hashInterval   = 5 * time.Second // How often the index is checked for new files
+	hashCacheFile  = "hashes.json"
+	hashSaveEvery  = 200       // Hashes between cache saves
+	hashBufferSize = 256 << 10 // Bytes read at once, and between rate limit checks
+)
+
+// Hasher computes the SHA-256 of every indexed file in the background, one file at
+// a time and at a limited read rate so browsing stays responsive. Hashes are cached
+// by path, size and mtime next to the stored index, so restarts only hash new or
+// changed files. Files that cannot be read are not tried again until they change.
+type Hasher struct {
+	index    *MediaIndex
+	libs     Libraries
+	cacheDir string
+	limiter  *rateLimiter
+	batch    *updateBatch
+
+	mu      sync.Mutex
+	cache   map[string]string // probeKey -> hex SHA-256, empty when reading failed
+	pending int               // Changes not yet saved
+}
+
+// contentHasher is the running hasher, nil when hashing is disabled
+var contentHasher *Hasher
+
+// NewHasher creates a hasher reading at most rate bytes per second, or without a
+// limit when rate is 0, and loads its cache
+func NewHasher(index *MediaIndex, libs Libraries, cacheDir string, rate int64) (*Hasher, error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	h := &Hasher{
+		index:    index,
+		libs:     libs,
+		cacheDir: cacheDir,
+		limiter:  &rateLimiter{rate: rate},
+		batch:    newUpdateBatch(index),
+		cache:    make(map[string]string),
+	}
+	h.load()
+	return h, nil
+}
+
+// load reads the cache file, starting empty when it is missing or corrupt
+func (h *Hasher) load() {
+	data, err := os.ReadFile(filepath.Join(h.cacheDir, hashCacheFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading hash cache: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &h.cache); err != nil {
+		log.Printf("Error parsing hash cache: %v", err)
+		h.cache = make(map[string]string)
+	}
+	debugLog("Loaded %d entries from hash cache", len(h.cache))
+}
+
+// save writes the cache file if it changed
+func (h *Hasher) save() {
+	h.mu.Lock()
+	if h.pending == 0 {
+		h.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(h.cache)
+	h.pending = 0
+	h.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Error serializing hash cache: %v", err)
+		return
+	}
+	// Write to a temporary file first so a crash never leaves a truncated cache
+	cacheFile := filepath.Join(h.cacheDir, hashCacheFile)
+	if err := os.WriteFile(cacheFile+".tmp", data, 0644); err != nil {
+		log.Printf("Error writing hash cache: %v", err)
+		return
+	}
+	if err := os.Rename(cacheFile+".tmp", cacheFile); err != nil {
+		log.Printf("Error writing hash cache: %v", err)
+	}
+}
+
+// Run hashes new and changed files whenever the index changes. It never returns.
+func (h *Hasher) Run() {
+	done := ^uint64(0)
+	for {
+		files, version := h.index.Snapshot()
+		if version != done {
+			h.hashAll(files)
+			h.save()
+			// Our own updates change the version too, they need no second pass
+			_, done = h.index.Snapshot()
+		}
+		time.Sleep(hashInterval)
+	}
+}
+
+// hashAll applies cached hashes, hashes the files that have none and drops the
+// cached hashes of files that are no longer indexed
+func (h *Hasher) hashAll(files []FileInfo) {
+	type hashJob struct {
+		file     FileInfo
+		fullPath string
+		key      string
+	}
+
+	defer h.batch.Flush()
+
+	var jobs []hashJob
+	current := make(map[string]bool, len(files))
+	for i := range files {
+		f := &files[i]
+		_, fullPath, ok := h.libs.Resolve(strings.TrimPrefix(f.Path, mediaURL))
+		if !ok {
+			continue
+		}
+		key := probeKey(fullPath, f.Size, f.Modified)
+		current[key] = true
+		if f.SHA256 != "" {
+			continue
+		}
+
+		h.mu.Lock()
+		sum, cached := h.cache[key]
+		h.mu.Unlock()
+
+		switch {
+		case !cached:
+			jobs = append(jobs, hashJob{file: *f, fullPath: fullPath, key: key})
+		case sum != "":
+			h.apply(f, sum)
+		}
+	}
+
+	h.mu.Lock()
+	for key := range h.cache {
+		if !current[key] {
+			delete(h.cache, key)
+			h.pending++
+		}
+	}
+	h.mu.Unlock()
+
+	if len(jobs) == 0 {
+		return
+	}
+
+	debugLog("Hashing %d files", len(jobs))
+	for _, job := range jobs {
+		sum, err := h.hashFile(job.fullPath, job.file.Size, job.file.Modified)
+		if err != nil {
+			// Remembered as failed for this size and mtime
+			debugLog("Could not hash %s: %v", job.fullPath, err)
+		}
+
+		h.mu.Lock()
+		h.cache[job.key] = sum
+		h.pending++
+		save := h.pending >= hashSaveEvery
+		h.mu.Unlock()
+
+		if sum != "" {
+			h.apply(&job.file, sum)
+		}
+		if save {
+			h.save()
+		}
+	}
+}
+
+// hashFile returns the hex SHA-256 of a file. It fails when the file no longer
+// has the given size and mtime, before or after reading it.
+func (h *Hasher) hashFile(fullPath string, size int64, modTime time.Time) (string, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	unchanged := func() error {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() != size || !info.ModTime().Equal(modTime) {
+			return fmt.Errorf("file changed")
+		}
+		return nil
+	}
+	if err := unchanged(); err != nil {
+		return "", err
+	}
+
+	sum := sha256.New()
+	buf := make([]byte, hashBufferSize)
+	for {
+		n, err := file.Read(buf)
+		sum.Write(buf[:n])
+		h.limiter.Wait(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := unchanged(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// apply queues storing a hash in the index unless the file changed in the meantime
+func (h *Hasher) apply(f *FileInfo, sum string) {
+	h.batch.Add(f.Path, func(cur *FileInfo) bool {
+		if cur.Size != f.Size || !cur.Modified.Equal(f.Modified) || cur.SHA256 == sum {
+			return false
+		}
+		cur.SHA256 = sum
+		return true
+	})
+}
+
+// rateLimiter spreads reads out to at most rate bytes per second
+type rateLimiter struct {
+	rate  int64 // Bytes per second, 0 for no limit
+	start time.Time
+	bytes int64 // Read since start
+}
+
+// Wait accounts for n bytes read and sleeps until reading them was due
+func (l *rateLimiter) Wait(n int) {
+	if l.rate <= 0 {
+		return
+	}
+	now := time.Now()
+	due := l.start.Add(time.Duration(float64(l.bytes) / float64(l.rate) * float64(time.Second)))
+	// After a pause, start over instead of catching up in a burst
+	if now.Sub(due) > time.Second {
+		l.start, l.bytes, due = now, 0, now
+	}
+	l.bytes += int64(n)
+	due = l.start.Add(time.Duration(float64(l.bytes) / float64(l.rate) * float64(time.Second)))
+	if wait := due.Sub(now); wait > 0 {
+		time.Sleep(wait)
+	}
+}
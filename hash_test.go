@@ -0,0 +1,95 @@
+// File: hash_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHasherHashFile(t *testing.T) {
+	p := writeTestFile(t, "a.jpg", []byte("hello"))
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const helloSum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	tests := []struct {
+		name     string
+		path     string
+		size     int64
+		modTime  time.Time
+		expected string // Empty when an error is expected
+	}{
+		{"unchanged", p, info.Size(), info.ModTime(), helloSum},
+		{"other size", p, info.Size() + 1, info.ModTime(), ""},
+		{"other mtime", p, info.Size(), info.ModTime().Add(time.Second), ""},
+		{"missing", filepath.Join(filepath.Dir(p), "missing.jpg"), 0, time.Time{}, ""},
+	}
+
+	h := &Hasher{limiter: &rateLimiter{}}
+	for _, tt := range tests {
+		sum, err := h.hashFile(tt.path, tt.size, tt.modTime)
+		if sum != tt.expected || (err == nil) != (tt.expected != "") {
+			t.Errorf("%s: hashFile() = %q, %v, want %q", tt.name, sum, err, tt.expected)
+		}
+	}
+}
+
+func TestHasherHashAll(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.jpg": "hello", "b.jpg": "b"})
+	info, _ := os.Stat(filepath.Join(root, "a.jpg"))
+	libs, _ := NewLibraries(&Config{InputDir: root})
+	index := NewMediaIndex([]FileInfo{
+		{Name: "a.jpg", Path: "/media/a.jpg", Dir: "/media", Size: info.Size(), Modified: info.ModTime()},
+		{Name: "b.jpg", Path: "/media/b.jpg", Dir: "/media", Size: 99}, // Changed since it was indexed
+	})
+
+	h, err := NewHasher(index, libs, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.hashAll(index.Files())
+	h.save()
+
+	if f, _ := index.Get("/media/a.jpg"); f.SHA256 == "" {
+		t.Error("a.jpg was not hashed")
+	}
+	if f, _ := index.Get("/media/b.jpg"); f.SHA256 != "" {
+		t.Error("b.jpg was hashed although it changed")
+	}
+
+	// A new hasher takes the hashes from the cache without reading the files
+	reloaded, _ := NewHasher(index, libs, h.cacheDir, 0)
+	if len(reloaded.cache) != 2 {
+		t.Errorf("reloaded cache has %d entries, want 2", len(reloaded.cache))
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter *rateLimiter
+		reads   []int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"no limit", &rateLimiter{}, []int{1 << 30}, 0, 50 * time.Millisecond},
+		{"limited", &rateLimiter{rate: 10000}, []int{1000, 1000}, 150 * time.Millisecond, time.Second},
+		{"after a pause", &rateLimiter{rate: 10000, start: time.Now().Add(-time.Minute), bytes: 10000}, []int{1000},
+			50 * time.Millisecond, time.Second}, // No burst, and no wait for the old bytes either
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		for _, n := range tt.reads {
+			tt.limiter.Wait(n)
+		}
+		if took := time.Since(start); took < tt.min || took > tt.max {
+			t.Errorf("%s: took %v, want between %v and %v", tt.name, took, tt.min, tt.max)
+		}
+	}
+}
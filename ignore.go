@@ -0,0 +1,253 @@
+// File: ignore.go
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ignoreFileName is the per-directory file holding ignore patterns
+const ignoreFileName = ".localpicsignore"
+
+// ignoreRule is one parsed gitignore-style pattern
+type ignoreRule struct {
+	base     string   // Directory the pattern is relative to, with forward slashes
+	segments []string // Pattern split on "/", unanchored patterns start with "**"
+	negate   bool     // "!pattern" re-includes what earlier rules excluded
+	dirOnly  bool     // "pattern/" only matches directories
+}
+
+// IgnoreRules is an immutable chain of ignore rules. Rules added later, including
+// those from deeper .localpicsignore files, take precedence over earlier ones.
+type IgnoreRules struct {
+	parent *IgnoreRules
+	rules  []ignoreRule
+	source []string // Configured patterns of the root, used to detect config changes
+}
+
+// NewIgnoreRules builds the root of a rule chain from configured exclude and include patterns.
+// Include patterns re-include files matched by an exclude pattern.
+func NewIgnoreRules(exclude, include []string) *IgnoreRules {
+	rules := &IgnoreRules{}
+	rules.rules = parseIgnorePatterns("", exclude)
+	rules.source = append(rules.source, exclude...)
+	for _, pattern := range include {
+		if rule, ok := parseIgnorePattern("", pattern); ok {
+			rule.negate = !rule.negate
+			rules.rules = append(rules.rules, rule)
+			rules.source = append(rules.source, "include:"+pattern)
+		}
+	}
+	return rules
+}
+
+// Fingerprint returns the configured patterns the chain was built from
+func (r *IgnoreRules) Fingerprint() []string {
+	for r != nil && r.parent != nil {
+		r = r.parent
+	}
+	if r == nil {
+		return nil
+	}
+	return r.source
+}
+
+// With returns a chain extended by patterns relative to the directory base
+func (r *IgnoreRules) With(base string, patterns []string) *IgnoreRules {
+	parsed := parseIgnorePatterns(base, patterns)
+	if len(parsed) == 0 {
+		return r
+	}
+	return &IgnoreRules{parent: r, rules: parsed}
+}
+
+// Ignored reports whether relPath (relative to the scan root, forward slashes) is excluded
+func (r *IgnoreRules) Ignored(relPath string, isDir bool) bool {
+	if path.Base(relPath) == ignoreFileName {
+		return true
+	}
+	for chain := r; chain != nil; chain = chain.parent {
+		for i := len(chain.rules) - 1; i >= 0; i-- {
+			if chain.rules[i].matches(relPath, isDir) {
+				return !chain.rules[i].negate
+			}
+		}
+	}
+	return false
+}
+
+// parseIgnorePatterns parses a list of pattern lines, skipping blanks and comments
+func parseIgnorePatterns(base string, patterns []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		if rule, ok := parseIgnorePattern(base, pattern); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// parseIgnorePattern parses one line using gitignore syntax
+func parseIgnorePattern(base, pattern string) (ignoreRule, bool) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\`) {
+		pattern = pattern[1:] // Escaped leading "#" or "!"
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	// A slash anywhere but at the end anchors the pattern to its base directory
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.segments = strings.Split(pattern, "/")
+	if !anchored {
+		rule.segments = append([]string{"**"}, rule.segments...)
+	}
+	return rule, true
+}
+
+// matches reports whether the rule applies to relPath
+func (rule ignoreRule) matches(relPath string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.base != "" {
+		if !strings.HasPrefix(relPath, rule.base+"/") {
+			return false
+		}
+		relPath = relPath[len(rule.base)+1:]
+	}
+	return matchSegments(rule.segments, strings.Split(relPath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where "**" spans any number of them
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				// A trailing "**" matches everything inside, but not the directory itself
+				return len(segments) > 0
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// readIgnoreFile reads the patterns of the ignore file in dir. A missing file yields no patterns.
+func readIgnoreFile(dir string) ([]string, time.Time, error) {
+	file, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var patterns []string
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		patterns = append(patterns, lines.Text())
+	}
+	return patterns, info.ModTime(), lines.Err()
+}
+
+// ignoreFileModTime returns the mtime of the ignore file in dir, or zero if there is none
+func ignoreFileModTime(dir string) time.Time {
+	info, err := os.Stat(filepath.Join(dir, ignoreFileName))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// rulesForDir extends base with the ignore files of relDir and every directory above it
+func rulesForDir(root, relDir string, base *IgnoreRules) *IgnoreRules {
+	rules := base
+	dirs := []string{""}
+	if relDir != "" {
+		parts := strings.Split(relDir, "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	for _, dir := range dirs {
+		patterns, _, err := readIgnoreFile(filepath.Join(root, filepath.FromSlash(dir)))
+		if err != nil {
+			debugLog("Could not read %s in %s: %v", ignoreFileName, dir, err)
+			continue
+		}
+		rules = rules.With(dir, patterns)
+	}
+	return rules
+}
+
+// internalIgnores returns patterns that keep localpics' own output out of the listing
+// when the output or cache directories live inside the library root rootDir
+func internalIgnores(config *Config, rootDir string) []string {
+	absInput, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil
+	}
+
+	var patterns []string
+	for _, dir := range []string{config.OutputDir, config.ThumbnailCache, config.IndexCache} {
+		if dir == "" {
+			continue
+		}
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(absInput, absDir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			continue
+		}
+		if rel == "." {
+			if dir == config.OutputDir {
+				// Serving from the input directory itself
+				patterns = append(patterns, "/index.html", "/static/")
+			}
+			continue
+		}
+		patterns = append(patterns, "/"+filepath.ToSlash(rel)+"/")
+	}
+	return patterns
+}
@@ -0,0 +1,86 @@
+// File: ignore_test.go
+package main
+
+import "testing"
+
+func TestIgnoreRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		exclude  []string
+		include  []string
+		relPath  string
+		isDir    bool
+		expected bool
+	}{
+		{"no rules", nil, nil, "a.jpg", false, false},
+		{"ignore file itself", nil, nil, "sub/.localpicsignore", false, true},
+		{"name anywhere", []string{"*.tmp"}, nil, "a/b/c.tmp", false, true},
+		{"name at root", []string{"*.tmp"}, nil, "c.tmp", false, true},
+		{"name no match", []string{"*.tmp"}, nil, "c.tmpx", false, false},
+		{"star stays in segment", []string{"a*"}, nil, "ab/c.jpg", false, false},
+		{"anchored by leading slash", []string{"/build"}, nil, "build", true, true},
+		{"anchored not nested", []string{"/build"}, nil, "src/build", true, false},
+		{"anchored by inner slash", []string{"docs/*.md"}, nil, "docs/a.md", false, true},
+		{"inner slash not nested", []string{"docs/*.md"}, nil, "x/docs/a.md", false, false},
+		{"dir only matches dir", []string{"cache/"}, nil, "x/cache", true, true},
+		{"dir only skips file", []string{"cache/"}, nil, "x/cache", false, false},
+		{"double star prefix", []string{"**/raw"}, nil, "a/b/raw", true, true},
+		{"double star middle", []string{"a/**/z.jpg"}, nil, "a/b/c/z.jpg", false, true},
+		{"double star middle empty", []string{"a/**/z.jpg"}, nil, "a/z.jpg", false, true},
+		{"trailing double star", []string{"a/**"}, nil, "a/b.jpg", false, true},
+		{"trailing double star not dir", []string{"a/**"}, nil, "a", true, false},
+		{"negation", []string{"*.jpg", "!keep.jpg"}, nil, "x/keep.jpg", false, false},
+		{"later rule wins", []string{"!keep.jpg", "*.jpg"}, nil, "keep.jpg", false, true},
+		{"include re-includes", []string{"*.jpg"}, []string{"best/*.jpg"}, "best/a.jpg", false, false},
+		{"include elsewhere", []string{"*.jpg"}, []string{"best/*.jpg"}, "other/a.jpg", false, true},
+		{"comment", []string{"# *.jpg"}, nil, "a.jpg", false, false},
+		{"escaped hash", []string{`\#a.jpg`}, nil, "#a.jpg", false, true},
+		{"escaped bang", []string{`\!a.jpg`}, nil, "!a.jpg", false, true},
+		{"trailing spaces", []string{"a.jpg  "}, nil, "a.jpg", false, true},
+		{"blank and slash only", []string{"", "/", "   "}, nil, "a.jpg", false, false},
+		{"character class", []string{"img[0-9].jpg"}, nil, "img5.jpg", false, true},
+		{"malformed class", []string{"img[.jpg"}, nil, "img[.jpg", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := NewIgnoreRules(tt.exclude, tt.include)
+			if got := rules.Ignored(tt.relPath, tt.isDir); got != tt.expected {
+				t.Errorf("Ignored(%q, %v) = %v, want %v", tt.relPath, tt.isDir, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIgnoreRulesNested(t *testing.T) {
+	root := NewIgnoreRules([]string{"*.raw"}, nil)
+	sub := root.With("photos", []string{"!*.raw", "/private", "*.tmp"})
+
+	tests := []struct {
+		relPath  string
+		isDir    bool
+		expected bool
+	}{
+		{"a.raw", false, true},            // Root rule outside the nested directory
+		{"photos/a.raw", false, false},    // The deeper file re-includes it
+		{"photos/x/a.raw", false, false},  // Also below it
+		{"photos/private", true, true},    // Anchored to photos
+		{"photos/x/private", true, false}, // Not deeper
+		{"private", true, false},          // Not outside
+		{"photos/a.tmp", false, true},
+		{"other/a.tmp", false, false}, // Nested rules only apply below their directory
+	}
+	for _, tt := range tests {
+		if got := sub.Ignored(tt.relPath, tt.isDir); got != tt.expected {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.relPath, tt.isDir, got, tt.expected)
+		}
+	}
+
+	// Only comments and blank lines keep the chain as it is
+	if root.With("photos", []string{"# nothing", ""}) != root {
+		t.Error("With without patterns returned a new chain")
+	}
+	if fp := sub.Fingerprint(); len(fp) != 1 || fp[0] != "*.raw" {
+		t.Errorf("Fingerprint() = %v, want the root patterns", fp)
+	}
+}
@@ -0,0 +1,239 @@
+// File: imagethumbs.go
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+)
+
+// Image thumbnail settings
+const (
+	imageThumbQuality  = 80          // JPEG quality of opaque thumbnails
+	maxThumbnailPixels = 200_000_000 // Larger images are not decoded
+)
+
+// imageThumbFormats are the decoders thumbnails are made with, as named by image.Decode
+var imageThumbFormats = map[string]bool{"jpeg": true, "png": true, "gif": true, "bmp": true, "webp": true}
+
+// Image thumbnail state, set up by InitThumbnails
+var (
+	ImageThumbnailsEnabled bool
+	imageThumbSemaphore    chan struct{}
+)
+
+// defaultImageThumbSize returns the size of image thumbnails without w and h parameters
+func defaultImageThumbSize() ThumbSize {
+	return ThumbSize{Width: ThumbnailConfig.ImageWidth, Fit: FitWidth}
+}
+
+// GetOrCreateImageThumbnail returns the path of the thumbnail of an image, creating
+// it if needed. Thumbnails share the cache directory and cache map with videos.
+// Images that would have to be enlarged are returned as they are.
+func GetOrCreateImageThumbnail(imagePath string, size ThumbSize) (string, error) {
+	if !ImageThumbnailsEnabled {
+		return "", fmt.Errorf("image thumbnails are disabled")
+	}
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	cfg, format, err := image.DecodeConfig(file)
+	file.Close()
+	if err != nil {
+		return "", fmt.Errorf("decoding header: %w", err)
+	}
+	if !imageThumbFormats[format] {
+		return "", fmt.Errorf("no thumbnails for %s images", format)
+	}
+
+	orientation := 1
+	if exif, err := readExif(imagePath); err == nil && exif.Orientation != 0 {
+		orientation = exif.Orientation
+	}
+	if _, _, scale := thumbLayout(cfg.Width, cfg.Height, orientation, size); scale >= 1 {
+		return imagePath, nil
+	}
+	if cfg.Width*cfg.Height > maxThumbnailPixels {
+		return "", fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	signature, err := GetVideoSignature(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image signature: %w", err)
+	}
+	key := size.key(GetSignatureHash(signature))
+
+	if cachedPath, exists := lookupThumbnail(key); exists {
+		return cachedPath, nil
+	}
+
+	// Opaque images get a JPEG and the others a PNG, it may be on disk but not in the cache
+	for _, ext := range []string{".jpg", ".png"} {
+		thumbnailPath := filepath.Join(ThumbnailConfig.CacheDir, key+ext)
+		if _, err := os.Stat(thumbnailPath); err == nil {
+			storeThumbnail(key, imagePath, thumbnailPath)
+			return thumbnailPath, nil
+		}
+	}
+
+	if err := os.MkdirAll(ThumbnailConfig.CacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+
+	thumbnailPath, err := GenerateImageThumbnail(imagePath, filepath.Join(ThumbnailConfig.CacheDir, key), orientation, size)
+	if err != nil {
+		return "", err
+	}
+
+	storeThumbnail(key, imagePath, thumbnailPath)
+	return thumbnailPath, nil
+}
+
+// thumbLayout returns how an image of w x h pixels with an EXIF orientation is
+// made into a thumbnail: the centered part of the image to use, the size it is
+// scaled to, both before the image is turned upright, and the scale factor.
+func thumbLayout(w, h, orientation int, size ThumbSize) (crop, out image.Point, scale float64) {
+	// Work with the size as displayed
+	rotated := orientation >= 5 && orientation <= 8
+	if rotated {
+		w, h = h, w
+	}
+
+	switch size.Fit {
+	case FitCover:
+		scale = max(float64(size.Width)/float64(w), float64(size.Height)/float64(h))
+		crop = image.Pt(min(w, int(float64(size.Width)/scale+0.5)), min(h, int(float64(size.Height)/scale+0.5)))
+		out = image.Pt(size.Width, size.Height)
+	case FitContain:
+		scale = min(float64(size.Width)/float64(w), float64(size.Height)/float64(h))
+	default:
+		scale = float64(size.Width) / float64(w)
+	}
+	if size.Fit != FitCover {
+		crop = image.Pt(w, h)
+		out = image.Pt(max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5)))
+	}
+
+	if rotated {
+		crop = image.Pt(crop.Y, crop.X)
+		out = image.Pt(out.Y, out.X)
+	}
+	return crop, out, scale
+}
+
+// GenerateImageThumbnail decodes an image, scales it to a thumbnail size and
+// turns it upright according to its EXIF orientation. The thumbnail is written to
+// basePath with a .jpg or, for images with transparency, a .png extension, and
+// the path is returned.
+func GenerateImageThumbnail(imagePath, basePath string, orientation int, size ThumbSize) (string, error) {
+	// Decoding needs a lot of memory for large images, so limit how many run at once
+	imageThumbSemaphore <- struct{}{}
+	defer func() { <-imageThumbSemaphore }()
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("decoding image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	crop, scaled, _ := thumbLayout(bounds.Dx(), bounds.Dy(), orientation, size)
+	offset := bounds.Min.Add(image.Pt((bounds.Dx()-crop.X)/2, (bounds.Dy()-crop.Y)/2))
+
+	dst := image.NewRGBA(image.Rectangle{Max: scaled})
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, image.Rectangle{Min: offset, Max: offset.Add(crop)}, draw.Src, nil)
+	thumb := orientRGBA(dst, orientation)
+
+	opaque := false
+	if o, ok := src.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+	ext := ".png"
+	if opaque {
+		ext = ".jpg"
+	}
+
+	thumbnailPath := basePath + ext
+	if err := writeImage(thumbnailPath, thumb, !opaque); err != nil {
+		return "", err
+	}
+	return thumbnailPath, nil
+}
+
+// writeImage encodes img as a JPEG, or as a PNG if asPNG is set, and writes it to
+// path. It writes to a temporary file first so a half-written image is never served.
+func writeImage(path string, img image.Image, asPNG bool) error {
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail: %w", err)
+	}
+	if asPNG {
+		err = png.Encode(out, img)
+	} else {
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: imageThumbQuality})
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), path)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+	return nil
+}
+
+// orientRGBA returns img turned as described by an EXIF orientation, so that it
+// is upright. Orientations 5-8 swap the width and height.
+func orientRGBA(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored
+				dx, dy = w-1-x, y
+			case 3: // Rotated by 180 degrees
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Mirrored and rotated by 270 degrees clockwise
+				dx, dy = y, x
+			case 6: // Rotated by 90 degrees clockwise
+				dx, dy = h-1-y, x
+			case 7: // Mirrored and rotated by 90 degrees clockwise
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated by 270 degrees clockwise
+				dx, dy = y, w-1-x
+			}
+			s := img.PixOffset(x, y)
+			d := dst.PixOffset(dx, dy)
+			copy(dst.Pix[d:d+4], img.Pix[s:s+4])
+		}
+	}
+	return dst
+}
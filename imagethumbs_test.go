@@ -0,0 +1,68 @@
+// File: imagethumbs_test.go
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestThumbLayout(t *testing.T) {
+	tests := []struct {
+		name        string
+		w, h        int
+		orientation int
+		size        ThumbSize
+		crop, out   image.Point
+	}{
+		{"width", 1000, 500, 1, ThumbSize{Width: 320, Fit: FitWidth}, image.Pt(1000, 500), image.Pt(320, 160)},
+		{"width rotated", 1000, 500, 6, ThumbSize{Width: 320, Fit: FitWidth}, image.Pt(1000, 500), image.Pt(640, 320)},
+		{"cover", 1000, 1000, 1, ThumbSize{Width: 320, Height: 180, Fit: FitCover}, image.Pt(1000, 563), image.Pt(320, 180)},
+		{"cover portrait", 1000, 2000, 1, ThumbSize{Width: 320, Height: 180, Fit: FitCover}, image.Pt(1000, 563), image.Pt(320, 180)},
+		{"cover rotated", 2000, 1000, 6, ThumbSize{Width: 320, Height: 180, Fit: FitCover}, image.Pt(563, 1000), image.Pt(180, 320)},
+		{"cover mirrored", 1000, 1000, 2, ThumbSize{Width: 320, Height: 180, Fit: FitCover}, image.Pt(1000, 563), image.Pt(320, 180)},
+		{"contain", 1000, 1000, 1, ThumbSize{Width: 320, Height: 180, Fit: FitContain}, image.Pt(1000, 1000), image.Pt(180, 180)},
+		{"contain rotated", 400, 1000, 8, ThumbSize{Width: 320, Height: 180, Fit: FitContain}, image.Pt(400, 1000), image.Pt(128, 320)},
+		{"never zero", 1000, 1, 1, ThumbSize{Width: 320, Height: 180, Fit: FitContain}, image.Pt(1000, 1), image.Pt(320, 1)},
+	}
+
+	for _, tt := range tests {
+		crop, out, _ := thumbLayout(tt.w, tt.h, tt.orientation, tt.size)
+		if crop != tt.crop || out != tt.out {
+			t.Errorf("%s: thumbLayout() = %v, %v, want %v, %v", tt.name, crop, out, tt.crop, tt.out)
+		}
+	}
+}
+
+func TestOrientRGBA(t *testing.T) {
+	// A 2x3 image with the top left pixel red and the top right one green
+	src := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	src.Pix[src.PixOffset(0, 0)] = 255
+	src.Pix[src.PixOffset(1, 0)+1] = 255
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		red, green  image.Point // Where the two pixels end up
+	}{
+		{1, image.Pt(2, 3), image.Pt(0, 0), image.Pt(1, 0)},
+		{2, image.Pt(2, 3), image.Pt(1, 0), image.Pt(0, 0)},
+		{3, image.Pt(2, 3), image.Pt(1, 2), image.Pt(0, 2)},
+		{4, image.Pt(2, 3), image.Pt(0, 2), image.Pt(1, 2)},
+		{5, image.Pt(3, 2), image.Pt(0, 0), image.Pt(0, 1)},
+		{6, image.Pt(3, 2), image.Pt(2, 0), image.Pt(2, 1)},
+		{7, image.Pt(3, 2), image.Pt(2, 1), image.Pt(2, 0)},
+		{8, image.Pt(3, 2), image.Pt(0, 1), image.Pt(0, 0)},
+		{9, image.Pt(2, 3), image.Pt(0, 0), image.Pt(1, 0)}, // Invalid values are ignored
+	}
+
+	for _, tt := range tests {
+		dst := orientRGBA(src, tt.orientation)
+		if dst.Bounds().Size() != tt.size {
+			t.Errorf("orientation %d: size %v, want %v", tt.orientation, dst.Bounds().Size(), tt.size)
+			continue
+		}
+		if dst.Pix[dst.PixOffset(tt.red.X, tt.red.Y)] != 255 || dst.Pix[dst.PixOffset(tt.green.X, tt.green.Y)+1] != 255 {
+			t.Errorf("orientation %d: pixels not at %v and %v", tt.orientation, tt.red, tt.green)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// File: index.go
+package main
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MediaIndex holds the in-memory file listing shared by the watcher and the server
+type MediaIndex struct {
+	mu      sync.RWMutex
+	byPath  map[string]FileInfo            // Web path -> entry
+	byDir   map[string]map[string]struct{} // Web directory -> web paths of its files
+	sorted  []FileInfo                     // Cached sorted listing, nil when stale
+	version uint64                         // Incremented on every change
+}
+
+// NewMediaIndex creates an index from an initial scan result
+func NewMediaIndex(files []FileInfo) *MediaIndex {
+	idx := &MediaIndex{
+		byPath: make(map[string]FileInfo, len(files)),
+		byDir:  make(map[string]map[string]struct{}),
+	}
+	for _, f := range files {
+		idx.put(f)
+	}
+	return idx
+}
+
+// Reset replaces the whole listing, e.g. with the result of a scan
+func (idx *MediaIndex) Reset(files []FileInfo) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.byPath = make(map[string]FileInfo, len(files))
+	idx.byDir = make(map[string]map[string]struct{})
+	idx.sorted = nil
+	idx.version++
+	for _, f := range files {
+		idx.put(f)
+	}
+}
+
+// Files returns all entries sorted by name. The returned slice must not be modified.
+func (idx *MediaIndex) Files() []FileInfo {
+	files, _ := idx.Snapshot()
+	return files
+}
+
+// Snapshot returns the sorted listing together with the version it belongs to
+func (idx *MediaIndex) Snapshot() ([]FileInfo, uint64) {
+	idx.mu.RLock()
+	files, version := idx.sorted, idx.version
+	idx.mu.RUnlock()
+
+	if files != nil {
+		return files, version
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.sorted == nil {
+		// Build a fresh slice so earlier snapshots stay valid for their readers
+		files := make([]FileInfo, 0, len(idx.byPath))
+		for _, f := range idx.byPath {
+			files = append(files, f)
+		}
+		sortFiles(files)
+		idx.sorted = files
+	}
+	return idx.sorted, idx.version
+}
+
+// Len returns the number of indexed files
+func (idx *MediaIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.byPath)
+}
+
+// Get returns the entry for a web path
+func (idx *MediaIndex) Get(webPath string) (FileInfo, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	f, ok := idx.byPath[webPath]
+	return f, ok
+}
+
+// Put adds or replaces an entry and reports whether the index changed
+func (idx *MediaIndex) Put(f FileInfo) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if old, ok := idx.byPath[f.Path]; ok && sameFile(old, f) {
+		return false
+	}
+	idx.put(f)
+	return true
+}
+
+func (idx *MediaIndex) put(f FileInfo) {
+	dir := path.Dir(f.Path)
+	if idx.byDir[dir] == nil {
+		idx.byDir[dir] = make(map[string]struct{})
+	}
+	idx.byDir[dir][f.Path] = struct{}{}
+	idx.byPath[f.Path] = f
+	idx.sorted = nil
+	idx.version++
+}
+
+// Update changes the entry for a web path in place. fn returns false to leave the
+// entry unchanged. Update reports whether the entry was changed.
+func (idx *MediaIndex) Update(webPath string, fn func(f *FileInfo) bool) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	f, ok := idx.byPath[webPath]
+	if !ok || !fn(&f) {
+		return false
+	}
+	idx.byPath[webPath] = f
+	idx.sorted = nil
+	idx.version++
+	return true
+}
+
+// UpdateAll changes several entries in place like Update, with a single version
+// change for all of them. It returns the number of entries changed.
+func (idx *MediaIndex) UpdateAll(updates map[string]func(f *FileInfo) bool) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	changed := 0
+	for webPath, fn := range updates {
+		f, ok := idx.byPath[webPath]
+		if !ok || !fn(&f) {
+			continue
+		}
+		idx.byPath[webPath] = f
+		changed++
+	}
+	if changed > 0 {
+		idx.sorted = nil
+		idx.version++
+	}
+	return changed
+}
+
+// Batching of background updates, so long passes do not invalidate the sorted
+// listing and the listing cache for every file
+const (
+	batchSize     = 500
+	batchInterval = 5 * time.Second
+)
+
+// updateBatch collects changes to index entries and applies them together, once
+// batchSize changes are waiting or batchInterval after the first of them
+type updateBatch struct {
+	index *MediaIndex
+
+	mu      sync.Mutex
+	updates map[string]func(f *FileInfo) bool
+	timer   *time.Timer
+}
+
+// newUpdateBatch creates an empty batch for an index
+func newUpdateBatch(index *MediaIndex) *updateBatch {
+	return &updateBatch{index: index, updates: make(map[string]func(f *FileInfo) bool)}
+}
+
+// Add queues a change to the entry for a web path, replacing an earlier one
+func (b *updateBatch) Add(webPath string, fn func(f *FileInfo) bool) {
+	b.mu.Lock()
+	b.updates[webPath] = fn
+	full := len(b.updates) >= batchSize
+	if !full && b.timer == nil {
+		b.timer = time.AfterFunc(batchInterval, b.Flush)
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.Flush()
+	}
+}
+
+// Flush applies the waiting changes
+func (b *updateBatch) Flush() {
+	b.mu.Lock()
+	updates := b.updates
+	b.updates = make(map[string]func(f *FileInfo) bool)
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(updates) > 0 {
+		b.index.UpdateAll(updates)
+	}
+}
+
+// Remove deletes the entry for a web path and reports whether it existed
+func (idx *MediaIndex) Remove(webPath string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.remove(webPath)
+}
+
+func (idx *MediaIndex) remove(webPath string) bool {
+	if _, ok := idx.byPath[webPath]; !ok {
+		return false
+	}
+	dir := path.Dir(webPath)
+	delete(idx.byDir[dir], webPath)
+	if len(idx.byDir[dir]) == 0 {
+		delete(idx.byDir, dir)
+	}
+	delete(idx.byPath, webPath)
+	idx.sorted = nil
+	idx.version++
+	return true
+}
+
+// RemoveDir deletes every entry in a web directory and its subdirectories
+func (idx *MediaIndex) RemoveDir(webDir string) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	prefix := strings.TrimSuffix(webDir, "/") + "/"
+	removed := 0
+	for dir, paths := range idx.byDir {
+		if dir != webDir && !strings.HasPrefix(dir, prefix) {
+			continue
+		}
+		for p := range paths {
+			if idx.remove(p) {
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// FilesInDir returns the web paths of the files directly inside a web directory
+func (idx *MediaIndex) FilesInDir(webDir string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	paths := make([]string, 0, len(idx.byDir[webDir]))
+	for p := range idx.byDir[webDir] {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// sameFile reports whether two entries describe the same file contents and sidecar
+func sameFile(a, b FileInfo) bool {
+	return a.Size == b.Size && a.Modified.Equal(b.Modified) && a.Type == b.Type && a.Name == b.Name &&
+		sameSidecar(a.XMP, b.XMP)
+}
@@ -0,0 +1,166 @@
+// File: indexstore.go
+package main
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// indexStoreVersion changes whenever stored entries need to be rebuilt
+const indexStoreVersion = 14
+
+// dirRecord is the stored state of one scanned directory
+type dirRecord struct {
+	ModTime time.Time  `json:"mod_time"`          // Directory mtime when it was listed
+	Subdirs []string   `json:"subdirs,omitempty"` // Names of the subdirectories
+	Links   []string   `json:"links,omitempty"`   // Subdirectories that are followed symlinks
+	Files   []FileInfo `json:"files,omitempty"`   // Entries for the files directly inside it
+
+	IgnorePatterns []string  `json:"ignore_patterns,omitempty"` // Contents of the directory's ignore file
+	IgnoreModTime  time.Time `json:"ignore_mod_time"`           // Its mtime, zero when there is none
+}
+
+// IndexStore is the persistent form of a scan, keyed by directory
+type IndexStore struct {
+	Version   int                   `json:"version"`
+	Root      string                `json:"root"`
+	BaseURL   string                `json:"base_url"`
+	Recursive bool                  `json:"recursive"`
+	Detect    bool                  `json:"detect_content"`
+	Metadata  bool                  `json:"extract_metadata"`
+	Ignore    []string              `json:"ignore,omitempty"` // Configured ignore patterns
+	Symlinks  string                `json:"symlinks"`         // Symlink policy the index was built with
+	Types     string                `json:"types"`            // Fingerprint of the category mapping
+	Places    string                `json:"places"`           // Fingerprint of the gazetteer
+	Dirs      map[string]*dirRecord `json:"dirs"`             // Relative directory with forward slashes -> record
+}
+
+func newIndexStore(opts ScanOptions) *IndexStore {
+	return &IndexStore{
+		Version:   indexStoreVersion,
+		Root:      opts.Root,
+		BaseURL:   opts.BaseURL,
+		Recursive: opts.Recursive,
+		Detect:    opts.Detect,
+		Metadata:  opts.Metadata,
+		Ignore:    opts.Ignore.Fingerprint(),
+		Symlinks:  opts.Links.Fingerprint(),
+		Types:     categories.Fingerprint(),
+		Places:    gazetteer.Fingerprint(),
+		Dirs:      make(map[string]*dirRecord),
+	}
+}
+
+// lookup returns the stored record for relDir if the directory is unchanged
+func (st *IndexStore) lookup(relDir string, modTime time.Time) *dirRecord {
+	if st == nil {
+		return nil
+	}
+	record, ok := st.Dirs[relDir]
+	if !ok || record.ModTime.IsZero() || !record.ModTime.Equal(modTime) {
+		return nil
+	}
+	return record
+}
+
+// record returns the stored record for relDir regardless of its mtime
+func (st *IndexStore) record(relDir string) *dirRecord {
+	if st == nil {
+		return nil
+	}
+	return st.Dirs[relDir]
+}
+
+// DirList returns the stored directories as paths relative to the root
+func (st *IndexStore) DirList() []string {
+	dirs := make([]string, 0, len(st.Dirs))
+	for dir := range st.Dirs {
+		dirs = append(dirs, filepath.FromSlash(dir))
+	}
+	return dirs
+}
+
+// indexStorePath returns the file that holds the stored index for a root
+func indexStorePath(cacheDir, root, baseURL string) string {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+	sum := md5.Sum([]byte(absRoot + "\x00" + baseURL))
+	return filepath.Join(cacheDir, fmt.Sprintf("index-%x.json.gz", sum[:6]))
+}
+
+// LoadIndexStore reads a stored index. It returns nil when there is no usable index
+// for this root and settings, in which case the scan starts from scratch.
+func LoadIndexStore(cacheDir string, opts ScanOptions) *IndexStore {
+	storePath := indexStorePath(cacheDir, opts.Root, opts.BaseURL)
+	file, err := os.Open(storePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			debugLog("Could not open stored index: %v", err)
+		}
+		return nil
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		debugLog("Ignoring corrupt stored index %s: %v", storePath, err)
+		return nil
+	}
+	defer reader.Close()
+
+	var st IndexStore
+	if err := json.NewDecoder(reader).Decode(&st); err != nil {
+		debugLog("Ignoring corrupt stored index %s: %v", storePath, err)
+		return nil
+	}
+
+	if st.Version != indexStoreVersion || st.BaseURL != opts.BaseURL || st.Recursive != opts.Recursive || st.Detect != opts.Detect ||
+		st.Metadata != opts.Metadata || !slices.Equal(st.Ignore, opts.Ignore.Fingerprint()) || st.Symlinks != opts.Links.Fingerprint() ||
+		st.Types != categories.Fingerprint() || st.Places != gazetteer.Fingerprint() || st.Dirs == nil {
+		debugLog("Stored index %s was built with different settings, rescanning", storePath)
+		return nil
+	}
+
+	return &st
+}
+
+// SaveIndexStore writes the index atomically so a crash never leaves a truncated file
+func SaveIndexStore(cacheDir string, st *IndexStore) error {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+
+	storePath := indexStorePath(cacheDir, st.Root, st.BaseURL)
+	tmpPath := storePath + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create index file: %w", err)
+	}
+
+	writer := gzip.NewWriter(file)
+	encodeErr := json.NewEncoder(writer).Encode(st)
+	closeErr := writer.Close()
+	fileErr := file.Close()
+
+	for _, err := range []error{encodeErr, closeErr, fileErr} {
+		if err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to write index file: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, storePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace index file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// File: indexstore_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ageTree sets the mtime of root and every directory below it to an hour ago,
+// outside the racy window of a scan
+func ageTree(t *testing.T, root string) {
+	t.Helper()
+	old := time.Now().Add(-time.Hour)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return os.Chtimes(p, old, old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexStoreLookup(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	st := &IndexStore{Dirs: map[string]*dirRecord{
+		"":     {ModTime: mtime},
+		"racy": {}, // Listed within the racy window
+	}}
+
+	tests := []struct {
+		name    string
+		store   *IndexStore
+		relDir  string
+		modTime time.Time
+		found   bool
+	}{
+		{"unchanged", st, "", mtime, true},
+		{"same instant in another zone", st, "", mtime.In(time.FixedZone("", 3600)), true},
+		{"changed", st, "", mtime.Add(time.Nanosecond), false},
+		{"unknown directory", st, "other", mtime, false},
+		{"racy record", st, "racy", time.Time{}, false},
+		{"no store", nil, "", mtime, false},
+	}
+	for _, tt := range tests {
+		if got := tt.store.lookup(tt.relDir, tt.modTime); (got != nil) != tt.found {
+			t.Errorf("%s: lookup() = %v, want found %v", tt.name, got, tt.found)
+		}
+	}
+}
+
+func TestScanReusesStoredIndex(t *testing.T) {
+	tests := []struct {
+		name     string
+		age      bool                            // Move the directory mtimes out of the racy window
+		change   func(t *testing.T, root string) // Applied before the second scan
+		reused   int
+		expected int // Files found by the second scan
+	}{
+		{"unchanged", true, nil, 3, 3},
+		{"racy", false, nil, 0, 3},
+		{"file added", true, func(t *testing.T, root string) {
+			writeTree(t, root, map[string]string{"sub/new.jpg": "n"})
+		}, 2, 4},
+		{"file removed", true, func(t *testing.T, root string) {
+			os.Remove(filepath.Join(root, "sub", "deep", "c.jpg"))
+		}, 2, 2},
+		{"ignore file edited in place", true, func(t *testing.T, root string) {
+			later := time.Now().Add(-time.Minute)
+			p := filepath.Join(root, "sub", ignoreFileName)
+			os.WriteFile(p, []byte("*.jpg\n"), 0644)
+			os.Chtimes(p, later, later)
+			os.Chtimes(filepath.Join(root, "sub"), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+		}, 1, 1}, // The rules of sub changed, so deep is listed again too
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, map[string]string{
+				"a.jpg":                 "a",
+				"sub/b.jpg":             "b",
+				"sub/deep/c.jpg":        "c",
+				"sub/" + ignoreFileName: "# nothing\n",
+			})
+			if tt.age {
+				ageTree(t, root)
+			}
+			opts := ScanOptions{Root: root, BaseURL: "/media", Recursive: true, Workers: 2}
+
+			_, store, _, err := scanDirectory(opts, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.change != nil {
+				tt.change(t, root)
+			}
+			files, _, stats, err := scanDirectory(opts, store, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.ReusedDirs != tt.reused || len(files) != tt.expected {
+				t.Errorf("reused %d directories and found %d files, want %d and %d", stats.ReusedDirs, len(files), tt.reused, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIndexStoreFingerprints(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.jpg": "a"})
+	cacheDir := t.TempDir()
+	opts := ScanOptions{Root: root, BaseURL: "/media", Recursive: true}
+
+	_, store, _, err := scanDirectory(opts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveIndexStore(cacheDir, store); err != nil {
+		t.Fatal(err)
+	}
+	if LoadIndexStore(cacheDir, opts) == nil {
+		t.Fatal("LoadIndexStore() with the same settings returned nil")
+	}
+
+	tests := []struct {
+		name   string
+		change func(opts *ScanOptions)
+	}{
+		{"not recursive", func(opts *ScanOptions) { opts.Recursive = false }},
+		{"detect content", func(opts *ScanOptions) { opts.Detect = true }},
+		{"metadata", func(opts *ScanOptions) { opts.Metadata = true }},
+		{"ignore rules", func(opts *ScanOptions) { opts.Ignore = NewIgnoreRules([]string{"*.tmp"}, nil) }},
+		{"symlink policy", func(opts *ScanOptions) { opts.Links = NewLinkPolicy(root, true, false) }},
+		{"base URL", func(opts *ScanOptions) { opts.BaseURL = "/media/photos" }},
+	}
+	for _, tt := range tests {
+		changed := opts
+		tt.change(&changed)
+		if LoadIndexStore(cacheDir, changed) != nil {
+			t.Errorf("%s: LoadIndexStore() reused an index built with other settings", tt.name)
+		}
+	}
+
+	// A corrupt file is ignored
+	os.WriteFile(indexStorePath(cacheDir, root, "/media"), []byte("not gzip"), 0644)
+	if LoadIndexStore(cacheDir, opts) != nil {
+		t.Error("LoadIndexStore() of a corrupt file returned an index")
+	}
+}
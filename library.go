@@ -0,0 +1,185 @@
+// File: library.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// mediaURL is the URL prefix all library files are served under
+const mediaURL = "/media"
+
+// Root is a library directory from the config, served at /media/<name>/
+type Root struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Recursive   *bool    `json:"recursive,omitempty"`    // Defaults to the global recursive setting
+	AllowDelete *bool    `json:"allow_delete,omitempty"` // Defaults to the global allow_delete setting
+	AllowEdit   *bool    `json:"allow_edit,omitempty"`   // Defaults to the global allow_edit setting
+	Ignore      []string `json:"ignore,omitempty"`       // Added to the global ignore patterns
+	Include     []string `json:"include,omitempty"`      // Added to the global include patterns
+}
+
+// validRootName keeps root names usable as a single URL path segment
+var validRootName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Library is a root prepared for scanning and serving
+type Library struct {
+	Name        string      // Empty for the single input_dir mounted at /media
+	Dir         string      // Directory on disk
+	AllowDelete bool        // Files may be deleted through /delete/
+	AllowEdit   bool        // Sidecars may be written through /sidecar/
+	Opts        ScanOptions // How the root is scanned and watched
+	Links       *LinkPolicy // Symlink policy for scanning and serving
+}
+
+// Libraries are the roots served by one process
+type Libraries []*Library
+
+// NewLibraries prepares the roots of a config. The legacy input_dir becomes a single
+// unnamed root served at /media; named roots are served at /media/<name>.
+func NewLibraries(config *Config) (Libraries, error) {
+	roots := config.Roots
+	if config.InputDir != "" {
+		if len(roots) > 0 {
+			return nil, fmt.Errorf("use either input_dir (-indir) or roots (-root), not both")
+		}
+		roots = []Root{{Path: config.InputDir}}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("input directory (-indir) or roots (-root) not specified and not set in config file")
+	}
+
+	var libs Libraries
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		if root.Path == "" {
+			return nil, fmt.Errorf("root %q has no path", root.Name)
+		}
+		if config.InputDir == "" && !validRootName.MatchString(root.Name) {
+			return nil, fmt.Errorf("invalid root name %q: use letters, digits, '.', '-' and '_'", root.Name)
+		}
+		if seen[root.Name] {
+			return nil, fmt.Errorf("duplicate root name %q", root.Name)
+		}
+		seen[root.Name] = true
+
+		recursive := config.Recursive
+		if root.Recursive != nil {
+			recursive = *root.Recursive
+		}
+		allowDelete := config.AllowDelete
+		if root.AllowDelete != nil {
+			allowDelete = *root.AllowDelete
+		}
+		allowEdit := config.AllowEdit
+		if root.AllowEdit != nil {
+			allowEdit = *root.AllowEdit
+		}
+
+		baseURL := mediaURL
+		if root.Name != "" {
+			baseURL = mediaURL + "/" + root.Name
+		}
+
+		links := NewLinkPolicy(root.Path, config.FollowSymlinks, config.AllowExternal)
+		exclude := append(internalIgnores(config, root.Path), config.Ignore...)
+		exclude = append(exclude, root.Ignore...)
+		include := append(append([]string{}, config.Include...), root.Include...)
+
+		libs = append(libs, &Library{
+			Name:        root.Name,
+			Dir:         root.Path,
+			AllowDelete: allowDelete,
+			AllowEdit:   allowEdit,
+			Links:       links,
+			Opts: ScanOptions{
+				Name:      root.Name,
+				Root:      root.Path,
+				BaseURL:   baseURL,
+				Recursive: recursive,
+				Workers:   config.ScanWorkers,
+				Detect:    config.DetectContent,
+				Metadata:  config.Metadata,
+				Ignore:    NewIgnoreRules(exclude, include),
+				Links:     links,
+			},
+		})
+	}
+	return libs, nil
+}
+
+// Resolve maps a path below /media to its library and the file on disk.
+// ok is false when no library serves the path.
+func (libs Libraries) Resolve(mediaPath string) (lib *Library, fullPath string, ok bool) {
+	// Cleaning a rooted path also removes any ".." that would leave the library
+	mediaPath = path.Clean("/" + mediaPath)
+
+	for _, lib := range libs {
+		if lib.Name == "" {
+			return lib, filepath.Join(lib.Dir, filepath.FromSlash(mediaPath)), true
+		}
+		prefix := "/" + lib.Name
+		if mediaPath == prefix || strings.HasPrefix(mediaPath, prefix+"/") {
+			return lib, filepath.Join(lib.Dir, filepath.FromSlash(mediaPath[len(prefix):])), true
+		}
+	}
+	return nil, "", false
+}
+
+// ResolveIndexed maps a path below /media to its index entry and the file on disk.
+// ok is false when the file is not indexed, e.g. because it is ignored, or when the
+// symlink policy of its library does not allow serving it.
+func (libs Libraries) ResolveIndexed(index *MediaIndex, mediaPath string) (f FileInfo, fullPath string, ok bool) {
+	mediaPath = path.Clean("/" + mediaPath)
+	f, ok = index.Get(mediaURL + mediaPath)
+	if !ok {
+		return FileInfo{}, "", false
+	}
+	lib, fullPath, ok := libs.Resolve(mediaPath)
+	if !ok || lib.Links.CheckPath(fullPath) != nil {
+		return FileInfo{}, "", false
+	}
+	return f, fullPath, true
+}
+
+// AnyDelete reports whether deletion is allowed in at least one library
+func (libs Libraries) AnyDelete() bool {
+	for _, lib := range libs {
+		if lib.AllowDelete {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyEdit reports whether writing sidecars is allowed in at least one library
+func (libs Libraries) AnyEdit() bool {
+	for _, lib := range libs {
+		if lib.AllowEdit {
+			return true
+		}
+	}
+	return false
+}
+
+// MediaHandler serves the files of every library below /media/
+func MediaHandler(libs Libraries) http.Handler {
+	servers := make(map[*Library]http.Handler, len(libs))
+	for _, lib := range libs {
+		servers[lib] = http.StripPrefix(lib.Opts.BaseURL, PolicyFileServer(lib.Dir, lib.Links))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lib, _, ok := libs.Resolve(strings.TrimPrefix(r.URL.Path, mediaURL))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		servers[lib].ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,105 @@
+// File: library_test.go
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewLibraries(t *testing.T) {
+	no := false
+
+	tests := []struct {
+		name   string
+		config Config
+		valid  bool
+	}{
+		{"input dir", Config{InputDir: "/photos"}, true},
+		{"named roots", Config{Roots: []Root{{Name: "photos", Path: "/photos"}, {Name: "Video_2.old", Path: "/video"}}}, true},
+		{"nothing", Config{}, false},
+		{"input dir and roots", Config{InputDir: "/photos", Roots: []Root{{Name: "a", Path: "/a"}}}, false},
+		{"root without path", Config{Roots: []Root{{Name: "a"}}}, false},
+		{"root without name", Config{Roots: []Root{{Path: "/a"}}}, false},
+		{"name with slash", Config{Roots: []Root{{Name: "a/b", Path: "/a"}}}, false},
+		{"name starting with a dot", Config{Roots: []Root{{Name: "..", Path: "/a"}}}, false},
+		{"duplicate names", Config{Roots: []Root{{Name: "a", Path: "/a"}, {Name: "a", Path: "/b"}}}, false},
+		{"overridden settings", Config{Recursive: true, Roots: []Root{{Name: "a", Path: "/a", Recursive: &no}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			libs, err := NewLibraries(&tt.config)
+			if (err == nil) != tt.valid {
+				t.Fatalf("NewLibraries() error = %v, want valid %v", err, tt.valid)
+			}
+			for _, lib := range libs {
+				if lib.Links == nil || lib.Opts.Links != lib.Links {
+					t.Errorf("library %q has no shared symlink policy", lib.Name)
+				}
+			}
+		})
+	}
+
+	libs, _ := NewLibraries(&Config{Recursive: true, AllowEdit: true, Roots: []Root{
+		{Name: "a", Path: "/a", Recursive: &no},
+		{Name: "b", Path: "/b"},
+	}})
+	if libs[0].Opts.Recursive || !libs[1].Opts.Recursive || !libs[0].AllowEdit || libs[0].Opts.BaseURL != "/media/a" {
+		t.Errorf("per-root settings not applied: %+v, %+v", libs[0].Opts, libs[1].Opts)
+	}
+}
+
+func TestLibrariesResolve(t *testing.T) {
+	single, _ := NewLibraries(&Config{InputDir: "/photos"})
+	named, _ := NewLibraries(&Config{Roots: []Root{{Name: "a", Path: "/a"}, {Name: "ab", Path: "/ab"}}})
+
+	tests := []struct {
+		name      string
+		libs      Libraries
+		mediaPath string
+		lib       string
+		fullPath  string // Empty when no library serves the path
+	}{
+		{"single root", single, "/x/y.jpg", "", "/photos/x/y.jpg"},
+		{"single root escape", single, "/../../etc/passwd", "", "/photos/etc/passwd"},
+		{"named root", named, "/a/x.jpg", "a", "/a/x.jpg"},
+		{"named root itself", named, "/a", "a", "/a"},
+		{"name prefix of another", named, "/ab/x.jpg", "ab", "/ab/x.jpg"},
+		{"named root escape", named, "/a/../ab/x.jpg", "ab", "/ab/x.jpg"},
+		{"relative", named, "a/x.jpg", "a", "/a/x.jpg"},
+		{"unknown root", named, "/c/x.jpg", "", ""},
+		{"root name as prefix only", named, "/abc", "", ""},
+		{"top level", named, "/", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lib, fullPath, ok := tt.libs.Resolve(tt.mediaPath)
+			if ok != (tt.fullPath != "") {
+				t.Fatalf("Resolve(%q) ok = %v", tt.mediaPath, ok)
+			}
+			if ok && (lib.Name != tt.lib || fullPath != filepath.FromSlash(tt.fullPath)) {
+				t.Errorf("Resolve(%q) = %q, %q, want %q, %q", tt.mediaPath, lib.Name, fullPath, tt.lib, tt.fullPath)
+			}
+		})
+	}
+}
+
+func TestLibrariesResolveIndexed(t *testing.T) {
+	root := linkTree(t)
+	libs, _ := NewLibraries(&Config{InputDir: root})
+	index := NewMediaIndex([]FileInfo{
+		{Name: "a.jpg", Path: "/media/a.jpg", Dir: "/media"},
+		{Name: "b.jpg", Path: "/media/dir-link/b.jpg", Dir: "/media/dir-link"},
+	})
+
+	if f, fullPath, ok := libs.ResolveIndexed(index, "/x/../a.jpg"); !ok || f.Name != "a.jpg" || fullPath != filepath.Join(root, "a.jpg") {
+		t.Errorf("ResolveIndexed() = %+v, %q, %v", f, fullPath, ok)
+	}
+	// Not indexed, or indexed but refused by the symlink policy
+	for _, p := range []string{"/real/b.jpg", "/dir-link/b.jpg"} {
+		if _, _, ok := libs.ResolveIndexed(index, p); ok {
+			t.Errorf("ResolveIndexed(%q) resolved", p)
+		}
+	}
+}
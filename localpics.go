@@ -11,10 +11,12 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -34,33 +36,70 @@ var (
 
 // FileInfo holds information about each file
 type FileInfo struct {
-	Name      string    `json:"name"`
-	Path      string    `json:"path"`
-	Size      int64     `json:"size"`
-	Modified  time.Time `json:"modified"`
-	Extension string    `json:"extension"`
-	Type      string    `json:"type"`
+	Name      string     `json:"name"`
+	Path      string     `json:"path"`
+	Dir       string     `json:"dir"`  // Web directory containing the file
+	Root      string     `json:"root"` // Name of the library root, empty for the input directory
+	Size      int64      `json:"size"`
+	Modified  time.Time  `json:"modified"`
+	MimeType  string     `json:"mime,omitempty"`
+	Extension string     `json:"extension"`
+	Type      string     `json:"type"`
+	Taken     time.Time  `json:"taken"`           // Capture time, see TakenFrom
+	TakenFrom string     `json:"taken_from"`      // Source of Taken: exif, video, filename or modified
+	Width     int        `json:"width,omitempty"` // Pixels as displayed, for images and probed videos
+	Height    int        `json:"height,omitempty"`
+	Exif      *ExifInfo  `json:"exif,omitempty"`  // Camera metadata of images
+	Video     *VideoInfo `json:"video,omitempty"` // Stream information of videos, filled in by the prober
+	Audio     *AudioInfo `json:"audio,omitempty"` // Tags of audio files
+	PDF       *PDFInfo   `json:"pdf,omitempty"`   // Document information of PDFs
+	Place     *Place     `json:"place,omitempty"` // City nearest to the GPS position
+	XMP       *XMPInfo   `json:"xmp,omitempty"`   // Rating and keywords from the XMP sidecar
+	// Hex SHA-256 of the contents, filled in by the hasher
+	SHA256 string `json:"sha256,omitempty"`
 }
 
 // TemplateData holds data to pass to the template
 type TemplateData struct {
 	AllowDelete       bool
+	AllowEdit         bool
 	Version           string
 	ThumbnailsEnabled bool
+	ImageThumbnails   bool
 	DebugLogging      bool
+	Categories        []Category
 }
 
 // Config holds the application configuration
 type Config struct {
-	InputDir       string `json:"input_dir"`
-	OutputDir      string `json:"output_dir"`
-	AllowDelete    bool   `json:"allow_delete"`
-	Host           string `json:"host"`
-	Recursive      bool   `json:"recursive"`
-	Thumbnails     bool   `json:"thumbnails"`
-	ThumbnailCache string `json:"thumbnail_cache"`
-	PreGenerate    int    `json:"thumbnail_pregenerate"`
-	DebugLog       bool   `json:"debug_log"`
+	InputDir       string     `json:"input_dir"`
+	OutputDir      string     `json:"output_dir"`
+	AllowDelete    bool       `json:"allow_delete"`
+	AllowEdit      bool       `json:"allow_edit"`
+	Host           string     `json:"host"`
+	Recursive      bool       `json:"recursive"`
+	Thumbnails     bool       `json:"thumbnails"`
+	ImageThumbs    bool       `json:"image_thumbnails"`
+	ThumbnailCache string     `json:"thumbnail_cache"`
+	ThumbCacheSize int        `json:"thumbnail_cache_size"` // MB, 0 for no limit
+	PreGenerate    int        `json:"thumbnail_pregenerate"`
+	DebugLog       bool       `json:"debug_log"`
+	Watch          string     `json:"watch"`
+	PollInterval   int        `json:"poll_interval"`
+	IndexCache     string     `json:"index_cache"`
+	ScanWorkers    int        `json:"scan_workers"`
+	Ignore         []string   `json:"ignore"`
+	Include        []string   `json:"include"`
+	FollowSymlinks bool       `json:"follow_symlinks"`
+	AllowExternal  bool       `json:"allow_external_symlinks"`
+	DetectContent  bool       `json:"detect_content"`
+	Metadata       bool       `json:"extract_metadata"`
+	ProbeVideos    bool       `json:"probe_videos"`
+	HashFiles      bool       `json:"hash_files"`
+	HashRate       int        `json:"hash_rate"` // MB per second read while hashing, 0 for no limit
+	Gazetteer      string     `json:"gazetteer"`
+	Categories     []Category `json:"categories"`
+	Roots          []Root     `json:"roots"`
 }
 
 // Debug loggin function
@@ -103,9 +142,20 @@ func LoadConfig(configPath string) (*Config, error) {
 		Host:           "localhost:8080",
 		Recursive:      true,
 		Thumbnails:     false,
+		ImageThumbs:    true,
 		ThumbnailCache: "thumbnails",
 		PreGenerate:    50,
 		DebugLog:       false,
+		Watch:          WatchAuto,
+		PollInterval:   30,
+		ScanWorkers:    8,
+		DetectContent:  true,
+		Metadata:       true,
+		ProbeVideos:    true,
+		HashFiles:      true,
+		HashRate:       20,
+		Ignore:         []string{},
+		Include:        []string{},
 	}
 
 	// Check if file exists
@@ -151,141 +201,45 @@ func SaveConfig(config *Config, configPath string) error {
 
 // categorizeFileType determines the media type based on file extension
 func categorizeFileType(ext string) string {
-	ext = strings.ToLower(ext)
-	switch ext {
-	case "jpg", "jpeg", "png", "gif", "bmp", "webp", "svg", "ico", "jfif":
-		return "image"
-	case "mp4", "webm", "mkv", "mpeg", "3gp":
-		return "video"
-	case "mp3", "wav", "ogg", "flac", "aac", "opus":
-		return "audio"
-	case "pdf":
-		return "pdf"
-	case "txt", "md", "log":
-		return "text"
-	case "go", "py", "c", "cpp", "h", "js", "ts", "html", "css", "sh", "java", "rs", "gd":
-		return "code"
-	case "zip", "rar", "7z", "gz", "tgz":
-		return "archive"
-	default:
-		return "other"
-	}
+	return categories.ForExtension(ext)
 }
 
-// scanDirectory scans a directory for files
-func scanDirectory(root string, baseURL string, recursive bool) ([]FileInfo, error) {
-	var files []FileInfo
-
-	if recursive {
-		// Use Walk for recursive scanning
-		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// Skip directories themselves
-			if info.IsDir() {
-				return nil
-			}
-
-			// Get the relative path from the root directory
-			relPath, err := filepath.Rel(root, path)
-			if err != nil {
-				return err
-			}
-
-			name := info.Name()
-			if name == "index.html" || strings.HasSuffix(name, ".json") {
-				return nil
-			}
-
-			ext := strings.TrimPrefix(filepath.Ext(name), ".")
-			fileType := categorizeFileType(ext)
-
-			// Always use forward slashes for web URLs, regardless of platform
-			webPath := filepath.Join(baseURL, relPath)
-			webPath = strings.ReplaceAll(webPath, "\\", "/")
-
-			files = append(files, FileInfo{
-				Name:      name,
-				Path:      webPath,
-				Size:      info.Size(),
-				Modified:  info.ModTime(),
-				Extension: ext,
-				Type:      fileType,
-			})
-
-			return nil
-		})
-
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		// Original non-recursive logic
-		entries, err := os.ReadDir(root)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			name := entry.Name()
-			if name == "index.html" || strings.HasSuffix(name, ".json") {
-				continue
-			}
-			info, err := entry.Info()
-			if err != nil {
-				continue
-			}
-			ext := strings.TrimPrefix(filepath.Ext(name), ".")
-			fileType := categorizeFileType(ext)
-
-			// Always use forward slashes for web URLs, regardless of platform
-			webPath := filepath.Join(baseURL, name)
-			webPath = strings.ReplaceAll(webPath, "\\", "/")
-
-			files = append(files, FileInfo{
-				Name:      name,
-				Path:      webPath,
-				Size:      info.Size(),
-				Modified:  info.ModTime(),
-				Extension: ext,
-				Type:      fileType,
-			})
-		}
-	}
-
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Name < files[j].Name
-	})
-
-	return files, nil
+// newFileInfo builds the index entry for a file at relPath below the scan root
+func newFileInfo(root, baseURL string, relPath string, info os.FileInfo) FileInfo {
+	name := filepath.Base(relPath) // The link name for symlinked files, not the target's
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+
+	// Always use forward slashes for web URLs, regardless of platform
+	webPath := filepath.Join(baseURL, relPath)
+	webPath = strings.ReplaceAll(webPath, "\\", "/")
+
+	f := FileInfo{
+		Name:      name,
+		Path:      webPath,
+		Dir:       path.Dir(webPath),
+		Root:      root,
+		Size:      info.Size(),
+		Modified:  info.ModTime(),
+		Extension: ext,
+		Type:      categorizeFileType(ext),
+		MimeType:  extensionMimeType(ext),
+	}
+	f.setCaptureTime()
+	return f
 }
 
-func writeJSONFiles(files []FileInfo, outputDir string) error {
-	typeMap := map[string][]FileInfo{}
-	for _, f := range files {
-		typeMap[f.Type] = append(typeMap[f.Type], f)
-	}
-	for typ, items := range typeMap {
-		jsonPath := filepath.Join(outputDir, typ+".json")
-		data, err := json.MarshalIndent(items, "", "  ")
-		if err != nil {
-			return err
-		}
-		err = os.WriteFile(jsonPath, data, 0644)
-		if err != nil {
-			return err
+// sortFiles orders a listing the way the frontend expects it
+func sortFiles(files []FileInfo) {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Name != files[j].Name {
+			return files[i].Name < files[j].Name
 		}
-	}
-	return nil
+		return files[i].Path < files[j].Path
+	})
 }
 
 // generateHTML creates the index.html file in the output directory
-func generateHTML(outputDir string, allowDelete bool, thumbnailsEnabled bool, debugLogging bool) error {
+func generateHTML(outputDir string, allowDelete bool, allowEdit bool, thumbnailsEnabled bool, imageThumbnails bool, debugLogging bool) error {
 	tmplContent, err := templateFS.ReadFile("template/index.html")
 	if err != nil {
 		return fmt.Errorf("failed to read embedded template: %w", err)
@@ -308,9 +262,12 @@ func generateHTML(outputDir string, allowDelete bool, thumbnailsEnabled bool, de
 	// Execute the template with data
 	data := TemplateData{
 		AllowDelete:       allowDelete,
+		AllowEdit:         allowEdit,
 		Version:           Version,
 		ThumbnailsEnabled: thumbnailsEnabled,
+		ImageThumbnails:   imageThumbnails,
 		DebugLogging:      debugLogging,
+		Categories:        categories.List(),
 	}
 
 	if err := tmpl.Execute(outFile, data); err != nil {
@@ -340,36 +297,54 @@ func cleanupOnExit(path string) {
 	}()
 }
 
-// FileDeleteHandler handles file deletion if enabled
-func FileDeleteHandler(inputDir string, allowDelete bool) http.HandlerFunc {
+// FileDeleteHandler handles file deletion if enabled. Only indexed files that the
+// symlink policy of their library allows serving can be deleted.
+func FileDeleteHandler(libs Libraries, index *MediaIndex) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !allowDelete {
-			http.Error(w, "File deletion is not enabled", http.StatusForbidden)
-			return
-		}
-
 		if r.Method != "DELETE" {
 			http.Error(w, "Only DELETE method is allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		// Extract filename from path, normalize for Windows
+		// The path below /delete/ is the same as below /media/
 		filename := r.URL.Path[len("/delete/"):]
-		filename = strings.ReplaceAll(filename, "/", string(os.PathSeparator))
-
 		if filename == "" {
 			http.Error(w, "No filename specified", http.StatusBadRequest)
 			return
 		}
 
 		// Prevent directory traversal
-		cleanPath := filepath.Clean(filename)
-		if strings.Contains(cleanPath, "..") {
+		if strings.Contains(filename, "..") {
 			http.Error(w, "Invalid file path", http.StatusBadRequest)
 			return
 		}
 
-		fullPath := filepath.Join(inputDir, cleanPath)
+		mediaPath := path.Clean("/" + filename)
+		lib, fullPath, ok := libs.Resolve(mediaPath)
+		if !ok {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		if !lib.AllowDelete {
+			http.Error(w, "File deletion is not enabled", http.StatusForbidden)
+			return
+		}
+
+		// Ignored files and directories are not listed, so they cannot be deleted either
+		webPath := mediaURL + mediaPath
+		if _, ok := index.Get(webPath); !ok {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+
+		// The same check as for serving, so a symlinked directory cannot reach outside the library
+		if err := lib.Links.CheckPath(fullPath); os.IsNotExist(err) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
 
 		// Check if file exists
 		_, err := os.Stat(fullPath)
@@ -385,6 +360,9 @@ func FileDeleteHandler(inputDir string, allowDelete bool) http.HandlerFunc {
 			return
 		}
 
+		// Do not wait for the watcher, which may be off
+		index.Remove(webPath)
+
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "File %s deleted successfully", filename)
 	}
@@ -424,22 +402,147 @@ func copyStaticFiles(outputDir string) error {
 	})
 }
 
+// buildIndex scans every library into index and then keeps it up to date
+func buildIndex(config *Config, libs Libraries, index *MediaIndex, progress *ScanProgress, rescan bool) {
+	// Reuse the stored index for directories that did not change since the last run
+	indexDir := config.IndexCache
+	if indexDir == "" {
+		indexDir = config.ThumbnailCache
+	}
+
+	type scanResult struct {
+		files []FileInfo
+		store *IndexStore
+		stats ScanStats
+		err   error
+	}
+	results := make([]scanResult, len(libs))
+
+	// Roots are often separate disks or shares, so they are scanned side by side
+	progress.Start()
+	var wg sync.WaitGroup
+	for i, lib := range libs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var stored *IndexStore
+			if !rescan {
+				stored = LoadIndexStore(indexDir, lib.Opts)
+			}
+			r := &results[i]
+			r.files, r.store, r.stats, r.err = scanDirectory(lib.Opts, stored, progress)
+		}()
+	}
+	wg.Wait()
+
+	var files []FileInfo
+	var total ScanStats
+	failed := 0
+	for i, lib := range libs {
+		r := results[i]
+		if r.err != nil {
+			log.Printf("Failed to scan %s: %v", lib.Dir, r.err)
+			failed++
+			continue
+		}
+		files = append(files, r.files...)
+		total.Files += r.stats.Files
+		total.Dirs += r.stats.Dirs
+		total.ReusedDirs += r.stats.ReusedDirs
+
+		if err := SaveIndexStore(indexDir, r.store); err != nil {
+			log.Printf("Warning: failed to store file index: %v", err)
+		}
+	}
+	if failed == len(libs) {
+		log.Fatalf("failed to scan directory")
+	}
+
+	sortFiles(files)
+	index.Reset(files)
+	progress.Finish()
+
+	status := progress.Status()
+	log.Printf("Indexed %d files in %d directories (%d unchanged) in %.1fs",
+		total.Files, total.Dirs, total.ReusedDirs, status.Elapsed)
+
+	// Keep the in-memory listing in sync with changes on disk
+	pollEvery := time.Duration(config.PollInterval) * time.Second
+	for i, lib := range libs {
+		if results[i].err != nil {
+			continue
+		}
+		_, err := StartWatcher(index, lib.Opts, results[i].store.DirList(), config.Watch, pollEvery, nil)
+		if err != nil {
+			log.Fatalf("failed to start watcher: %v", err)
+		}
+	}
+
+	if videoProber != nil {
+		go videoProber.Run()
+	}
+	if contentHasher != nil {
+		go contentHasher.Run()
+	}
+
+	if config.Thumbnails {
+		go PreGenerateThumbnails(files, libs)
+	}
+}
+
+// isFlagSet reports whether a flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func main() {
 	inputDir := flag.String("indir", "", "Directory to scan for media files")
-	outputDir := flag.String("outdir", "", "Directory to write HTML and JSON files (optional)")
+	var rootFlags []Root
+	flag.Func("root", "Library root as name=path, served at /media/<name>/ (can be repeated)", func(v string) error {
+		name, dir, ok := strings.Cut(v, "=")
+		if !ok || name == "" || dir == "" {
+			return fmt.Errorf("expected name=path")
+		}
+		rootFlags = append(rootFlags, Root{Name: name, Path: dir})
+		return nil
+	})
+	outputDir := flag.String("outdir", "", "Directory to write the HTML page and static assets (optional)")
 	allowDelete := flag.Bool("delete", false, "Enable file deletion API (default: false)")
+	allowEdit := flag.Bool("edit", false, "Allow changing ratings, labels and keywords in XMP sidecars (default: false)")
 	showVersion := flag.Bool("v", false, "Print version information and exit")
+	indexCache := flag.String("index-cache", "", "Directory to store the file index (default: the thumbnail cache directory)")
+	rescan := flag.Bool("rescan", false, "Ignore the stored file index and rescan everything")
+	ignorePatterns := flag.String("ignore", "", "Comma-separated gitignore-style patterns to leave out of the listing")
+	scanWorkers := flag.Int("scan-workers", 8, "Number of directories scanned in parallel (default: 8)")
 	hostAddr := flag.String("host", "localhost:8080", "Host address to serve on (default: localhost:8080)")
 	recursive := flag.Bool("recursive", true, "Scan directory recursively (default: true)")
 	enableThumbnails := flag.Bool("thumbnails", false, "Enable video thumbnail generation (requires FFmpeg)")
-	thumbnailCache := flag.String("thumb-cache", "thumbnails", "Directory to store video thumbnails")
+	imageThumbs := flag.Bool("image-thumbs", true, "Serve scaled down JPEG, PNG, GIF, BMP and WebP images in the grid (default: true)")
+	thumbnailCache := flag.String("thumb-cache", "thumbnails", "Directory to store thumbnails")
+	thumbCacheSize := flag.Int("thumb-cache-size", 0, "Maximum size of the thumbnail cache in MB, least recently used thumbnails are removed first (default: 0, no limit)")
 	preGenerate := flag.Int("thumb-pregenerate", 50, "Number of video thumbnails to pre-generate at startup")
 	debugLog := flag.Bool("log", false, "Enable debug logging (default: false)")
+	watchMode := flag.String("watch", WatchAuto, "Live update mode: auto, poll or off (default: auto)")
+	pollInterval := flag.Int("poll-interval", 30, "Seconds between rescans when polling for changes (default: 30)")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Follow symbolic links to directories inside the input directory (default: false)")
+	detectContent := flag.Bool("detect-content", true, "Detect file types from their contents, not only the extension (default: true)")
+	extractMetadata := flag.Bool("metadata", true, "Read EXIF, audio tags and other embedded metadata while scanning (default: true)")
+	probeVideos := flag.Bool("probe", true, "Read video metadata with ffprobe in the background (default: true)")
+	hashFiles := flag.Bool("hash", true, "Compute SHA-256 hashes of all files in the background (default: true)")
+	hashRate := flag.Int("hash-rate", 20, "MB per second read while hashing, 0 for no limit (default: 20)")
+	citiesFile := flag.String("gazetteer", "", "GeoNames cities file (e.g. cities1000.txt) to name places with instead of the built-in city list")
+	allowExternal := flag.Bool("allow-external-symlinks", false, "Follow symbolic links that point outside the input directory (default: false)")
 	createConfig := flag.Bool("create-config", false, "Create default config file and exit")
 	configPath := flag.String("config", GetDefaultConfigPath(), "Path to config file")
 
 	flag.Usage = func() {
-		fmt.Println("Usage: localpics -indir <input_directory> [-outdir <output_directory>] [-delete] [-host <host:port>]")
+		fmt.Println("Usage: localpics -indir <input_directory> | -root <name=path>... [-outdir <output_directory>] [-delete] [-host <host:port>]")
 		flag.PrintDefaults()
 	}
 
@@ -459,9 +562,21 @@ func main() {
 			Host:           "localhost:8080",
 			Recursive:      true,
 			Thumbnails:     false,
+			ImageThumbs:    true,
 			ThumbnailCache: "thumbnails",
 			PreGenerate:    50,
 			DebugLog:       false,
+			Watch:          WatchAuto,
+			PollInterval:   30,
+			ScanWorkers:    8,
+			DetectContent:  true,
+			Metadata:       true,
+			ProbeVideos:    true,
+			HashFiles:      true,
+			HashRate:       20,
+			Categories:     DefaultCategories(),
+			Ignore:         []string{},
+			Include:        []string{},
 		}
 
 		if err := SaveConfig(defaultConfig, *configPath); err != nil {
@@ -482,34 +597,86 @@ func main() {
 		switch f.Name {
 		case "indir":
 			config.InputDir = *inputDir
+			config.Roots = nil // The flag replaces the roots from the config file
 		case "outdir":
 			config.OutputDir = *outputDir
 		case "delete":
 			config.AllowDelete = *allowDelete
+		case "edit":
+			config.AllowEdit = *allowEdit
 		case "host":
 			config.Host = *hostAddr
 		case "recursive":
 			config.Recursive = *recursive
 		case "thumbnails":
 			config.Thumbnails = *enableThumbnails
+		case "image-thumbs":
+			config.ImageThumbs = *imageThumbs
 		case "thumb-cache":
 			config.ThumbnailCache = *thumbnailCache
+		case "thumb-cache-size":
+			config.ThumbCacheSize = *thumbCacheSize
 		case "thumb-pregenerate":
 			config.PreGenerate = *preGenerate
 		case "log":
 			config.DebugLog = *debugLog
+		case "watch":
+			config.Watch = *watchMode
+		case "poll-interval":
+			config.PollInterval = *pollInterval
+		case "index-cache":
+			config.IndexCache = *indexCache
+		case "scan-workers":
+			config.ScanWorkers = *scanWorkers
+		case "ignore":
+			config.Ignore = strings.Split(*ignorePatterns, ",")
+		case "root":
+			config.Roots = rootFlags
+			if !isFlagSet("indir") {
+				config.InputDir = "" // The flags replace the input directory from the config file
+			}
+		case "follow-symlinks":
+			config.FollowSymlinks = *followSymlinks
+		case "allow-external-symlinks":
+			config.AllowExternal = *allowExternal
+		case "detect-content":
+			config.DetectContent = *detectContent
+		case "metadata":
+			config.Metadata = *extractMetadata
+		case "probe":
+			config.ProbeVideos = *probeVideos
+		case "hash":
+			config.HashFiles = *hashFiles
+		case "hash-rate":
+			config.HashRate = *hashRate
+		case "gazetteer":
+			config.Gazetteer = *citiesFile
 		}
 	})
 
 	debugLogging = config.DebugLog
 
+	if len(config.Categories) == 0 {
+		config.Categories = DefaultCategories()
+	}
+	if err := SetCategories(config.Categories); err != nil {
+		fmt.Fprintf(os.Stderr, "Error in categories: %v\n", err)
+		os.Exit(1)
+	}
+	if err := SetGazetteer(config.Gazetteer); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading gazetteer: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Initialize thumbnails if enabled
-	if config.Thumbnails {
-		InitThumbnails(true, config.ThumbnailCache, config.PreGenerate, config.DebugLog)
+	if config.Thumbnails || config.ImageThumbs {
+		InitThumbnails(config.Thumbnails, config.ImageThumbs, config.ThumbnailCache, max(config.ThumbCacheSize, 0),
+			config.PreGenerate, config.DebugLog)
 	}
 
-	if config.InputDir == "" {
-		fmt.Fprintln(os.Stderr, "Error: input directory (-indir) not specified and not set in config file.")
+	libs, err := NewLibraries(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -528,16 +695,30 @@ func main() {
 		log.Fatalf("failed to create output directory: %v", err)
 	}
 
-	files, err := scanDirectory(config.InputDir, "/media", config.Recursive)
-	if err != nil {
-		log.Fatalf("failed to scan directory: %v", err)
-	}
+	// Scan in the background so the interface can show progress right away
+	index := NewMediaIndex(nil)
+	progress := &ScanProgress{}
 
-	if err := writeJSONFiles(files, config.OutputDir); err != nil {
-		log.Fatalf("failed to write JSON files: %v", err)
+	if config.ProbeVideos {
+		videoProber, err = NewVideoProber(index, libs, config.ThumbnailCache)
+		if err != nil {
+			log.Printf("Video metadata disabled: %v", err)
+		}
+	}
+	if config.HashFiles {
+		hashDir := config.IndexCache
+		if hashDir == "" {
+			hashDir = config.ThumbnailCache
+		}
+		contentHasher, err = NewHasher(index, libs, hashDir, int64(max(config.HashRate, 0))<<20)
+		if err != nil {
+			log.Printf("Content hashing disabled: %v", err)
+		}
 	}
 
-	if err := generateHTML(config.OutputDir, config.AllowDelete, config.Thumbnails, config.DebugLog); err != nil {
+	go buildIndex(config, libs, index, progress, *rescan)
+
+	if err := generateHTML(config.OutputDir, libs.AnyDelete(), libs.AnyEdit(), config.Thumbnails, ImageThumbnailsEnabled, config.DebugLog); err != nil {
 		log.Fatalf("failed to write HTML file: %v", err)
 	}
 
@@ -549,18 +730,28 @@ func main() {
 		cleanupOnExit(config.OutputDir)
 	}
 
-	if config.AllowDelete {
+	if libs.AnyDelete() {
 		fmt.Println("⚠️ WARNING: File deletion API is enabled")
-		http.Handle("/delete/", FileDeleteHandler(config.InputDir, true))
+		http.Handle("/delete/", FileDeleteHandler(libs, index))
 	}
-
-	if config.Thumbnails {
-		http.Handle("/thumbnail/", ThumbnailHandler(config.InputDir))
-		go PreGenerateThumbnails(files, config.InputDir)
+	if libs.AnyEdit() {
+		http.Handle("/sidecar/", SidecarHandler(libs, index))
 	}
 
+	// Video thumbnails need FFmpeg, image thumbnails and the cover art of audio files do not
+	http.Handle("/thumbnail/", ThumbnailHandler(libs, index))
+	http.Handle("/storyboard/", StoryboardHandler(libs, index))
+	http.Handle("/preview/", PreviewHandler(libs, index))
+
+	http.Handle("/api/files", FileListHandler(index))
+	http.Handle("/api/status", ScanStatusHandler(progress, index))
+	http.Handle("/api/tree", TreeHandler(index, mediaURL))
+	http.Handle("/api/timeline", TimelineHandler(index))
+	http.Handle("/api/places", PlacesHandler(index))
+	http.Handle("/api/snippet", SnippetHandler(libs, index))
+	http.Handle("/api/cache", CacheStatsHandler())
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(filepath.Join(config.OutputDir, "static")))))
-	http.Handle("/media/", http.StripPrefix("/media/", http.FileServer(http.Dir(config.InputDir))))
+	http.Handle("/media/", MediaHandler(libs))
 	http.Handle("/", http.FileServer(http.Dir(config.OutputDir)))
 
 	fmt.Printf("Serving on http://%s\n", config.Host)
@@ -0,0 +1,81 @@
+// File: metadata.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// extractMetadata reads the embedded metadata of the file at fullPath into f.
+// Files without metadata or in unsupported formats are left unchanged.
+func extractMetadata(f *FileInfo, fullPath string) {
+	switch f.Type {
+	case "image":
+		exif, err := readExif(fullPath)
+		if err != nil && err != errNoExif {
+			debugLog("Could not read EXIF data of %s: %v", fullPath, err)
+		}
+		if err == nil {
+			f.Exif = exif
+			if exif.GPS != nil {
+				f.Place = gazetteer.Nearest(exif.GPS.Lat, exif.GPS.Lon)
+			}
+		}
+
+		width, height, err := readImageSize(fullPath)
+		if err != nil {
+			if !errors.Is(err, image.ErrFormat) {
+				debugLog("Could not read the size of %s: %v", fullPath, err)
+			}
+			return
+		}
+		// Orientations 5-8 are rotated by 90 degrees, so the image is displayed sideways
+		if f.Exif != nil && f.Exif.Orientation >= 5 && f.Exif.Orientation <= 8 {
+			width, height = height, width
+		}
+		f.Width, f.Height = width, height
+	case "audio":
+		tags, err := readAudioTags(fullPath)
+		if err != nil {
+			if err != errNoTags {
+				debugLog("Could not read audio tags of %s: %v", fullPath, err)
+			}
+			return
+		}
+		f.Audio = tags
+	case "pdf":
+		info, err := readPDFInfo(fullPath)
+		if err != nil {
+			if err != errNotPDF {
+				debugLog("Could not read PDF metadata of %s: %v", fullPath, err)
+			}
+			return
+		}
+		f.PDF = info
+	}
+}
+
+// readImageSize returns the pixel size stored in the header of an image without
+// decoding it. Formats without a registered decoder return image.ErrFormat.
+func readImageSize(fullPath string) (width, height int, err error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("decoding header: %w", err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
@@ -0,0 +1,1114 @@
+// File: pdf.go
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// PDFInfo is the document information and page count of a PDF
+type PDFInfo struct {
+	Title     string     `json:"title,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+	Keywords  string     `json:"keywords,omitempty"`
+	Creator   string     `json:"creator,omitempty"`  // Application the document was made in
+	Producer  string     `json:"producer,omitempty"` // Application that wrote the PDF
+	Created   *time.Time `json:"created,omitempty"`
+	Modified  *time.Time `json:"modified,omitempty"`
+	Pages     int        `json:"pages,omitempty"`
+	Version   string     `json:"version"`             // From the header, e.g. 1.7
+	Encrypted bool       `json:"encrypted,omitempty"` // The information of encrypted files is not read
+}
+
+// Limits for reading PDFs
+const (
+	pdfTailSize      = 4 << 10  // Bytes searched from the end for startxref
+	pdfWindowSize    = 64 << 10 // Bytes read at once to parse an object
+	maxPDFRead       = 64 << 20 // Largest object, stream or file read into memory
+	maxPDFDepth      = 64       // Nesting of arrays, dictionaries and references
+	maxPDFXrefs      = 256      // Cross-reference sections followed through /Prev
+	maxPDFPageWalk   = 100000   // Page tree nodes visited when /Count is missing
+	maxPDFStreamObjs = 1 << 20  // Objects in one object stream
+)
+
+// errNotPDF is returned for files without a PDF header
+var errNotPDF = errors.New("not a PDF file")
+
+// errPDFTruncated is returned when a read window ends inside an object; the object
+// is parsed again from a larger window
+var errPDFTruncated = errors.New("object extends past the read window")
+
+// PDF object types. Numbers are int64 or float64, dictionaries pdfDict and arrays []any.
+type (
+	pdfName    string
+	pdfString  []byte
+	pdfKeyword string
+	pdfDict    map[pdfName]any
+	pdfRef     struct{ num, gen int64 }
+	pdfStream  struct {
+		dict   pdfDict
+		offset int64 // Of the stream data in the file
+	}
+)
+
+// pdfXref locates an object: at an offset in the file, or inside an object stream
+type pdfXref struct {
+	offset   int64
+	stream   int64 // Object number of the object stream, 0 for an offset
+	index    int   // Position in the object stream
+	inStream bool
+}
+
+// pdfReader reads objects from a PDF through its cross-reference sections
+type pdfReader struct {
+	f       io.ReaderAt
+	size    int64
+	xref    map[int64]pdfXref
+	trailer pdfDict
+	objStms map[int64]map[int64]any // Parsed object streams
+	depth   int                     // Current nesting of resolved references
+}
+
+// readPDFInfo reads the document information dictionary and page count of a PDF.
+// Cross-reference tables and streams, incremental updates and linearized files are
+// supported; files with broken cross-references are scanned for their objects.
+func readPDFInfo(fullPath string) (*PDFInfo, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 1024)
+	n, _ := file.ReadAt(header, 0)
+	header = header[:n]
+	start := bytes.Index(header, []byte("%PDF-"))
+	if start < 0 {
+		return nil, errNotPDF
+	}
+	version := header[start+5:]
+	if end := bytes.IndexFunc(version, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); end >= 0 {
+		version = version[:end]
+	}
+
+	// Offsets are relative to the header, which some files have after junk bytes
+	p := &pdfReader{
+		f:       io.NewSectionReader(file, int64(start), st.Size()-int64(start)),
+		size:    st.Size() - int64(start),
+		xref:    make(map[int64]pdfXref),
+		trailer: make(pdfDict),
+		objStms: make(map[int64]map[int64]any),
+	}
+	if err := p.readXrefs(); err != nil {
+		debugLog("Rebuilding the cross-references of %s: %v", fullPath, err)
+		p.xref = make(map[int64]pdfXref)
+		p.trailer = make(pdfDict)
+		if err := p.rebuildXref(); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &PDFInfo{Version: string(version)}
+	info.Pages = p.pageCount()
+
+	if _, ok := p.trailer["Encrypt"]; ok {
+		// The strings of encrypted files would have to be decrypted first
+		info.Encrypted = true
+		return info, nil
+	}
+	if dict, ok := p.resolve(p.trailer["Info"]).(pdfDict); ok {
+		info.Title = p.text(dict["Title"])
+		info.Author = p.text(dict["Author"])
+		info.Subject = p.text(dict["Subject"])
+		info.Keywords = p.text(dict["Keywords"])
+		info.Creator = p.text(dict["Creator"])
+		info.Producer = p.text(dict["Producer"])
+		info.Created = parsePDFDate(p.text(dict["CreationDate"]))
+		info.Modified = parsePDFDate(p.text(dict["ModDate"]))
+	}
+	return info, nil
+}
+
+// readXrefs reads the cross-reference sections from the last one back through
+// /Prev. Entries and trailer keys of newer sections take precedence.
+func (p *pdfReader) readXrefs() error {
+	tailSize := min(int64(pdfTailSize), p.size)
+	tail := make([]byte, tailSize)
+	if _, err := p.f.ReadAt(tail, p.size-tailSize); err != nil && err != io.EOF {
+		return err
+	}
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i < 0 {
+		return fmt.Errorf("no startxref")
+	}
+	l := &pdfLexer{buf: tail[i+len("startxref"):], eof: true}
+	offset, ok := l.next().(int64)
+	if !ok {
+		return fmt.Errorf("invalid startxref")
+	}
+
+	seen := make(map[int64]bool)
+	for n := 0; ; n++ {
+		if n >= maxPDFXrefs || seen[offset] {
+			return fmt.Errorf("cross-reference sections loop")
+		}
+		seen[offset] = true
+
+		trailer, err := p.readXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		// A hybrid file keeps the entries of its compressed objects in a stream
+		if stm, ok := trailer["XRefStm"].(int64); ok && !seen[stm] {
+			seen[stm] = true
+			if _, err := p.readXrefSection(stm); err != nil {
+				return err
+			}
+		}
+		for key, value := range trailer {
+			if _, ok := p.trailer[key]; !ok {
+				p.trailer[key] = value
+			}
+		}
+
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		offset = prev
+	}
+	if _, ok := p.trailer["Root"]; !ok {
+		return fmt.Errorf("trailer has no /Root")
+	}
+	return nil
+}
+
+// readXrefSection reads a cross-reference table with its trailer, or a
+// cross-reference stream, at offset and returns the trailer
+func (p *pdfReader) readXrefSection(offset int64) (pdfDict, error) {
+	var trailer pdfDict
+	err := p.parseAt(offset, func(l *pdfLexer) error {
+		first := l.next()
+		if err := l.err; err != nil {
+			return err
+		}
+		if first != pdfKeyword("xref") {
+			// A cross-reference stream is an indirect object
+			l.pos = 0
+			obj, err := p.parseIndirect(l, offset)
+			if err != nil {
+				return err
+			}
+			stream, ok := obj.(*pdfStream)
+			if !ok || stream.dict["Type"] != pdfName("XRef") {
+				return fmt.Errorf("no cross-reference section at offset %d", offset)
+			}
+			trailer = stream.dict
+			return p.readXrefStream(stream)
+		}
+
+		for {
+			tok := l.next()
+			if l.err != nil {
+				return l.err
+			}
+			if tok == pdfKeyword("trailer") {
+				dict, ok := l.next().(pdfDict)
+				if l.err != nil {
+					return l.err
+				}
+				if !ok {
+					return fmt.Errorf("invalid trailer")
+				}
+				trailer = dict
+				return nil
+			}
+			start, ok1 := tok.(int64)
+			count, ok2 := l.next().(int64)
+			if !ok1 || !ok2 || start < 0 || count < 0 {
+				return fmt.Errorf("invalid cross-reference subsection")
+			}
+			for i := int64(0); i < count; i++ {
+				off, ok1 := l.next().(int64)
+				_, ok2 := l.next().(int64)
+				kind := l.next()
+				if l.err != nil {
+					return l.err
+				}
+				if !ok1 || !ok2 || (kind != pdfKeyword("n") && kind != pdfKeyword("f")) {
+					return fmt.Errorf("invalid cross-reference entry")
+				}
+				num := start + i
+				// Free entries are left out: hybrid files list their compressed
+				// objects as free and locate them in the /XRefStm stream
+				if _, ok := p.xref[num]; !ok && kind == pdfKeyword("n") {
+					p.xref[num] = pdfXref{offset: off}
+				}
+			}
+		}
+	})
+	return trailer, err
+}
+
+// readXrefStream adds the entries of a cross-reference stream
+func (p *pdfReader) readXrefStream(stream *pdfStream) error {
+	data, err := p.streamData(stream)
+	if err != nil {
+		return err
+	}
+
+	widths, ok := stream.dict["W"].([]any)
+	if !ok || len(widths) != 3 {
+		return fmt.Errorf("invalid cross-reference stream /W")
+	}
+	var w [3]int
+	for i, v := range widths {
+		n, ok := v.(int64)
+		if !ok || n < 0 || n > 8 {
+			return fmt.Errorf("invalid cross-reference stream /W")
+		}
+		w[i] = int(n)
+	}
+	entrySize := w[0] + w[1] + w[2]
+	if entrySize == 0 {
+		return fmt.Errorf("invalid cross-reference stream /W")
+	}
+
+	index := []any{int64(0), stream.dict["Size"]}
+	if v, ok := stream.dict["Index"].([]any); ok {
+		index = v
+	}
+
+	field := func(b []byte, def int64) int64 {
+		if len(b) == 0 {
+			return def
+		}
+		var v int64
+		for _, c := range b {
+			v = v<<8 | int64(c)
+		}
+		return v
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 || start < 0 || count < 0 {
+			return fmt.Errorf("invalid cross-reference stream /Index")
+		}
+		for j := int64(0); j < count; j++ {
+			if pos+entrySize > len(data) {
+				return fmt.Errorf("cross-reference stream is too short")
+			}
+			entry := data[pos : pos+entrySize]
+			pos += entrySize
+
+			num := start + j
+			if _, ok := p.xref[num]; ok {
+				continue
+			}
+			f2, f3 := field(entry[w[0]:w[0]+w[1]], 0), field(entry[w[0]+w[1]:], 0)
+			switch field(entry[:w[0]], 1) {
+			case 0:
+				p.xref[num] = pdfXref{offset: -1}
+			case 1:
+				p.xref[num] = pdfXref{offset: f2}
+			case 2:
+				p.xref[num] = pdfXref{stream: f2, index: int(f3), inStream: true}
+			}
+		}
+	}
+	return nil
+}
+
+// objRegexp finds the start of indirect objects when the cross-references are broken
+var objRegexp = regexp.MustCompile(`(?:^|[\r\n\s])(\d+)\s+(\d+)\s+obj\b`)
+
+// rebuildXref finds the objects and trailer of a file whose cross-references
+// cannot be read by scanning the whole file
+func (p *pdfReader) rebuildXref() error {
+	if p.size > maxPDFRead {
+		return fmt.Errorf("broken cross-references in a file too large to scan")
+	}
+	data := make([]byte, p.size)
+	if _, err := p.f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	// Later definitions replace earlier ones, as in an incremental update
+	var streams, catalogs []int64
+	for _, m := range objRegexp.FindAllSubmatchIndex(data, -1) {
+		num, err := strconv.ParseInt(string(data[m[2]:m[3]]), 10, 64)
+		if err != nil {
+			continue
+		}
+		p.xref[num] = pdfXref{offset: int64(m[2])}
+		head := data[m[1]:min(len(data), m[1]+512)]
+		if bytes.Contains(head, []byte("/XRef")) {
+			streams = append(streams, int64(m[2]))
+		}
+		if bytes.Contains(head, []byte("/Catalog")) {
+			catalogs = append(catalogs, num)
+		}
+	}
+
+	// Cross-reference streams still point into object streams and carry the trailer
+	for i := len(streams) - 1; i >= 0; i-- {
+		obj, err := p.objectAt(streams[i])
+		if err != nil {
+			continue
+		}
+		if stream, ok := obj.(*pdfStream); ok && stream.dict["Type"] == pdfName("XRef") {
+			p.readXrefStream(stream)
+			for key, value := range stream.dict {
+				if _, ok := p.trailer[key]; !ok {
+					p.trailer[key] = value
+				}
+			}
+		}
+	}
+	if i := bytes.LastIndex(data, []byte("trailer")); i >= 0 {
+		l := &pdfLexer{buf: data[i+len("trailer"):], eof: true}
+		if dict, ok := l.next().(pdfDict); ok {
+			for key, value := range dict {
+				p.trailer[key] = value
+			}
+		}
+	}
+
+	if _, ok := p.trailer["Root"]; !ok {
+		// Look for the catalog itself
+		for i := len(catalogs) - 1; i >= 0; i-- {
+			if dict, ok := p.resolve(pdfRef{num: catalogs[i]}).(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+				p.trailer["Root"] = pdfRef{num: catalogs[i]}
+				break
+			}
+		}
+	}
+	if _, ok := p.trailer["Root"]; !ok {
+		return fmt.Errorf("no document catalog found")
+	}
+	return nil
+}
+
+// pageCount returns the number of pages from the page tree, 0 when unknown
+func (p *pdfReader) pageCount() int {
+	root, ok := p.resolve(p.trailer["Root"]).(pdfDict)
+	if !ok {
+		return 0
+	}
+	pages, ok := p.resolve(root["Pages"]).(pdfDict)
+	if !ok {
+		return 0
+	}
+	if count, ok := p.resolve(pages["Count"]).(int64); ok && count >= 0 {
+		return int(count)
+	}
+
+	// Count the leaves of the page tree without /Count
+	visited := 0
+	var walk func(node pdfDict, depth int) int
+	walk = func(node pdfDict, depth int) int {
+		visited++
+		if depth > maxPDFDepth || visited > maxPDFPageWalk {
+			return 0
+		}
+		kids, ok := p.resolve(node["Kids"]).([]any)
+		if !ok {
+			return 1
+		}
+		n := 0
+		for _, kid := range kids {
+			if dict, ok := p.resolve(kid).(pdfDict); ok {
+				n += walk(dict, depth+1)
+			}
+		}
+		return n
+	}
+	return walk(pages, 0)
+}
+
+// resolve follows a reference to its object; other values are returned as they are.
+// Objects that cannot be read resolve to nil.
+func (p *pdfReader) resolve(v any) any {
+	ref, ok := v.(pdfRef)
+	if !ok {
+		return v
+	}
+	if p.depth > maxPDFDepth {
+		return nil
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	x, ok := p.xref[ref.num]
+	switch {
+	case !ok || (!x.inStream && x.offset < 0):
+		return nil
+	case x.inStream:
+		objs, err := p.objectStream(x.stream)
+		if err != nil {
+			debugLog("Could not read PDF object stream %d: %v", x.stream, err)
+			return nil
+		}
+		return p.resolve(objs[ref.num])
+	}
+
+	obj, err := p.objectAt(x.offset)
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// objectAt reads the indirect object at an offset
+func (p *pdfReader) objectAt(offset int64) (any, error) {
+	var obj any
+	err := p.parseAt(offset, func(l *pdfLexer) error {
+		var err error
+		obj, err = p.parseIndirect(l, offset)
+		return err
+	})
+	return obj, err
+}
+
+// parseIndirect parses "num gen obj ... endobj". The data of a stream is not read,
+// only its position is noted.
+func (p *pdfReader) parseIndirect(l *pdfLexer, offset int64) (any, error) {
+	_, ok1 := l.next().(int64)
+	_, ok2 := l.next().(int64)
+	kw := l.next()
+	if l.err != nil {
+		return nil, l.err
+	}
+	if !ok1 || !ok2 || kw != pdfKeyword("obj") {
+		return nil, fmt.Errorf("no object at offset %d", offset)
+	}
+	obj := l.next()
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		return obj, nil
+	}
+	save := l.pos
+	if l.next() != pdfKeyword("stream") {
+		if l.err == errPDFTruncated {
+			return nil, l.err
+		}
+		l.pos = save
+		return dict, nil
+	}
+	// The data starts after the end of line following the keyword
+	if l.pos < len(l.buf) && l.buf[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.buf) && l.buf[l.pos] == '\n' {
+		l.pos++
+	}
+	return &pdfStream{dict: dict, offset: offset + int64(l.pos)}, nil
+}
+
+// parseAt runs fn on a window of the file starting at offset. The window grows
+// while fn stops at its end.
+func (p *pdfReader) parseAt(offset int64, fn func(l *pdfLexer) error) error {
+	if offset < 0 || offset >= p.size {
+		return fmt.Errorf("offset %d outside the file", offset)
+	}
+	for size := int64(pdfWindowSize); ; size *= 8 {
+		size = min(size, p.size-offset, maxPDFRead)
+		buf := make([]byte, size)
+		n, err := p.f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		l := &pdfLexer{buf: buf[:n], eof: offset+int64(n) >= p.size}
+		err = fn(l)
+		if err != errPDFTruncated || l.eof || size >= maxPDFRead {
+			return err
+		}
+	}
+}
+
+// streamData returns the decoded data of a stream
+func (p *pdfReader) streamData(s *pdfStream) ([]byte, error) {
+	length, ok := p.resolve(s.dict["Length"]).(int64)
+	if !ok || length < 0 {
+		return nil, fmt.Errorf("invalid stream length")
+	}
+	if length > maxPDFRead || s.offset+length > p.size {
+		return nil, fmt.Errorf("stream too large")
+	}
+	data := make([]byte, length)
+	if _, err := p.f.ReadAt(data, s.offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var filters, params []any
+	switch f := p.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []any{f}
+	case []any:
+		filters = f
+	}
+	switch d := p.resolve(s.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = []any{d}
+	case []any:
+		params = d
+	}
+
+	for i, filter := range filters {
+		if filter != pdfName("FlateDecode") && filter != pdfName("Fl") {
+			return nil, fmt.Errorf("unsupported stream filter %v", filter)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := io.ReadAll(io.LimitReader(zr, maxPDFRead))
+		// Many writers leave out the checksum, keep what was inflated
+		if err != nil && len(decoded) == 0 {
+			return nil, err
+		}
+		data = decoded
+
+		if i < len(params) {
+			if dict, ok := p.resolve(params[i]).(pdfDict); ok {
+				if data, err = pdfPredictor(data, dict); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+// pdfPredictor undoes the PNG predictors used by cross-reference streams
+func pdfPredictor(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 10 {
+		if predictor > 1 {
+			return nil, fmt.Errorf("unsupported TIFF predictor")
+		}
+		return data, nil
+	}
+	columns, ok := params["Columns"].(int64)
+	if !ok {
+		columns = 1
+	}
+	colors, ok := params["Colors"].(int64)
+	if !ok {
+		colors = 1
+	}
+	bits, ok := params["BitsPerComponent"].(int64)
+	if !ok {
+		bits = 8
+	}
+	if columns < 1 || colors < 1 || bits < 1 || columns*colors*bits > 1<<20 {
+		return nil, fmt.Errorf("invalid predictor parameters")
+	}
+	bpp := int(max(1, colors*bits/8))
+	rowSize := int((columns*colors*bits + 7) / 8)
+
+	out := make([]byte, 0, len(data))
+	prev := make([]byte, rowSize)
+	for pos := 0; pos+1+rowSize <= len(data); pos += 1 + rowSize {
+		kind, row := data[pos], data[pos+1:pos+1+rowSize]
+		cur := make([]byte, rowSize)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch kind {
+			case 0:
+				cur[i] = row[i]
+			case 1:
+				cur[i] = row[i] + left
+			case 2:
+				cur[i] = row[i] + up
+			case 3:
+				cur[i] = row[i] + byte((int(left)+int(up))/2)
+			case 4:
+				cur[i] = row[i] + paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("invalid PNG predictor %d", kind)
+			}
+		}
+		out = append(out, cur...)
+		prev = cur
+	}
+	return out, nil
+}
+
+// paeth is the Paeth predictor of the PNG specification
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// objectStream returns the objects of a compressed object stream by number
+func (p *pdfReader) objectStream(num int64) (map[int64]any, error) {
+	if objs, ok := p.objStms[num]; ok {
+		return objs, nil
+	}
+	p.objStms[num] = nil // Guards against streams that contain themselves
+
+	x, ok := p.xref[num]
+	if !ok || x.inStream || x.offset < 0 {
+		return nil, fmt.Errorf("object stream %d not found", num)
+	}
+	obj, err := p.objectAt(x.offset)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		return nil, fmt.Errorf("object %d is not a stream", num)
+	}
+	data, err := p.streamData(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok1 := p.resolve(stream.dict["N"]).(int64)
+	first, ok2 := p.resolve(stream.dict["First"]).(int64)
+	if !ok1 || !ok2 || n < 0 || n > maxPDFStreamObjs || first < 0 || first > int64(len(data)) {
+		return nil, fmt.Errorf("invalid object stream %d", num)
+	}
+
+	objs := make(map[int64]any, n)
+	header := &pdfLexer{buf: data[:first], eof: true}
+	for i := int64(0); i < n; i++ {
+		objNum, ok1 := header.next().(int64)
+		offset, ok2 := header.next().(int64)
+		if !ok1 || !ok2 || offset < 0 || first+offset > int64(len(data)) {
+			break
+		}
+		l := &pdfLexer{buf: data[first+offset:], eof: true}
+		if obj := l.next(); l.err == nil {
+			objs[objNum] = obj
+		}
+	}
+	p.objStms[num] = objs
+	return objs, nil
+}
+
+// text decodes a text string: UTF-16BE or UTF-8 with a byte order mark, otherwise
+// PDFDocEncoding
+func (p *pdfReader) text(v any) string {
+	s, ok := p.resolve(v).(pdfString)
+	if !ok {
+		return ""
+	}
+
+	var text string
+	switch {
+	case len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF:
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		text = string(utf16.Decode(units))
+	case len(s) >= 3 && s[0] == 0xEF && s[1] == 0xBB && s[2] == 0xBF:
+		text = strings.ToValidUTF8(string(s[3:]), "")
+	default:
+		runes := make([]rune, 0, len(s))
+		for _, c := range s {
+			if r, ok := pdfDocEncoding[c]; ok {
+				runes = append(runes, r)
+			} else {
+				runes = append(runes, rune(c))
+			}
+		}
+		text = string(runes)
+	}
+	return strings.TrimSpace(strings.TrimRight(text, "\x00"))
+}
+
+// pdfDocEncoding maps the bytes where PDFDocEncoding differs from Latin-1
+var pdfDocEncoding = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1A: 'ˆ', 0x1B: '˙', 0x1C: '˝', 0x1D: '˛', 0x1E: '˚', 0x1F: '˜',
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…', 0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8A: '−', 0x8B: '‰', 0x8C: '„', 0x8D: '“', 0x8E: '”', 0x8F: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ', 0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9A: 'ı', 0x9B: 'ł', 0x9C: 'œ', 0x9D: 'š', 0x9E: 'ž', 0xA0: '€',
+}
+
+// parsePDFDate parses a date like D:20230714183205+02'00'. Everything after the
+// year is optional; dates without a time zone are taken as UTC.
+func parsePDFDate(s string) *time.Time {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	digits := len(s) - len(strings.TrimLeft(s, "0123456789"))
+	if digits < 4 {
+		return nil
+	}
+	fields := []int{0, 1, 1, 0, 0, 0} // Year, month, day, hour, minute, second
+	pos := 0
+	for i, width := range []int{4, 2, 2, 2, 2, 2} {
+		if pos+width > digits {
+			break
+		}
+		fields[i], _ = strconv.Atoi(s[pos : pos+width])
+		pos += width
+	}
+
+	loc := time.UTC
+	if rest := s[digits:]; rest != "" && (rest[0] == '+' || rest[0] == '-') {
+		zone := strings.NewReplacer("'", "", ":", "").Replace(rest[1:])
+		hours, _ := strconv.Atoi(zone[:min(2, len(zone))])
+		minutes := 0
+		if len(zone) >= 4 {
+			minutes, _ = strconv.Atoi(zone[2:4])
+		}
+		offset := hours*3600 + minutes*60
+		if rest[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+
+	t := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, loc)
+	if t.Month() != time.Month(fields[1]) || fields[0] < 1000 {
+		return nil // Out of range fields such as month 13
+	}
+	return &t
+}
+
+// pdfLexer parses PDF objects from a buffer. eof tells whether the buffer ends at
+// the end of the file; otherwise running into its end is errPDFTruncated.
+type pdfLexer struct {
+	buf   []byte
+	pos   int
+	eof   bool
+	depth int
+	err   error
+}
+
+// isPDFSpace reports whether c is PDF white space
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+// isPDFDelimiter reports whether c ends a name, number or keyword
+func isPDFDelimiter(c byte) bool {
+	return isPDFSpace(c) || strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// fail records the first error; running into the end of the buffer is a truncation
+func (l *pdfLexer) fail(err error) any {
+	if l.err == nil {
+		if l.pos >= len(l.buf) && !l.eof {
+			err = errPDFTruncated
+		}
+		l.err = err
+	}
+	return nil
+}
+
+// skipSpace skips white space and comments
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.buf) && l.buf[l.pos] != '\r' && l.buf[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// token reads a run of regular characters
+func (l *pdfLexer) token() []byte {
+	start := l.pos
+	for l.pos < len(l.buf) && !isPDFDelimiter(l.buf[l.pos]) {
+		l.pos++
+	}
+	if l.pos >= len(l.buf) && !l.eof {
+		l.fail(errPDFTruncated)
+	}
+	return l.buf[start:l.pos]
+}
+
+// next parses the next object or keyword; "num gen R" becomes a pdfRef. It returns
+// nil and sets err at the end of the buffer or on invalid syntax.
+func (l *pdfLexer) next() any {
+	if l.err != nil {
+		return nil
+	}
+	l.skipSpace()
+	if l.pos >= len(l.buf) {
+		return l.fail(io.ErrUnexpectedEOF)
+	}
+
+	switch c := l.buf[l.pos]; {
+	case c == '/':
+		l.pos++
+		return pdfName(decodeName(l.token()))
+	case c == '(':
+		return l.literalString()
+	case c == '<' && l.pos+1 < len(l.buf) && l.buf[l.pos+1] == '<':
+		l.pos += 2
+		return l.dict()
+	case c == '<':
+		return l.hexString()
+	case c == '[':
+		l.pos++
+		return l.array()
+	case c == ']' || c == '>' || c == '{' || c == '}' || c == ')':
+		l.pos++
+		return pdfKeyword(c)
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return l.number()
+	}
+
+	tok := l.token()
+	if len(tok) == 0 {
+		return l.fail(fmt.Errorf("unexpected character %q", l.buf[l.pos]))
+	}
+	switch string(tok) {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	return pdfKeyword(tok)
+}
+
+// number parses an integer, a real or a reference
+func (l *pdfLexer) number() any {
+	tok := l.token()
+	if l.err != nil {
+		return nil
+	}
+	if bytes.ContainsAny(tok, ".") {
+		f, err := strconv.ParseFloat(string(tok), 64)
+		if err != nil {
+			return l.fail(fmt.Errorf("invalid number %q", tok))
+		}
+		return f
+	}
+	n, err := strconv.ParseInt(string(tok), 10, 64)
+	if err != nil {
+		return l.fail(fmt.Errorf("invalid number %q", tok))
+	}
+
+	// Look ahead for "gen R"
+	save := l.pos
+	l.skipSpace()
+	if l.pos < len(l.buf) && l.buf[l.pos] >= '0' && l.buf[l.pos] <= '9' {
+		gen, err := strconv.ParseInt(string(l.token()), 10, 64)
+		l.skipSpace()
+		if err == nil && l.err == nil && l.pos < len(l.buf) && l.buf[l.pos] == 'R' &&
+			(l.pos+1 == len(l.buf) || isPDFDelimiter(l.buf[l.pos+1])) {
+			l.pos++
+			return pdfRef{num: n, gen: gen}
+		}
+	}
+	if l.err == nil && l.pos >= len(l.buf) && !l.eof {
+		return l.fail(errPDFTruncated)
+	}
+	l.pos = save
+	return n
+}
+
+// dict parses the entries of a dictionary after <<
+func (l *pdfLexer) dict() any {
+	if l.depth++; l.depth > maxPDFDepth {
+		return l.fail(fmt.Errorf("objects nested too deeply"))
+	}
+	defer func() { l.depth-- }()
+
+	dict := make(pdfDict)
+	for {
+		l.skipSpace()
+		if l.pos+1 < len(l.buf) && l.buf[l.pos] == '>' && l.buf[l.pos+1] == '>' {
+			l.pos += 2
+			return dict
+		}
+		key := l.next()
+		if l.err != nil {
+			return nil
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return l.fail(fmt.Errorf("invalid dictionary key %v", key))
+		}
+		value := l.next()
+		if l.err != nil {
+			return nil
+		}
+		dict[name] = value
+	}
+}
+
+// array parses the elements of an array after [
+func (l *pdfLexer) array() any {
+	if l.depth++; l.depth > maxPDFDepth {
+		return l.fail(fmt.Errorf("objects nested too deeply"))
+	}
+	defer func() { l.depth-- }()
+
+	arr := []any{}
+	for {
+		v := l.next()
+		if l.err != nil {
+			return nil
+		}
+		if v == pdfKeyword("]") {
+			return arr
+		}
+		arr = append(arr, v)
+	}
+}
+
+// literalString parses a (string) with its escapes and balanced parentheses
+func (l *pdfLexer) literalString() any {
+	l.pos++
+	var s []byte
+	nesting := 1
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			nesting++
+		case ')':
+			if nesting--; nesting == 0 {
+				return pdfString(s)
+			}
+		case '\\':
+			if l.pos >= len(l.buf) {
+				return l.fail(io.ErrUnexpectedEOF)
+			}
+			c = l.buf[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.buf) && l.buf[l.pos] == '\n' {
+					l.pos++
+				}
+				continue // Line continuation
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.buf) && l.buf[l.pos] >= '0' && l.buf[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.buf[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return l.fail(io.ErrUnexpectedEOF)
+}
+
+// hexString parses a <hex string>
+func (l *pdfLexer) hexString() any {
+	l.pos++
+	var s []byte
+	digit := -1
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		l.pos++
+		var v int
+		switch {
+		case c == '>':
+			if digit >= 0 {
+				s = append(s, byte(digit<<4)) // An odd digit count implies a final 0
+			}
+			return pdfString(s)
+		case c >= '0' && c <= '9':
+			v = int(c - '0')
+		case c >= 'a' && c <= 'f':
+			v = int(c-'a') + 10
+		case c >= 'A' && c <= 'F':
+			v = int(c-'A') + 10
+		case isPDFSpace(c):
+			continue
+		default:
+			return l.fail(fmt.Errorf("invalid hex string"))
+		}
+		if digit < 0 {
+			digit = v
+		} else {
+			s = append(s, byte(digit<<4|v))
+			digit = -1
+		}
+	}
+	return l.fail(io.ErrUnexpectedEOF)
+}
+
+// decodeName replaces the #xx escapes of a name
+func decodeName(b []byte) string {
+	if bytes.IndexByte(b, '#') < 0 {
+		return string(b)
+	}
+	var s []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] == '#' && i+2 < len(b) {
+			if v, err := strconv.ParseUint(string(b[i+1:i+3]), 16, 8); err == nil {
+				s = append(s, byte(v))
+				i += 2
+				continue
+			}
+		}
+		s = append(s, b[i])
+	}
+	return string(s)
+}
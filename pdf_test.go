@@ -0,0 +1,135 @@
+// File: pdf_test.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pdfFile builds a PDF from the bodies of objects 1, 2, ... with a cross-reference
+// table and a trailer that has the given extra entries
+func pdfFile(trailer string, objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return b.Bytes()
+}
+
+// testPDFObjects are a catalog, a page tree of two pages and an information dictionary
+var testPDFObjects = []string{
+	"<< /Type /Catalog /Pages 2 0 R >>",
+	"<< /Type /Pages /Kids [3 0 R 3 0 R] /Count 2 >>",
+	"<< /Type /Page /Parent 2 0 R >>",
+	"<< /Title (Report \\(draft\\)) /Author <FEFF004A00F6> /CreationDate (D:20230714183205+02'00') >>",
+}
+
+func TestReadPDFInfo(t *testing.T) {
+	valid := pdfFile("/Info 4 0 R", testPDFObjects...)
+	noCatalog := pdfFile("", "<< /Type /Pages >>")
+	created := time.Date(2023, 7, 14, 18, 32, 5, 0, time.FixedZone("", 2*3600))
+
+	tests := []struct {
+		name  string
+		data  []byte
+		pages int // -1 when an error is expected
+		title string
+	}{
+		{"valid", valid, 2, "Report (draft)"},
+		{"junk before header", append([]byte("junk\n"), valid...), 2, "Report (draft)"},
+		{"wrong startxref", bytes.Replace(valid, []byte("startxref\n"), []byte("startxref\n1"), 1), 2, "Report (draft)"},
+		{"no cross-references", valid[:bytes.Index(valid, []byte("xref\n"))], 2, ""},
+		{"prev loop", pdfFile("/Info 4 0 R /Prev 0", testPDFObjects...), 2, "Report (draft)"},
+		{"no count", pdfFile("", strings.Replace(testPDFObjects[0], "2 0 R", "<< /Kids [3 0 R 3 0 R 3 0 R] >>", 1), "null", "<< /Type /Page >>"), 3, ""},
+		{"reference loop", pdfFile("/Info 2 0 R", "<< /Type /Catalog /Pages 2 0 R >>", "3 0 R", "2 0 R"), 0, ""},
+		{"deep nesting", pdfFile("/Info 2 0 R", "<< /Type /Catalog >>", "<< /Title "+strings.Repeat("[", 1000)+strings.Repeat("]", 1000)+" >>"), 0, ""},
+		{"unterminated string", pdfFile("/Info 2 0 R", "<< /Type /Catalog >>", "<< /Title (open >>"), 0, ""},
+		{"empty", nil, -1, ""},
+		{"not a PDF", []byte("%!PS-Adobe-3.0\n"), -1, ""},
+		{"header only", []byte("%PDF-1.4\n"), -1, ""},
+		{"no catalog", noCatalog[:bytes.Index(noCatalog, []byte("xref"))], -1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := readPDFInfo(writeTestFile(t, "a.pdf", tt.data))
+			if tt.pages < 0 {
+				if err == nil {
+					t.Errorf("readPDFInfo() = %+v, want an error", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readPDFInfo() error = %v", err)
+			}
+			if info.Pages != tt.pages || info.Title != tt.title {
+				t.Errorf("readPDFInfo() = %d pages, title %q, want %d, %q", info.Pages, info.Title, tt.pages, tt.title)
+			}
+		})
+	}
+
+	info, err := readPDFInfo(writeTestFile(t, "a.pdf", valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.7" || info.Author != "Jö" || info.Created == nil || !info.Created.Equal(created) {
+		t.Errorf("readPDFInfo() = %+v, want version 1.7, author Jö, created %v", info, created)
+	}
+
+	info, err = readPDFInfo(writeTestFile(t, "a.pdf", pdfFile("/Info 4 0 R /Encrypt << >>", testPDFObjects...)))
+	if err != nil || !info.Encrypted || info.Title != "" || info.Pages != 2 {
+		t.Errorf("readPDFInfo() of an encrypted file = %+v, %v", info, err)
+	}
+}
+
+func TestReadPDFInfoTruncated(t *testing.T) {
+	// Every prefix of a valid file must be read without panicking
+	data := pdfFile("/Info 4 0 R", testPDFObjects...)
+	p := writeTestFile(t, "a.pdf", nil)
+	for n := range len(data) {
+		if err := os.WriteFile(p, data[:n], 0644); err != nil {
+			t.Fatal(err)
+		}
+		readPDFInfo(p)
+	}
+}
+
+func TestParsePDFDate(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string // RFC 3339, empty for nil
+	}{
+		{"D:20230714183205+02'00'", "2023-07-14T18:32:05+02:00"},
+		{"D:20230714183205-05'30", "2023-07-14T18:32:05-05:30"},
+		{"D:20230714183205Z", "2023-07-14T18:32:05Z"},
+		{"D:2023", "2023-01-01T00:00:00Z"},
+		{"20230714", "2023-07-14T00:00:00Z"},
+		{"D:202", ""},
+		{"D:20231314", ""},
+		{"D:0999", ""},
+		{"D:2023+", "2023-01-01T00:00:00Z"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if ts := parsePDFDate(tt.value); ts != nil {
+			got = ts.Format(time.RFC3339)
+		}
+		if got != tt.expected {
+			t.Errorf("parsePDFDate(%q) = %q, want %q", tt.value, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// File: places.go
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Granularities of /api/places
+var placeGroups = map[string]bool{"country": true, "region": true, "city": true}
+
+// PlaceCount is one country, region or city with the number of files taken there
+type PlaceCount struct {
+	CountryCode string `json:"country_code"`
+	Country     string `json:"country"`
+	Region      string `json:"region,omitempty"` // Empty when grouped by country
+	City        string `json:"city,omitempty"`   // Only set when grouped by city
+	Count       int    `json:"count"`
+}
+
+// PlacesResponse is the JSON body returned by /api/places
+type PlacesResponse struct {
+	Group  string       `json:"group"`
+	Total  int          `json:"total"` // Files with a place that match the query
+	Places []PlaceCount `json:"places"`
+}
+
+// PlacesHandler lists the countries, regions or cities (group) the geotagged files
+// were taken in, most files first. The filters of /api/files apply, and its country,
+// region and city parameters list the files of one place.
+func PlacesHandler(index *MediaIndex) http.HandlerFunc {
+	cache := &listingCache{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q, err := parseFileQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group := r.URL.Query().Get("group")
+		if group == "" {
+			group = "city"
+		}
+		if !placeGroups[group] {
+			http.Error(w, fmt.Sprintf("invalid group %q", group), http.StatusBadRequest)
+			return
+		}
+
+		result := cache.get(index, q)
+
+		places := make([]PlaceCount, 0)
+		positions := make(map[PlaceCount]int)
+		total := 0
+		for i := range result {
+			p := result[i].Place
+			if p == nil {
+				continue
+			}
+			total++
+
+			key := PlaceCount{CountryCode: p.CountryCode, Country: p.Country}
+			if group != "country" {
+				key.Region = p.Region
+			}
+			if group == "city" {
+				key.City = p.City
+			}
+			if pos, ok := positions[key]; ok {
+				places[pos].Count++
+				continue
+			}
+			positions[key] = len(places)
+			key.Count = 1
+			places = append(places, key)
+		}
+
+		slices.SortFunc(places, func(a, b PlaceCount) int {
+			return cmp.Or(
+				cmp.Compare(b.Count, a.Count),
+				cmp.Compare(a.Country, b.Country),
+				cmp.Compare(a.Region, b.Region),
+				cmp.Compare(a.City, b.City),
+			)
+		})
+
+		writeJSON(w, PlacesResponse{Group: group, Total: total, Places: places})
+	}
+}
@@ -0,0 +1,149 @@
+// File: preview.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/u2takey/ffmpeg-go"
+)
+
+// Preview clip settings
+const (
+	previewSegments      = 4   // Points in the video the clip is sampled from
+	previewSegmentLength = 1.5 // Seconds taken from each point
+	previewWidth         = 320
+	previewFPS           = 15
+)
+
+// PreviewSemaphore limits concurrent preview clip generations separately from
+// thumbnails, as encoding a clip takes much longer than grabbing a frame
+var PreviewSemaphore chan struct{}
+
+// previewSegmentTimes returns where the segments of a preview clip start.
+// Videos too short to sample are previewed from the start in one segment.
+func previewSegmentTimes(duration float64) (starts []float64, length float64) {
+	if duration <= 2*previewSegments*previewSegmentLength {
+		return []float64{0}, min(duration, previewSegments*previewSegmentLength)
+	}
+	for i := range previewSegments {
+		starts = append(starts, duration*float64(i+1)/(previewSegments+1)-previewSegmentLength/2)
+	}
+	return starts, previewSegmentLength
+}
+
+// GeneratePreviewClip encodes a short, muted, low resolution MP4 of a video from
+// segments evenly spread over its length
+func GeneratePreviewClip(videoPath, outputPath string) error {
+	PreviewSemaphore <- struct{}{}
+	defer func() { <-PreviewSemaphore }()
+
+	// Another request may have made the clip while this one waited
+	if _, err := os.Stat(outputPath); err == nil {
+		return nil
+	}
+
+	duration := videoDuration(videoPath)
+	if duration <= 0 {
+		return fmt.Errorf("unknown video duration")
+	}
+	starts, length := previewSegmentTimes(duration)
+
+	segments := make([]*ffmpeg_go.Stream, len(starts))
+	for i, start := range starts {
+		segments[i] = ffmpeg_go.Input(videoPath, ffmpeg_go.KwArgs{"ss": start, "t": length}).
+			Video().
+			Filter("scale", ffmpeg_go.Args{fmt.Sprintf("%d:-2", previewWidth)}).
+			Filter("fps", ffmpeg_go.Args{fmt.Sprint(previewFPS)})
+	}
+
+	// Encode to a temporary file first so a half-written clip is never served
+	tmpPath := outputPath + ".tmp"
+	err := ffmpeg_go.Concat(segments).
+		Output(tmpPath, ffmpeg_go.KwArgs{
+			"format":   "mp4",
+			"vcodec":   "libx264",
+			"preset":   "veryfast",
+			"crf":      30,
+			"pix_fmt":  "yuv420p", // Playable in every browser
+			"movflags": "+faststart",
+			"an":       "", // No audio
+		}).
+		GlobalArgs("-loglevel", "quiet").
+		WithErrorOutput(getOutputWriter()).
+		OverWriteOutput().
+		Run()
+	if err == nil {
+		err = os.Rename(tmpPath, outputPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ffmpeg preview generation failed: %w", err)
+	}
+	return nil
+}
+
+// GetOrCreatePreview returns the path of the preview clip of a video, creating it
+// if needed. Clips are cached like thumbnails, by the video signature.
+func GetOrCreatePreview(videoPath string) (string, error) {
+	if !ThumbnailEnabled {
+		return "", fmt.Errorf("thumbnail generation is disabled")
+	}
+
+	signature, err := GetVideoSignature(videoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get video signature: %w", err)
+	}
+	key := GetSignatureHash(signature) + "-preview"
+
+	if cachedPath, exists := lookupThumbnail(key); exists {
+		return cachedPath, nil
+	}
+
+	if err := os.MkdirAll(ThumbnailConfig.CacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+
+	// A clip on disk but not in the cache is reused by GeneratePreviewClip
+	previewPath := filepath.Join(ThumbnailConfig.CacheDir, key+".mp4")
+	if err := GeneratePreviewClip(videoPath, previewPath); err != nil {
+		return "", err
+	}
+
+	storeThumbnail(key, videoPath, previewPath)
+	return previewPath, nil
+}
+
+// PreviewHandler serves the preview clip of a video for playing on hover
+func PreviewHandler(libs Libraries, index *MediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decodedPath, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/preview/"))
+		if err != nil {
+			http.Error(w, "Invalid path encoding", http.StatusBadRequest)
+			return
+		}
+
+		// The path is relative to /media; only indexed videos are accepted
+		file, videoPath, ok := libs.ResolveIndexed(index, decodedPath)
+		if !ok || file.Type != "video" {
+			http.Error(w, "Video not found", http.StatusNotFound)
+			return
+		}
+
+		if !ThumbnailEnabled {
+			http.Error(w, "Thumbnail generation is disabled", http.StatusNotFound)
+			return
+		}
+
+		previewPath, err := GetOrCreatePreview(videoPath)
+		if err != nil {
+			http.Error(w, "Failed to generate preview: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.ServeFile(w, r, previewPath)
+	}
+}
@@ -0,0 +1,365 @@
+// File: probe.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/u2takey/ffmpeg-go"
+)
+
+// VideoInfo is the stream information ffprobe reports for a video
+type VideoInfo struct {
+	Duration   float64    `json:"duration"`                // Seconds
+	Width      int        `json:"width,omitempty"`         // As displayed, after rotation
+	Height     int        `json:"height,omitempty"`        // As displayed, after rotation
+	FPS        float64    `json:"fps,omitempty"`           // Frames per second
+	VideoCodec string     `json:"video_codec,omitempty"`   // e.g. h264, hevc, vp9
+	AudioCodec string     `json:"audio_codec,omitempty"`   // Empty for videos without sound
+	Bitrate    int64      `json:"bitrate,omitempty"`       // Bits per second of the whole file
+	Rotation   int        `json:"rotation,omitempty"`      // Degrees clockwise the video is displayed rotated
+	Created    *time.Time `json:"creation_time,omitempty"` // Recording time from the container
+}
+
+// Video probing settings
+const (
+	probeTimeout   = 10 * time.Second
+	probeInterval  = 5 * time.Second // How often the index is checked for new videos
+	probeWorkers   = 2
+	probeCacheFile = "probe.json"
+	probeSaveEvery = 50 // Probes between cache saves
+)
+
+// VideoProber reads the metadata of every video once in the background. Results,
+// including failures, are cached by path, size and mtime in the thumbnail cache
+// directory so restarts do not probe again.
+type VideoProber struct {
+	index    *MediaIndex
+	libs     Libraries
+	cacheDir string
+	batch    *updateBatch
+
+	mu      sync.Mutex
+	cache   map[string]*VideoInfo // probeKey -> result, nil when ffprobe failed
+	pending int                   // Changes not yet saved
+}
+
+// videoProber is the running prober, nil when probing is disabled
+var videoProber *VideoProber
+
+// NewVideoProber creates a prober and loads its cache. It returns an error when
+// ffprobe is not installed.
+func NewVideoProber(index *MediaIndex, libs Libraries, cacheDir string) (*VideoProber, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return nil, fmt.Errorf("ffprobe not found: %w", err)
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	p := &VideoProber{
+		index:    index,
+		libs:     libs,
+		cacheDir: cacheDir,
+		batch:    newUpdateBatch(index),
+		cache:    make(map[string]*VideoInfo),
+	}
+	p.load()
+	return p, nil
+}
+
+// probeKey identifies one version of a file on disk
+func probeKey(fullPath string, size int64, modTime time.Time) string {
+	return fmt.Sprintf("%s|%d|%d", fullPath, size, modTime.UnixNano())
+}
+
+// load reads the cache file, starting empty when it is missing or corrupt
+func (p *VideoProber) load() {
+	data, err := os.ReadFile(filepath.Join(p.cacheDir, probeCacheFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading video probe cache: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &p.cache); err != nil {
+		log.Printf("Error parsing video probe cache: %v", err)
+		p.cache = make(map[string]*VideoInfo)
+	}
+	debugLog("Loaded %d entries from video probe cache", len(p.cache))
+}
+
+// save writes the cache file if it changed
+func (p *VideoProber) save() {
+	p.mu.Lock()
+	if p.pending == 0 {
+		p.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(p.cache)
+	p.pending = 0
+	p.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Error serializing video probe cache: %v", err)
+		return
+	}
+	// Write to a temporary file first so a crash never leaves a truncated cache
+	cacheFile := filepath.Join(p.cacheDir, probeCacheFile)
+	if err := os.WriteFile(cacheFile+".tmp", data, 0644); err != nil {
+		log.Printf("Error writing video probe cache: %v", err)
+		return
+	}
+	if err := os.Rename(cacheFile+".tmp", cacheFile); err != nil {
+		log.Printf("Error writing video probe cache: %v", err)
+	}
+}
+
+// Lookup returns the cached metadata of a video file, or nil if it was not probed yet
+func (p *VideoProber) Lookup(fullPath string) *VideoInfo {
+	if p == nil {
+		return nil
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cache[probeKey(fullPath, info.Size(), info.ModTime())]
+}
+
+// Run probes new videos whenever the index changes. It never returns.
+func (p *VideoProber) Run() {
+	done := ^uint64(0)
+	for {
+		files, version := p.index.Snapshot()
+		if version != done {
+			p.probeAll(files)
+			p.save()
+			// Our own updates change the version too, they need no second pass
+			_, done = p.index.Snapshot()
+		}
+		time.Sleep(probeInterval)
+	}
+}
+
+// probeJob is a video waiting to be probed
+type probeJob struct {
+	file     FileInfo
+	fullPath string
+	key      string
+}
+
+// probeAll applies cached results, probes the videos that have none and drops
+// the cached results of videos that are no longer indexed
+func (p *VideoProber) probeAll(files []FileInfo) {
+	defer p.batch.Flush()
+
+	var jobs []probeJob
+	current := make(map[string]bool)
+	for i := range files {
+		f := &files[i]
+		if f.Type != "video" {
+			continue
+		}
+		_, fullPath, ok := p.libs.Resolve(strings.TrimPrefix(f.Path, mediaURL))
+		if !ok {
+			continue
+		}
+		key := probeKey(fullPath, f.Size, f.Modified)
+		current[key] = true
+		if f.Video != nil {
+			continue
+		}
+
+		p.mu.Lock()
+		info, cached := p.cache[key]
+		p.mu.Unlock()
+
+		switch {
+		case !cached:
+			jobs = append(jobs, probeJob{file: *f, fullPath: fullPath, key: key})
+		case info != nil:
+			p.apply(f, info)
+		}
+	}
+
+	p.mu.Lock()
+	for key := range p.cache {
+		if !current[key] {
+			delete(p.cache, key)
+			p.pending++
+		}
+	}
+	p.mu.Unlock()
+
+	if len(jobs) == 0 {
+		return
+	}
+
+	debugLog("Probing %d videos", len(jobs))
+	queue := make(chan probeJob)
+	var wg sync.WaitGroup
+	for i := 0; i < probeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				info, err := probeVideo(job.fullPath)
+				if err != nil {
+					debugLog("Could not probe %s: %v", job.fullPath, err)
+				}
+
+				p.mu.Lock()
+				p.cache[job.key] = info
+				p.pending++
+				save := p.pending >= probeSaveEvery
+				p.mu.Unlock()
+
+				if info != nil {
+					p.apply(&job.file, info)
+				}
+				if save {
+					p.save()
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// apply queues storing a result in the index unless the file changed in the meantime
+func (p *VideoProber) apply(f *FileInfo, info *VideoInfo) {
+	p.batch.Add(f.Path, func(cur *FileInfo) bool {
+		if cur.Size != f.Size || !cur.Modified.Equal(f.Modified) {
+			return false
+		}
+		cur.Video = info
+		cur.Width, cur.Height = info.Width, info.Height
+		cur.setCaptureTime()
+		return true
+	})
+}
+
+// probeData is the part of the ffprobe JSON output we use
+type probeData struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		BitRate  string            `json:"bit_rate"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		Tags         map[string]string `json:"tags"`
+		Disposition  struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+		SideData []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// probeVideo runs ffprobe on a file
+func probeVideo(fullPath string) (*VideoInfo, error) {
+	// ffprobe's error output is captured into the returned error
+	data, err := ffmpeg_go.ProbeWithTimeout(fullPath, probeTimeout, ffmpeg_go.KwArgs{})
+	if err != nil {
+		return nil, err
+	}
+	return parseProbe(data)
+}
+
+// parseProbe converts the JSON output of ffprobe
+func parseProbe(data string) (*VideoInfo, error) {
+	var probe probeData
+	if err := json.Unmarshal([]byte(data), &probe); err != nil {
+		return nil, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+
+	info := &VideoInfo{}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	info.Bitrate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	created := probe.Format.Tags["creation_time"]
+
+	hasVideo := false
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			// Cover art in audio and MKV files is a single still image
+			if hasVideo || s.Disposition.AttachedPic != 0 {
+				continue
+			}
+			hasVideo = true
+			info.VideoCodec = s.CodecName
+			info.Width, info.Height = s.Width, s.Height
+			info.FPS = parseFrameRate(s.AvgFrameRate)
+			if info.FPS == 0 {
+				info.FPS = parseFrameRate(s.RFrameRate)
+			}
+
+			// Older files use a rotate tag, newer ones a display matrix rotated the other way
+			if v, err := strconv.Atoi(s.Tags["rotate"]); err == nil {
+				info.Rotation = v
+			} else if len(s.SideData) > 0 {
+				info.Rotation = -int(math.Round(s.SideData[0].Rotation))
+			}
+			info.Rotation = ((info.Rotation % 360) + 360) % 360
+			if info.Rotation == 90 || info.Rotation == 270 {
+				info.Width, info.Height = info.Height, info.Width
+			}
+
+			if created == "" {
+				created = s.Tags["creation_time"]
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = s.CodecName
+			}
+		}
+	}
+	if !hasVideo {
+		return nil, fmt.Errorf("no video stream")
+	}
+
+	// Cameras without a clock write the MP4 epoch
+	if ts, err := time.Parse(time.RFC3339Nano, created); err == nil && ts.Year() > 1970 {
+		info.Created = &ts
+	}
+	return info, nil
+}
+
+// parseFrameRate parses rates like "30000/1001"
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		v, _ := strconv.ParseFloat(rate, 64)
+		return v
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return math.Round(n/d*1000) / 1000
+}
@@ -0,0 +1,394 @@
+// File: scanner.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// racyWindow is how close to the scan start a directory mtime may be before it is not trusted.
+// Coarse file system timestamps could otherwise hide changes made right after the scan.
+const racyWindow = 2 * time.Second
+
+// scanProgressInterval is how often a running scan logs its progress
+const scanProgressInterval = 5 * time.Second
+
+// ScanStats summarizes the work done by a scan
+type ScanStats struct {
+	Dirs       int // Directories visited
+	ReusedDirs int // Directories taken from the stored index without listing them
+	Files      int // Files in the result
+}
+
+// ScanProgress tracks a running scan and can be read while it is in progress
+type ScanProgress struct {
+	dirs       atomic.Int64
+	reusedDirs atomic.Int64
+	files      atomic.Int64
+	started    atomic.Int64 // Unix nanoseconds, 0 before the first scan
+	finished   atomic.Int64 // Unix nanoseconds, 0 while scanning
+}
+
+// ScanStatus is the JSON body returned by /api/status
+type ScanStatus struct {
+	Scanning   bool    `json:"scanning"`
+	Dirs       int64   `json:"dirs"`
+	ReusedDirs int64   `json:"reused_dirs"`
+	Files      int64   `json:"files"`
+	Elapsed    float64 `json:"elapsed"` // Seconds since the scan started
+	Rate       float64 `json:"rate"`    // Files per second
+	Indexed    int     `json:"indexed"` // Files currently served by the index
+}
+
+// Start resets the counters for a new scan
+func (p *ScanProgress) Start() {
+	p.dirs.Store(0)
+	p.reusedDirs.Store(0)
+	p.files.Store(0)
+	p.finished.Store(0)
+	p.started.Store(time.Now().UnixNano())
+}
+
+// Finish marks the scan as completed
+func (p *ScanProgress) Finish() {
+	p.finished.Store(time.Now().UnixNano())
+}
+
+// Status returns a snapshot of the counters
+func (p *ScanProgress) Status() ScanStatus {
+	status := ScanStatus{
+		Dirs:       p.dirs.Load(),
+		ReusedDirs: p.reusedDirs.Load(),
+		Files:      p.files.Load(),
+	}
+
+	started := p.started.Load()
+	if started == 0 {
+		status.Scanning = true // Not started yet, but nothing is indexed either
+		return status
+	}
+
+	end := p.finished.Load()
+	if end == 0 {
+		status.Scanning = true
+		end = time.Now().UnixNano()
+	}
+	status.Elapsed = time.Duration(end - started).Seconds()
+	if status.Elapsed > 0 {
+		status.Rate = float64(status.Files) / status.Elapsed
+	}
+	return status
+}
+
+// logProgress periodically reports the scan progress until done is closed
+func (p *ScanProgress) logProgress(root string, done <-chan struct{}) {
+	ticker := time.NewTicker(scanProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			status := p.Status()
+			log.Printf("Scanning %s: %d directories, %d files (%.0f files/s)",
+				root, status.Dirs, status.Files, status.Rate)
+		}
+	}
+}
+
+// ScanOptions describes a root directory and how to index it
+type ScanOptions struct {
+	Name      string       // Name of the library root, empty for the legacy input directory
+	Root      string       // Directory on disk
+	BaseURL   string       // URL prefix the files are served under
+	Recursive bool         // Descend into subdirectories
+	Workers   int          // Directories scanned in parallel
+	Detect    bool         // Detect file types from their contents
+	Metadata  bool         // Extract embedded metadata such as EXIF
+	Ignore    *IgnoreRules // Configured ignore rules, may be nil
+	Links     *LinkPolicy  // Symlink policy, nil skips all symlinks
+}
+
+// scanJob is one directory waiting to be scanned
+type scanJob struct {
+	relDir string       // Directory relative to the root, with forward slashes
+	rules  *IgnoreRules // Rules inherited from the directories above
+	fresh  bool         // Do not reuse stored records, the inherited rules changed
+	linked bool         // Reached through a symlinked directory
+}
+
+// scanner walks a root directory with a pool of workers, one directory per job.
+// Directories reached through symlinks are only scanned once every real directory
+// is done, so a tree that links into itself is indexed under its real paths.
+type scanner struct {
+	opts     ScanOptions
+	prev     *IndexStore // Index from the previous run, may be nil
+	next     *IndexStore // Index being built by this scan
+	started  time.Time
+	progress *ScanProgress
+	visited  *dirTracker // Directories already scanned, used to break symlink loops
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []scanJob // Directories waiting to be scanned
+	linked  []scanJob // Symlinked directories waiting for the real ones to finish
+	pending int       // Directories queued or being scanned
+	real    int       // Real directories queued or being scanned
+	files   []FileInfo
+	err     error
+}
+
+// scanDirectory scans a directory for files using a pool of workers.
+// Directories whose mtime matches the stored index prev are not listed again;
+// their stored entries are reused. Ignored directories are never entered.
+// It returns the sorted files and the index to store for the next run.
+// progress may be nil.
+func scanDirectory(opts ScanOptions, prev *IndexStore, progress *ScanProgress) ([]FileInfo, *IndexStore, ScanStats, error) {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if progress == nil {
+		progress = &ScanProgress{}
+		progress.Start()
+	}
+
+	s := &scanner{
+		opts:     opts,
+		prev:     prev,
+		next:     newIndexStore(opts),
+		started:  time.Now(),
+		progress: progress,
+		visited:  newDirTracker(),
+		queue:    []scanJob{{relDir: "", rules: opts.Ignore}},
+		pending:  1,
+		real:     1,
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	done := make(chan struct{})
+	go progress.logProgress(opts.Root, done)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+	wg.Wait()
+	close(done)
+
+	stats := ScanStats{
+		Dirs:       len(s.next.Dirs),
+		ReusedDirs: int(progress.reusedDirs.Load()),
+		Files:      len(s.files),
+	}
+	if s.err != nil {
+		return nil, nil, stats, s.err
+	}
+
+	sortFiles(s.files)
+
+	return s.files, s.next, stats, nil
+}
+
+// work takes directories from the queue until the whole tree is done
+func (s *scanner) work() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && (len(s.linked) == 0 || s.real > 0) && s.pending > 0 {
+			s.cond.Wait()
+		}
+		if s.pending == 0 {
+			s.mu.Unlock()
+			return
+		}
+		var job scanJob
+		if len(s.queue) > 0 {
+			job = s.queue[len(s.queue)-1]
+			s.queue = s.queue[:len(s.queue)-1]
+		} else {
+			job = s.linked[len(s.linked)-1]
+			s.linked = s.linked[:len(s.linked)-1]
+		}
+		failed := s.err != nil
+		s.mu.Unlock()
+
+		var children, linked []scanJob
+		if !failed {
+			record, childJob, err := s.scanDir(job)
+
+			s.mu.Lock()
+			if err != nil {
+				if s.err == nil {
+					s.err = err
+				}
+			} else if record != nil {
+				s.next.Dirs[job.relDir] = record
+				s.files = append(s.files, record.Files...)
+			}
+			s.mu.Unlock()
+
+			if err == nil && record != nil && s.opts.Recursive {
+				for _, name := range record.Subdirs {
+					child := childJob
+					child.relDir = path.Join(job.relDir, name)
+					child.linked = job.linked || slices.Contains(record.Links, name)
+					if child.linked {
+						linked = append(linked, child)
+					} else {
+						children = append(children, child)
+					}
+				}
+			}
+		}
+
+		s.mu.Lock()
+		s.queue = append(s.queue, children...)
+		s.linked = append(s.linked, linked...)
+		s.pending += len(children) + len(linked) - 1
+		s.real += len(children)
+		if !job.linked {
+			s.real--
+		}
+		s.mu.Unlock()
+		s.cond.Broadcast()
+	}
+}
+
+// scanDir returns the record for one directory and the job template for its subdirectories.
+// The record is nil for a symlinked directory that was already scanned under another path.
+func (s *scanner) scanDir(job scanJob) (*dirRecord, scanJob, error) {
+	fullPath := filepath.Join(s.opts.Root, filepath.FromSlash(job.relDir))
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if job.linked {
+			debugLog("Skipping symlinked directory %s: %v", fullPath, err)
+			return nil, job, nil
+		}
+		return nil, job, err
+	}
+
+	if s.opts.Links.following() && !s.visited.Visit(job.relDir, fullPath, info) {
+		debugLog("Skipping %s, the directory is already indexed under another path", fullPath)
+		return nil, job, nil
+	}
+
+	var record *dirRecord
+	if !job.fresh {
+		record = s.prev.lookup(job.relDir, info.ModTime())
+		// An ignore file edited in place does not change the directory mtime
+		if record != nil && !record.IgnoreModTime.IsZero() &&
+			!ignoreFileModTime(fullPath).Equal(record.IgnoreModTime) {
+			record = nil
+		}
+		// So does a sidecar written by an editor
+		if record != nil && s.opts.Metadata && record.sidecarsChanged(fullPath) {
+			record = nil
+		}
+	}
+
+	if record != nil {
+		s.progress.reusedDirs.Add(1)
+		job.rules = job.rules.With(job.relDir, record.IgnorePatterns)
+	} else {
+		patterns, ignoreModTime, err := readIgnoreFile(fullPath)
+		if err != nil {
+			log.Printf("Could not read %s: %v", filepath.Join(fullPath, ignoreFileName), err)
+		}
+		job.rules = job.rules.With(job.relDir, patterns)
+
+		record, err = s.listDir(job.relDir, fullPath, info.ModTime(), job.rules)
+		if err != nil {
+			return nil, job, err
+		}
+		record.IgnorePatterns = patterns
+		record.IgnoreModTime = ignoreModTime
+
+		// Stored records below this directory were filtered with different rules
+		if stored := s.prev.record(job.relDir); stored != nil && !slices.Equal(stored.IgnorePatterns, patterns) {
+			job.fresh = true
+		}
+	}
+
+	s.progress.dirs.Add(1)
+	s.progress.files.Add(int64(len(record.Files)))
+	return record, job, nil
+}
+
+// listDir reads a directory from disk and builds its index record
+func (s *scanner) listDir(relDir, fullPath string, modTime time.Time, rules *IgnoreRules) (*dirRecord, error) {
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &dirRecord{ModTime: modTime}
+	if modTime.After(s.started.Add(-racyWindow)) {
+		// Changed while we were looking; make sure the next run lists it again
+		record.ModTime = time.Time{}
+	}
+
+	sidecars := sidecarNames(entries)
+	for _, entry := range entries {
+		name := entry.Name()
+		relPath := path.Join(relDir, name)
+
+		info, linked, ok := s.opts.Links.Entry(fullPath, entry)
+		if !ok {
+			continue
+		}
+
+		if info.IsDir() {
+			// Prune ignored directories instead of filtering their contents later
+			if !rules.Ignored(relPath, true) {
+				record.Subdirs = append(record.Subdirs, name)
+				if linked {
+					record.Links = append(record.Links, name)
+				}
+			}
+			continue
+		}
+		// Sidecars are shown as part of the file they describe
+		if isSidecar(name) || rules.Ignored(relPath, false) {
+			continue
+		}
+		f := newFileInfo(s.opts.Name, s.opts.BaseURL, filepath.FromSlash(relPath), info)
+		inspectFile(s.opts, &f, filepath.Join(fullPath, name))
+		if s.opts.Metadata {
+			attachSidecar(&f, fullPath, sidecars)
+		}
+		record.Files = append(record.Files, f)
+	}
+
+	return record, nil
+}
+
+// inspectFile fills in the details of f that are read from the file's contents
+func inspectFile(opts ScanOptions, f *FileInfo, fullPath string) {
+	if opts.Detect {
+		detectContent(f, fullPath)
+	}
+	if opts.Metadata {
+		extractMetadata(f, fullPath)
+		f.setCaptureTime()
+	}
+}
+
+// ScanStatusHandler reports the progress of the initial scan
+func ScanStatusHandler(progress *ScanProgress, index *MediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := progress.Status()
+		status.Indexed = index.Len()
+		writeJSON(w, status)
+	}
+}
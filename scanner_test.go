@@ -0,0 +1,144 @@
+// File: scanner_test.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// symlink creates a symbolic link or skips the test where that is not possible
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+}
+
+// scanPaths scans root and returns the sorted web paths found
+func scanPaths(t *testing.T, opts ScanOptions) []string {
+	t.Helper()
+	files, _, _, err := scanDirectory(opts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestScanDirectorySymlinkedDirs(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, root, outside string)
+		external bool
+		expected []string
+	}{
+		{"link into the tree is indexed under the real path", func(t *testing.T, root, outside string) {
+			// "alias" sorts before "real", so a plain walk would reach it first
+			symlink(t, filepath.Join(root, "real"), filepath.Join(root, "alias"))
+		}, false, []string{"/media/real/a.jpg", "/media/real/sub/b.jpg"}},
+		{"link to a subdirectory", func(t *testing.T, root, outside string) {
+			symlink(t, filepath.Join(root, "real", "sub"), filepath.Join(root, "a-sub"))
+		}, false, []string{"/media/real/a.jpg", "/media/real/sub/b.jpg"}},
+		{"loop back to the root", func(t *testing.T, root, outside string) {
+			symlink(t, root, filepath.Join(root, "real", "sub", "loop"))
+		}, false, []string{"/media/real/a.jpg", "/media/real/sub/b.jpg"}},
+		{"link outside the root is skipped", func(t *testing.T, root, outside string) {
+			symlink(t, outside, filepath.Join(root, "ext"))
+		}, false, []string{"/media/real/a.jpg", "/media/real/sub/b.jpg"}},
+		{"link outside the root is followed when allowed", func(t *testing.T, root, outside string) {
+			symlink(t, outside, filepath.Join(root, "ext"))
+		}, true, []string{"/media/ext/o.jpg", "/media/real/a.jpg", "/media/real/sub/b.jpg"}},
+		{"broken link", func(t *testing.T, root, outside string) {
+			symlink(t, filepath.Join(root, "missing"), filepath.Join(root, "broken"))
+		}, false, []string{"/media/real/a.jpg", "/media/real/sub/b.jpg"}},
+	}
+
+	for _, tt := range tests {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s, %d workers", tt.name, workers), func(t *testing.T) {
+				root, outside := t.TempDir(), t.TempDir()
+				writeTree(t, root, map[string]string{"real/a.jpg": "a", "real/sub/b.jpg": "b"})
+				writeTree(t, outside, map[string]string{"o.jpg": "o"})
+				tt.setup(t, root, outside)
+
+				opts := ScanOptions{Root: root, BaseURL: "/media", Recursive: true, Workers: workers,
+					Links: NewLinkPolicy(root, true, tt.external)}
+				if got := scanPaths(t, opts); !slices.Equal(got, tt.expected) {
+					t.Errorf("found %v, want %v", got, tt.expected)
+				}
+			})
+		}
+	}
+}
+
+func TestScanDirectoryDuplicateLinks(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	writeTree(t, outside, map[string]string{"o.jpg": "o"})
+	symlink(t, outside, filepath.Join(root, "ext1"))
+	os.Mkdir(filepath.Join(root, "sub"), 0755)
+	symlink(t, outside, filepath.Join(root, "sub", "ext2"))
+
+	// A directory linked twice is indexed once, under either link
+	opts := ScanOptions{Root: root, BaseURL: "/media", Recursive: true, Workers: 2, Links: NewLinkPolicy(root, true, true)}
+	got := scanPaths(t, opts)
+	if len(got) != 1 || (got[0] != "/media/ext1/o.jpg" && got[0] != "/media/sub/ext2/o.jpg") {
+		t.Errorf("found %v, want o.jpg once", got)
+	}
+}
+
+func TestScanDirectoryProgress(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.jpg": "a", "x/b.jpg": "b", "x/y/c.jpg": "c", "z/d.jpg": "d"})
+
+	progress := &ScanProgress{}
+	progress.Start()
+	files, _, stats, err := scanDirectory(ScanOptions{Root: root, BaseURL: "/media", Recursive: true, Workers: 3}, nil, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress.Finish()
+
+	status := progress.Status()
+	if len(files) != 4 || stats.Files != 4 || stats.Dirs != 4 || status.Files != 4 || status.Dirs != 4 || status.Scanning {
+		t.Errorf("files %d, stats %+v, status %+v, want 4 files in 4 directories", len(files), stats, status)
+	}
+
+	// Without recursion only the root is listed
+	files, _, stats, err = scanDirectory(ScanOptions{Root: root, BaseURL: "/media", Workers: 3}, nil, nil)
+	if err != nil || len(files) != 1 || stats.Dirs != 1 {
+		t.Errorf("non-recursive scan found %d files in %d directories, %v", len(files), stats.Dirs, err)
+	}
+
+	if _, _, _, err := scanDirectory(ScanOptions{Root: filepath.Join(root, "missing"), Workers: 2}, nil, nil); err == nil {
+		t.Error("scanning a missing root returned no error")
+	}
+}
+
+func TestMediaIndexReset(t *testing.T) {
+	index := NewMediaIndex([]FileInfo{{Name: "a.jpg", Path: "/media/a.jpg", Dir: "/media"}})
+	files, before := index.Snapshot()
+	if len(files) != 1 {
+		t.Fatalf("Snapshot() = %d files, want 1", len(files))
+	}
+
+	index.Reset(nil)
+	files, after := index.Snapshot()
+	if len(files) != 0 || after == before {
+		t.Errorf("after Reset(nil): %d files at version %d, was %d", len(files), after, before)
+	}
+	if len(index.FilesInDir("/media")) != 0 || index.Len() != 0 {
+		t.Error("Reset(nil) left entries behind")
+	}
+
+	index.Reset([]FileInfo{{Name: "b.jpg", Path: "/media/b.jpg", Dir: "/media"}})
+	if files := index.Files(); len(files) != 1 || files[0].Name != "b.jpg" {
+		t.Errorf("after Reset: %v, want b.jpg", files)
+	}
+}
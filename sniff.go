@@ -0,0 +1,191 @@
+// File: sniff.go
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// sniffLen is how much of a file is read to detect its type, the same as http.DetectContentType uses
+const sniffLen = 512
+
+// detectContent reads the start of the file at fullPath and updates the MIME type and
+// category of f. Unknown contents keep the category from the extension, and so do
+// categories marked MimeFallback, since many formats are zip or text files underneath
+// (docx, epub, source code).
+func detectContent(f *FileInfo, fullPath string) {
+	head, err := readHead(fullPath)
+	if err != nil {
+		debugLog("Could not read %s to detect its type: %v", fullPath, err)
+	}
+
+	mimeType := sniffContent(head, f.Extension)
+	if mimeType == "" {
+		return // Nothing recognizable, the extension decides
+	}
+
+	category := categories.ForMimeType(mimeType)
+	switch {
+	case category != nil && !category.MimeFallback:
+		// A generic MP4 container with an audio extension is an audio file
+		if category.Name == "video" && f.Type == "audio" && mimeType == "video/mp4" {
+			f.MimeType = "audio/mp4"
+			return
+		}
+		f.Type = category.Name
+	case f.Extension == "":
+		if category != nil {
+			f.Type = category.Name
+		}
+	case f.MimeType != "":
+		return // The extension is more specific than "a zip file" or "plain text"
+	}
+	f.MimeType = mimeType
+}
+
+// readHead returns the first sniffLen bytes of a file
+func readHead(fullPath string) ([]byte, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// extensionMimeType returns the MIME type registered for an extension without parameters
+func extensionMimeType(ext string) string {
+	if ext == "" {
+		return ""
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension("."+strings.ToLower(ext)), ";")
+	return strings.TrimSpace(mimeType)
+}
+
+// sniffContent detects the MIME type of a file from its first bytes. It returns ""
+// when the contents are not recognized. ext disambiguates RAW formats that share
+// the TIFF layout.
+func sniffContent(head []byte, ext string) string {
+	if len(head) == 0 {
+		return ""
+	}
+	if mimeType := sniffSignature(head, strings.ToLower(ext)); mimeType != "" {
+		return mimeType
+	}
+
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	if mimeType == "application/octet-stream" {
+		return ""
+	}
+	return mimeType
+}
+
+// sniffSignature recognizes the formats http.DetectContentType does not know
+func sniffSignature(head []byte, ext string) string {
+	switch {
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return sniffISOBMFF(head)
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return sniffMatroska(head, ext)
+	case len(head) >= 12 && string(head[:4]) == "RIFF":
+		return sniffRIFF(head)
+	case bytes.HasPrefix(head, []byte("FUJIFILMCCD-RAW")):
+		return "image/x-fuji-raf"
+	case bytes.HasPrefix(head, []byte("IIRO")), bytes.HasPrefix(head, []byte("IIRS")):
+		return "image/x-olympus-orf"
+	case bytes.HasPrefix(head, []byte("IIU\x00")):
+		return "image/x-panasonic-rw2"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return sniffTIFF(head, ext)
+	case bytes.HasPrefix(head, []byte("7z\xBC\xAF\x27\x1C")):
+		return "application/x-7z-compressed"
+	}
+	return ""
+}
+
+// sniffISOBMFF identifies MP4, QuickTime, HEIF/AVIF and CR3 files by their ftyp brands
+func sniffISOBMFF(head []byte) string {
+	brands := []string{string(head[8:12])}
+
+	// Compatible brands follow the minor version
+	boxSize := int(head[0])<<24 | int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	boxSize = min(boxSize, len(head))
+	for i := 16; i+4 <= boxSize; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+
+	for _, brand := range brands {
+		switch brand {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			return "image/heic"
+		case "crx ":
+			return "image/x-canon-cr3"
+		case "qt  ":
+			return "video/quicktime"
+		case "M4A ", "M4B ", "M4P ":
+			return "audio/mp4"
+		case "3gp4", "3gp5", "3gp6", "3g2a":
+			return "video/3gpp"
+		}
+	}
+	if brands[0] == "mif1" || brands[0] == "msf1" {
+		return "image/heif"
+	}
+	return "video/mp4"
+}
+
+// sniffMatroska tells WebM from other Matroska files by the EBML DocType
+func sniffMatroska(head []byte, ext string) string {
+	if bytes.Contains(head, []byte("webm")) {
+		return "video/webm"
+	}
+	if ext == "mka" {
+		return "audio/x-matroska"
+	}
+	return "video/x-matroska"
+}
+
+// sniffRIFF identifies the common RIFF containers
+func sniffRIFF(head []byte) string {
+	switch string(head[8:12]) {
+	case "WAVE":
+		return "audio/wav"
+	case "AVI ":
+		return "video/x-msvideo"
+	case "WEBP":
+		return "image/webp"
+	}
+	return ""
+}
+
+// sniffTIFF identifies TIFF based RAW formats, which only differ in their extension
+func sniffTIFF(head []byte, ext string) string {
+	if len(head) >= 10 && string(head[8:10]) == "CR" {
+		return "image/x-canon-cr2"
+	}
+	switch ext {
+	case "cr2":
+		return "image/x-canon-cr2"
+	case "nef", "nrw":
+		return "image/x-nikon-nef"
+	case "arw", "srf", "sr2":
+		return "image/x-sony-arw"
+	case "dng":
+		return "image/x-adobe-dng"
+	case "pef":
+		return "image/x-pentax-pef"
+	}
+	return "image/tiff"
+}
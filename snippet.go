@@ -0,0 +1,252 @@
+// File: snippet.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Limits of /api/snippet
+const (
+	defaultSnippetLines = 30
+	maxSnippetLines     = 5000
+	maxSnippetBytes     = 512 << 10 // Raw bytes of content returned at most, long lines are cut
+	snippetChunkSize    = 64 << 10  // Bytes read at once while counting lines
+	maxCountBytes       = 16 << 20  // Bytes lines are counted in, longer files get an estimate
+)
+
+// Text encodings reported by /api/snippet
+const (
+	encodingUTF8    = "utf-8"
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+	encodingLatin1  = "latin-1"
+)
+
+// Snippet is the JSON body returned by /api/snippet
+type Snippet struct {
+	Content    string `json:"content"`     // The first lines, transcoded to UTF-8
+	Lines      int    `json:"lines"`       // Lines in Content
+	TotalLines int    `json:"total_lines"` // Lines in the whole file
+	Encoding   string `json:"encoding"`    // Encoding of the file: utf-8, utf-16le, utf-16be or latin-1
+	Truncated  bool   `json:"truncated"`   // Content is not the whole file
+	// TotalLines is estimated from the first maxCountBytes of longer files
+	Estimated bool `json:"total_lines_estimated,omitempty"`
+}
+
+// readSnippet returns the first maxLines lines of a text file together with its
+// line count, which is estimated for files longer than maxCountBytes. UTF-16 is recognized by its byte order mark; text without one is
+// UTF-8 when the first lines are valid UTF-8 and Latin-1 otherwise.
+func readSnippet(fullPath string, maxLines int) (*Snippet, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	s := &Snippet{Encoding: encodingUTF8}
+	bom := make([]byte, 3)
+	n, err := io.ReadFull(file, bom)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	bom = bom[:n]
+
+	// Newlines are one byte, or one code unit in UTF-16
+	unit, newline := 1, []byte{'\n'}
+	skip := 0
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		skip = 3
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}):
+		s.Encoding, unit, newline, skip = encodingUTF16LE, 2, []byte{'\n', 0}, 2
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		s.Encoding, unit, newline, skip = encodingUTF16BE, 2, []byte{0, '\n'}, 2
+	}
+	if _, err := file.Seek(int64(skip), io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var head []byte // Raw bytes of the first lines
+	headDone := maxLines == 0
+	lines := 0          // Newlines seen
+	lastNewline := true // The file is empty or ends with a newline
+	buf := make([]byte, snippetChunkSize)
+	carry := 0          // Bytes of an incomplete code unit kept at the start of buf
+	read := int64(skip) // Bytes read so far
+
+	for {
+		n, err := io.ReadFull(file, buf[carry:])
+		read += int64(n)
+		n += carry
+		if n == 0 {
+			break
+		}
+		chunk := buf[:n-n%unit]
+
+		for pos := 0; pos < len(chunk); pos += unit {
+			if headDone && unit == 1 {
+				lines += bytes.Count(chunk[pos:], newline)
+				break
+			}
+			i := indexUnit(chunk[pos:], newline, unit)
+			if i < 0 {
+				if !headDone {
+					head = append(head, chunk[pos:]...)
+				}
+				break
+			}
+			lines++
+			if !headDone {
+				head = append(head, chunk[pos:pos+i+unit]...)
+				if lines >= maxLines {
+					headDone = true
+				}
+			}
+			pos += i
+		}
+		if len(chunk) > 0 {
+			lastNewline = bytes.HasSuffix(chunk, newline)
+		}
+		if len(head) > maxSnippetBytes {
+			head = head[:maxSnippetBytes-maxSnippetBytes%unit]
+			headDone, s.Truncated = true, true
+		}
+
+		carry = copy(buf, buf[len(chunk):n])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if headDone && read >= maxCountBytes {
+			s.Estimated = true
+			break
+		}
+	}
+
+	s.TotalLines = lines
+	if s.Estimated {
+		// Assume the rest of the file has lines as long as the part that was read
+		if info, err := file.Stat(); err == nil && read > 0 {
+			s.TotalLines = int(float64(lines) * float64(info.Size()) / float64(read))
+		}
+		s.TotalLines = max(s.TotalLines, lines+1)
+	} else if !lastNewline {
+		s.TotalLines++ // The last line has no newline
+	}
+
+	if s.Encoding == encodingUTF8 && skip == 0 && !validUTF8Prefix(head, s.Truncated) {
+		s.Encoding = encodingLatin1
+	}
+	s.Content = decodeText(head, s.Encoding)
+	s.Lines = strings.Count(s.Content, "\n")
+	if s.Content != "" && !strings.HasSuffix(s.Content, "\n") {
+		s.Lines++
+	}
+	if s.Lines < s.TotalLines {
+		s.Truncated = true
+	}
+	return s, nil
+}
+
+// indexUnit returns the offset of the first newline that starts at a code unit boundary
+func indexUnit(b, newline []byte, unit int) int {
+	if unit == 1 {
+		return bytes.IndexByte(b, newline[0])
+	}
+	for i := 0; i+unit <= len(b); i += unit {
+		if b[i] == newline[0] && b[i+1] == newline[1] {
+			return i
+		}
+	}
+	return -1
+}
+
+// validUTF8Prefix reports whether b is valid UTF-8. When b was cut from a longer
+// text, an incomplete character at its end is allowed.
+func validUTF8Prefix(b []byte, cut bool) bool {
+	if cut {
+		for i := 0; i < utf8.UTFMax-1 && i < len(b); i++ {
+			if utf8.RuneStart(b[len(b)-1-i]) {
+				if !utf8.FullRune(b[len(b)-1-i:]) {
+					b = b[:len(b)-1-i]
+				}
+				break
+			}
+		}
+	}
+	return utf8.Valid(b)
+}
+
+// decodeText converts text in a supported encoding to UTF-8
+func decodeText(b []byte, encoding string) string {
+	switch encoding {
+	case encodingUTF16LE, encodingUTF16BE:
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			if encoding == encodingUTF16LE {
+				units[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+			} else {
+				units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+			}
+		}
+		return string(utf16.Decode(units))
+	case encodingLatin1:
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	}
+	// A character cut at the end of the snippet is dropped
+	return strings.ToValidUTF8(string(b), "")
+}
+
+// SnippetHandler returns the first lines of a text file, so previews do not have
+// to download large files. Parameters: path, the web path of the file, and lines.
+func SnippetHandler(libs Libraries, index *MediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		values := r.URL.Query()
+		maxLines := defaultSnippetLines
+		if v := values.Get("lines"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid lines %q", v), http.StatusBadRequest)
+				return
+			}
+			maxLines = min(n, maxSnippetLines)
+		}
+
+		// Only indexed files that may be served, so ignored files stay hidden
+		f, fullPath, ok := libs.ResolveIndexed(index, strings.TrimPrefix(values.Get("path"), mediaURL))
+		if !ok {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		if f.Type != "text" && f.Type != "code" && !strings.HasPrefix(f.MimeType, "text/") {
+			http.Error(w, "Snippets are only available for text and code files", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		snippet, err := readSnippet(fullPath, maxLines)
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, snippet)
+	}
+}
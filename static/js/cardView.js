@@ -32,9 +32,10 @@ function createCardElement(file, i, startIndex) {
       break;
     case "audio":
       div.innerHTML += `<audio controls src="${file.path}" preload="metadata"></audio>`;
+      if (file.audio) div.appendChild(createTrackInfo(file));
       break;
     case "pdf":
-      div.innerHTML += `<iframe src="${file.path}" title="${file.name}"></iframe>`;
+      appendPdfContent(div, file);
       break;
     case "code":
     case "text":
@@ -49,6 +50,32 @@ function createCardElement(file, i, startIndex) {
   return div;
 }
 
+/**
+ * Append a PDF preview with the document title, author and page count to a card
+ * @param {HTMLElement} div - Card element
+ * @param {Object} file - File data
+ */
+function appendPdfContent(div, file) {
+  div.innerHTML += `<iframe src="${file.path}" title="${file.name}"></iframe>`;
+  if (!file.pdf) return;
+
+  const info = document.createElement("div");
+  info.className = "pdf-info";
+  const parts = [file.pdf.title, file.pdf.author]
+    .filter(Boolean)
+    .map((part) => escapeHtml(part));
+  if (file.pdf.pages) {
+    parts.push(`${file.pdf.pages} page${file.pdf.pages === 1 ? "" : "s"}`);
+  }
+  info.innerHTML = parts.join(" • ");
+
+  const button = document.createElement("button");
+  button.textContent = "Details";
+  button.onclick = () => showPdfModal(file);
+  info.appendChild(button);
+  div.appendChild(info);
+}
+
 /**
  * Append image content to a card
  * @param {HTMLElement} div - Card element
@@ -73,7 +100,11 @@ function appendImageContent(div, file, imageIndex) {
   const img = document.createElement("img");
   img.style.opacity = "0"; // Start hidden
   img.setAttribute("loading", "lazy");
-  img.dataset.src = file.path; // Store path but don't load immediately
+  // Store path but don't load immediately; the modal still shows the full image
+  const thumbnailUrl = `/thumbnail/${encodeURIComponent(file.path.substring(7))}`;
+  img.dataset.src = imageThumbnails
+    ? `${thumbnailUrl}?w=${imageThumbWidths[currentZoom]}&fit=width`
+    : file.path;
 
   // Set click handler
   img.onclick = function () {
@@ -171,17 +202,11 @@ function appendOtherContent(div, file) {
  */
 async function renderTextPreview(file, div) {
   try {
-    const response = await fetch(file.path);
-    if (!response.ok) throw new Error(`HTTP error ${response.status}`);
-    const text = await response.text();
-
     // Limit preview to 30 lines or 3000 characters
-    const contentPreview = text
-      .split("\n")
-      .slice(0, 30)
-      .join("\n")
-      .substring(0, 3000);
-    const hasMore = text.length > contentPreview.length;
+    const snippet = await fetchSnippet(file, 30);
+    const contentPreview = snippet.content.substring(0, 3000);
+    const hasMore =
+      snippet.truncated || snippet.content.length > contentPreview.length;
 
     const previewDiv = document.createElement("div");
 
@@ -284,6 +309,9 @@ function appendVideoContent(div, file, videoIndex) {
   // Create container
   const videoContainer = document.createElement("div");
   videoContainer.className = "video-container";
+  if (file.width && file.height) {
+    videoContainer.style.aspectRatio = getImageAspectRatio(file);
+  }
 
   // Create a visually pleasing placeholder
   const placeholder = document.createElement("div");
@@ -374,6 +402,8 @@ function appendVideoContent(div, file, videoIndex) {
     }
 
     placeholder.appendChild(thumbnailImg);
+    attachStoryboardScrub(placeholder, file);
+    attachPreviewClip(placeholder, file);
   } else {
     placeholder.classList.add("loaded");
   }
@@ -386,6 +416,106 @@ function appendVideoContent(div, file, videoIndex) {
   videoContainer.appendChild(placeholder);
   div.appendChild(videoContainer);
 }
+
+/**
+ * Scrub through a video by moving the mouse over its card. The storyboard is
+ * loaded on the first hover and shows the frame under the mouse position.
+ * @param {HTMLElement} placeholder - Video placeholder of the card
+ * @param {Object} file - File data
+ */
+function attachStoryboardScrub(placeholder, file) {
+  let cues = null; // Loaded on the first hover, empty without a storyboard
+  let loading = false;
+
+  const frame = document.createElement("div");
+  frame.className = "storyboard-frame";
+  const progress = document.createElement("div");
+  progress.className = "storyboard-progress";
+  placeholder.append(frame, progress);
+
+  placeholder.addEventListener("mousemove", (e) => {
+    if (cues === null) {
+      if (!loading) {
+        loading = true;
+        fetchStoryboard(file)
+          .then((loaded) => (cues = loaded))
+          .catch((err) => {
+            window.debugLog("No storyboard:", err);
+            cues = [];
+          });
+      }
+      return;
+    }
+    if (!cues.length) return;
+
+    const rect = placeholder.getBoundingClientRect();
+    const fraction = Math.min(
+      Math.max((e.clientX - rect.left) / rect.width, 0),
+      1,
+    );
+    const time = fraction * cues[cues.length - 1].end;
+    const cue = cues.find((c) => time < c.end) || cues[cues.length - 1];
+
+    // Scale the sheet so one frame fills the card
+    const columns = Math.max(...cues.map((c) => c.x / c.w)) + 1;
+    const rows = Math.max(...cues.map((c) => c.y / c.h)) + 1;
+    const x = columns > 1 ? (cue.x / cue.w / (columns - 1)) * 100 : 0;
+    const y = rows > 1 ? (cue.y / cue.h / (rows - 1)) * 100 : 0;
+    frame.style.backgroundImage = `url("${cue.src}")`;
+    frame.style.backgroundSize = `${columns * 100}% ${rows * 100}%`;
+    frame.style.backgroundPosition = `${x}% ${y}%`;
+    frame.style.display = "block";
+    progress.style.width = `${fraction * 100}%`;
+  });
+
+  placeholder.addEventListener("mouseleave", () => {
+    frame.style.display = "none";
+    progress.style.width = "0";
+  });
+}
+
+/**
+ * Play the preview clip of a video while the mouse rests on its card. Moving
+ * the mouse stops it again, so the storyboard can be scrubbed.
+ * @param {HTMLElement} placeholder - Video placeholder of the card
+ * @param {Object} file - File data
+ */
+function attachPreviewClip(placeholder, file) {
+  let preview = null;
+  let timer = null;
+  let failed = false; // The server has no clip for this video
+
+  const stop = () => {
+    clearTimeout(timer);
+    if (preview) {
+      preview.pause();
+      preview.remove();
+      preview = null;
+    }
+  };
+
+  placeholder.addEventListener("mousemove", () => {
+    stop();
+    if (failed) return;
+    timer = setTimeout(() => {
+      preview = document.createElement("video");
+      preview.className = "preview-clip";
+      preview.src = `/preview/${encodeURIComponent(file.path.substring(7))}`;
+      preview.muted = true;
+      preview.loop = true;
+      preview.autoplay = true;
+      preview.playsInline = true;
+      preview.onerror = () => {
+        failed = true;
+        stop();
+      };
+      placeholder.appendChild(preview);
+    }, 700);
+  });
+
+  placeholder.addEventListener("mouseleave", stop);
+}
+
 /**
  * Generate a consistent hue from a string for color variety
  * @param {string} str - String to generate color from
@@ -399,6 +529,24 @@ function generateConsistentHue(str) {
   return hash % 360;
 }
 
+/**
+ * Summarize the probed metadata of a video
+ * @param {Object} video - The video field of a file from /api/files, may be missing
+ * @returns {string} e.g. "3840×2160 • 29.97 fps • hevc/aac • 12:14 • 45 Mbps"
+ */
+function formatVideoInfo(video) {
+  if (!video) return "";
+
+  const parts = [];
+  if (video.width && video.height) parts.push(`${video.width}×${video.height}`);
+  if (video.fps) parts.push(`${video.fps} fps`);
+  const codecs = [video.video_codec, video.audio_codec].filter(Boolean);
+  if (codecs.length) parts.push(codecs.join("/"));
+  if (video.duration) parts.push(formatDuration(video.duration));
+  if (video.bitrate) parts.push(`${(video.bitrate / 1e6).toFixed(1)} Mbps`);
+  return parts.join(" • ");
+}
+
 /**
  * Show video modal for on-demand playback
  * @param {Object} file - File data
@@ -417,6 +565,9 @@ function showVideoModal(file, videoIndex) {
   document.getElementById("videoModalSize").textContent = formatFileSize(
     file.size,
   );
+  document.getElementById("videoModalMeta").textContent = formatVideoInfo(
+    file.video,
+  );
 
   modal.style.display = "flex";
 
@@ -6,20 +6,11 @@
  * Update navigation based on file counts
  */
 function updateNavigation() {
-  const types = [
-    "image",
-    "video",
-    "audio",
-    "text",
-    "code",
-    "pdf",
-    "archive",
-    "other",
-  ];
-
-  types.forEach((type) => {
-    const stat = allFileStats[type];
-    const navItem = document.querySelector(`a[data-category="${type}"]`);
+  categories.forEach((category) => {
+    const stat = allFileStats[category.name];
+    const navItem = document.querySelector(
+      `a[data-category="${category.name}"]`,
+    );
 
     if (navItem) {
       // Hide nav items with zero files
@@ -32,10 +23,21 @@ function updateNavigation() {
   });
 }
 
+/**
+ * Look up a category definition by name
+ * @param {string} name - Category name
+ * @returns {Object|undefined} Category definition
+ */
+function getCategory(name) {
+  return categories.find((category) => category.name === name);
+}
+
 /**
  * Show home view with statistics
  */
 function showIntro() {
+  currentDir = null;
+  hideFolderBar();
   updateActiveNav("home");
   document.getElementById("intro").style.display = "block";
   document.getElementById("container").innerHTML = "";
@@ -54,48 +56,47 @@ function updateActiveNav(category) {
   });
 }
 
+/**
+ * Wait until the server has finished its initial scan, showing progress meanwhile
+ * @returns {Promise<void>} Resolves when the index is ready
+ */
+async function waitForIndex() {
+  const statsContainer = document.getElementById("fileStats");
+
+  for (;;) {
+    try {
+      const response = await fetch("/api/status");
+      if (!response.ok) return;
+      const status = await response.json();
+      if (!status.scanning) return;
+
+      statsContainer.innerHTML = `<div class="loading-container"><div class="spinner"></div><p>Indexing… ${status.files} files in ${status.dirs} folders</p></div>`;
+    } catch (error) {
+      console.warn("Could not fetch indexing status:", error);
+      return;
+    }
+
+    await new Promise((resolve) => setTimeout(resolve, 1000));
+  }
+}
+
 /**
  * Fetch statistics for all file types
  * @returns {Promise<Object>} Statistics for each file type
  */
 async function fetchAllStats() {
-  const types = [
-    "image",
-    "video",
-    "audio",
-    "text",
-    "code",
-    "pdf",
-    "archive",
-    "other",
-  ];
   const statsContainer = document.getElementById("fileStats");
   statsContainer.innerHTML =
     '<div class="loading-container"><div class="spinner"></div><p>Loading file statistics...</p></div>';
 
   try {
-    const results = await Promise.allSettled(
-      types.map(async (t) => {
-        try {
-          const response = await fetch(t + ".json");
-          if (!response.ok) {
-            // Just return zero count rather than throwing an error
-            return { type: t, count: 0 };
-          }
-          const json = await response.json();
-          return { type: t, count: json.length };
-        } catch (error) {
-          console.warn(`Could not fetch stats for ${t}:`, error);
-          return { type: t, count: 0, error: true };
-        }
-      }),
-    );
+    // A zero-sized page only returns the per-type counts
+    const response = await fetch("/api/files?limit=0");
+    if (!response.ok) throw new Error(`HTTP error ${response.status}`);
+    const json = await response.json();
 
-    // Rest of the function remains the same
-    allFileStats = results.reduce((acc, result) => {
-      if (result.status === "fulfilled") {
-        acc[result.value.type] = result.value;
-      }
+    allFileStats = Object.entries(json.counts).reduce((acc, [t, count]) => {
+      acc[t] = { type: t, count: count };
       return acc;
     }, {});
 
@@ -106,11 +107,58 @@ async function fetchAllStats() {
     return {};
   }
 }
+
+/**
+ * Fetch the next page of files for a category from the listing API
+ * @param {string} t - Category type to fetch
+ * @returns {Promise<boolean>} True if new files were added to data
+ */
+function fetchNextPage(t) {
+  if (pageRequest) return pageRequest;
+
+  const params = new URLSearchParams({
+    offset: data.length,
+    limit: pageSize,
+    sort: sortField,
+    order: sortOrder,
+  });
+  if (searchQuery) {
+    params.set("q", searchQuery);
+  }
+  // The folder view lists one directory across all types
+  const dir = currentDir;
+  if (t === "folder") {
+    params.set("dir", dir);
+  } else {
+    params.set("type", t);
+  }
+
+  pageRequest = fetch(`/api/files?${params}`)
+    .then((response) => {
+      if (!response.ok) throw new Error(`HTTP error ${response.status}`);
+      return response.json();
+    })
+    .then((page) => {
+      // Ignore pages for a category or folder the user already left
+      if (t !== type || dir !== currentDir) return false;
+      totalFiles = page.total;
+      data = data.concat(page.files);
+      return page.files.length > 0;
+    })
+    .finally(() => {
+      pageRequest = null;
+    });
+
+  return pageRequest;
+}
+
 /**
  * Display file statistics in a table
  */
 function displayFileStats() {
-  const types = Object.keys(allFileStats);
+  const types = categories
+    .map((category) => category.name)
+    .filter((name) => allFileStats[name]);
   if (types.length === 0) {
     document.getElementById("fileStats").innerHTML =
       "<p>No file statistics available.</p>";
@@ -142,7 +190,7 @@ function displayFileStats() {
         totalCount > 0 ? ((stat.count / totalCount) * 100).toFixed(1) : 0;
       html += `
         <tr>
-          <td>${type.charAt(0).toUpperCase() + type.slice(1)}</td>
+          <td>${getCategory(type).label}</td>
           <td>${stat.count}</td>
           <td>${percentage}%</td>
         </tr>
@@ -165,14 +213,37 @@ function displayFileStats() {
   document.getElementById("fileStats").innerHTML = html;
 }
 
+/**
+ * Filter the current listing by name, document information, tags and keywords
+ * once the user stops typing
+ * @param {string} value - Text in the search box
+ */
+function onSearchInput(value) {
+  clearTimeout(searchTimer);
+  searchTimer = setTimeout(() => {
+    searchQuery = value.trim();
+    // The home view has no listing to filter
+    if (document.getElementById("intro").style.display !== "none") return;
+    load(type);
+  }, 300);
+}
+
 /**
  * Load a category of files
  * @param {string} t - Category type to load
  */
 async function load(t) {
   type = t;
+  if (t === "folder") {
+    renderFolderBar(currentDir);
+  } else {
+    currentDir = null;
+    hideFolderBar();
+  }
   index = 0;
   data = [];
+  totalFiles = 0;
+  pageRequest = null;
   endReached = false;
   updateActiveNav(t);
   document.getElementById("intro").style.display = "none";
@@ -206,9 +277,7 @@ async function load(t) {
   }
 
   try {
-    const response = await fetch(t + ".json");
-    if (!response.ok) throw new Error(`HTTP error ${response.status}`);
-    data = await response.json();
+    await fetchNextPage(t);
 
     if (shouldUseTableView(t)) {
       // Clear the loading indicator for table view
@@ -262,6 +331,14 @@ function setupCardView(container, t) {
  * @returns {Promise<boolean>} True if render was successful
  */
 async function render() {
+  // Fetch another page once everything loaded so far has been rendered
+  if (index >= data.length && data.length < totalFiles) {
+    const renderType = type;
+    const renderDir = currentDir;
+    await fetchNextPage(type);
+    if (renderType !== type || renderDir !== currentDir) return false;
+  }
+
   if (data.length === 0 || index >= data.length) {
     endReached = true;
     return false;
@@ -0,0 +1,109 @@
+/**
+ * Folder browsing functionality
+ */
+
+/**
+ * Show the files directly inside a folder
+ * @param {string} dir - Web directory to show, e.g. "/media/holiday"
+ */
+async function browseFolder(dir) {
+  currentDir = dir;
+  await load("folder");
+}
+
+/**
+ * Hide the folder bar when leaving the folder view
+ */
+function hideFolderBar() {
+  const bar = document.getElementById("folderBar");
+  bar.style.display = "none";
+  bar.innerHTML = "";
+}
+
+/**
+ * Create a link that opens a folder
+ * @param {string} label - Text to show
+ * @param {string} dir - Web directory to open
+ * @returns {HTMLElement} Link element
+ */
+function createFolderLink(label, dir) {
+  const link = document.createElement("a");
+  link.textContent = label;
+  link.onclick = function () {
+    browseFolder(dir);
+  };
+  return link;
+}
+
+/**
+ * Render the breadcrumb trail for a folder
+ * @param {string} dir - Web directory
+ * @returns {HTMLElement} Breadcrumb element
+ */
+function createBreadcrumb(dir) {
+  const crumbs = document.createElement("div");
+  crumbs.className = "folder-breadcrumb";
+
+  // The first segment is the media root itself
+  const parts = dir.split("/").filter(Boolean);
+  let current = "";
+  parts.forEach((part, i) => {
+    current += "/" + part;
+    if (i > 0) crumbs.append(" / ");
+    const label = i === 0 ? "📂 All folders" : part;
+    if (i === parts.length - 1) {
+      const span = document.createElement("span");
+      span.textContent = label;
+      crumbs.appendChild(span);
+    } else {
+      crumbs.appendChild(createFolderLink(label, current));
+    }
+  });
+
+  return crumbs;
+}
+
+/**
+ * Render the breadcrumb and the subfolders of the current folder
+ * @param {string} dir - Web directory
+ */
+async function renderFolderBar(dir) {
+  const bar = document.getElementById("folderBar");
+  bar.style.display = "block";
+  bar.innerHTML = "";
+  bar.appendChild(createBreadcrumb(dir));
+
+  try {
+    const params = new URLSearchParams({ dir: dir, depth: 1 });
+    const response = await fetch(`/api/tree?${params}`);
+    if (!response.ok) throw new Error(`HTTP error ${response.status}`);
+    const node = await response.json();
+
+    // The user may have moved on while the tree was loading
+    if (dir !== currentDir) return;
+
+    const list = document.createElement("div");
+    list.className = "folder-list";
+    (node.children || []).forEach((child) => {
+      const link = createFolderLink(`📁 ${child.name}`, child.path);
+      link.className = "folder-chip";
+      link.title = categories
+        .filter((category) => child.counts[category.name])
+        .map((category) => `${category.label}: ${child.counts[category.name]}`)
+        .join("\n");
+
+      const info = document.createElement("span");
+      info.className = "folder-info";
+      info.textContent = `${child.total} files • ${formatFileSize(child.size)}`;
+      link.appendChild(info);
+
+      list.appendChild(link);
+    });
+    bar.appendChild(list);
+  } catch (error) {
+    const message = document.createElement("div");
+    message.className = "error-container";
+    message.textContent = `Failed to load folders: ${error.message}`;
+    bar.appendChild(message);
+  }
+}
@@ -7,18 +7,31 @@ let data = [];
 let index = 0;
 let step = 50;
 let type = "";
+let currentDir = null; // Web directory shown by the folder view
+let totalFiles = 0; // Total files of the current type on the server
+let pageSize = 200; // Files requested per API call
+let sortField = "name";
+let sortOrder = "asc";
+let pageRequest = null; // Pending page fetch, shared by concurrent callers
 let modalIndex = -1;
 let detailsVisible = false;
 let loadingMore = false;
 let allFileStats = {};
+let categories = []; // Category definitions from the server, in navigation order
 let endReached = false;
 let imageObserver;
 let currentAudio = null;
 let resizeObserver;
 let thumbnailsEnabled = false;
+let imageThumbnails = false; // The grid loads scaled down images from /thumbnail/
+let editEnabled = false; // Ratings and keywords can be written to XMP sidecars
+let searchQuery = ""; // Text the listed files must contain, sent as q
+let searchTimer = null;
 let debugLogging = false;
 let currentZoom = "md"; // Default zoom level: xs, sm, md, lg, xl
 const zoomLevels = ["xs", "sm", "md", "lg", "xl"];
+// Width of image thumbnails per zoom level, one of the sizes the server allows
+const imageThumbWidths = { xs: 320, sm: 480, md: 640, lg: 960, xl: 1280 };
 var thumbnailCache = {};
 var sharedThumbnails = {}; // Global cache of loaded thumbnails
 var firstThumbnailForSeries = {}; // Track the first thumbnail for each video series
@@ -27,7 +40,13 @@ var firstThumbnailForSeries = {}; // Track the first thumbnail for each video se
 window.addEventListener("DOMContentLoaded", function () {
   thumbnailsEnabled =
     document.body.getAttribute("data-thumbnails-enabled") === "true";
+  imageThumbnails =
+    document.body.getAttribute("data-image-thumbnails") === "true";
   debugLogging = document.body.getAttribute("data-debug-enabled") === "true";
+  editEnabled = document.body.getAttribute("data-edit-enabled") === "true";
+  categories = JSON.parse(
+    document.getElementById("categoryData").textContent || "[]",
+  );
   window.debugLog = function (message, ...args) {
     if (debugLogging) {
       window.debugLog("[DEBUG]", message, ...args);
@@ -40,10 +59,12 @@ window.addEventListener("DOMContentLoaded", function () {
  * Initialize the application
  */
 function initApp() {
-  // Fetch file statistics
-  fetchAllStats().then(() => {
-    updateNavigation();
-  });
+  // Fetch file statistics once the server has finished indexing
+  waitForIndex()
+    .then(fetchAllStats)
+    .then(() => {
+      updateNavigation();
+    });
 
   // Set up observers
   setupImageObserver();
@@ -94,8 +115,11 @@ function setupKeyboardNavigation() {
       document.getElementById("videoModal").style.display = "none";
     }
 
-    // Arrow keys - navigate images when image modal is open
-    if (document.getElementById("imageModal").style.display === "flex") {
+    // Arrow keys - navigate images when image modal is open, unless typing
+    if (
+      document.getElementById("imageModal").style.display === "flex" &&
+      e.target.tagName !== "INPUT"
+    ) {
       if (e.key === "ArrowRight") navigateModal(1);
       else if (e.key === "ArrowLeft") navigateModal(-1);
     }
@@ -16,7 +16,10 @@ function showImageModal(i) {
 
   // Update navigation buttons
   prevButton.classList.toggle("disabled", i <= 0);
-  nextButton.classList.toggle("disabled", i >= data.length - 1);
+  nextButton.classList.toggle(
+    "disabled",
+    i >= data.length - 1 && data.length >= totalFiles,
+  );
 
   // Set image and download link
   modalImg.src = file.path;
@@ -35,11 +38,16 @@ function showImageModal(i) {
  * Navigate between images in modal
  * @param {number} dir - Direction to navigate (1 for next, -1 for previous)
  */
-function navigateModal(dir) {
+async function navigateModal(dir) {
   if (modalIndex < 0) return;
 
   const newIndex = modalIndex + dir;
 
+  // Pull in the next page when stepping past the files loaded so far
+  if (newIndex >= data.length && data.length < totalFiles) {
+    await fetchNextPage(type);
+  }
+
   // Check bounds
   if (newIndex < 0 || newIndex >= data.length) {
     return; // Do nothing if out of bounds
@@ -88,8 +96,25 @@ function toggleExif(forceUpdate = false) {
   ];
   info.push(`<strong>File:</strong> ${data[modalIndex].name}`);
   info.push(`<strong>Size:</strong> ${formatFileSize(data[modalIndex].size)}`);
+  info.push(...formatSidecar(data[modalIndex]));
+
+  // Prefer the metadata extracted by the server while scanning
+  const serverExif = data[modalIndex].exif;
+  if (serverExif) {
+    info.push("<h4>EXIF Metadata</h4>");
+    info.push(...formatServerExif(serverExif));
+    const place = data[modalIndex].place;
+    if (place) {
+      const name = [place.city, place.region, place.country]
+        .filter((part, i, parts) => part && part !== parts[i - 1])
+        .join(", ");
+      info.push(`<strong>Place:</strong> ${escapeHtml(name)}`);
+    }
+    details.innerHTML = info.join("<br>");
+    return;
+  }
 
-  // Try to get EXIF data
+  // Fall back to reading the EXIF data in the browser
   try {
     EXIF.getData(img, function () {
       try {
@@ -196,6 +221,127 @@ function toggleExif(forceUpdate = false) {
   }
 }
 
+/**
+ * Format the rating, label and keywords from the XMP sidecar of a file, with
+ * controls to change them when editing is enabled
+ * @param {Object} file - A file from /api/files
+ * @returns {string[]} HTML lines
+ */
+function formatSidecar(file) {
+  const xmp = file.xmp || { rating: 0, keywords: [] };
+  if (!file.xmp && !editEnabled) return [];
+
+  const lines = ["<h4>Sidecar</h4>"];
+  let stars = "";
+  for (let n = 1; n <= 5; n++) {
+    const star = n <= xmp.rating ? "★" : "☆";
+    stars += editEnabled
+      ? `<span class="rating-star" onclick="updateSidecar({rating: ${n === xmp.rating ? 0 : n}})">${star}</span>`
+      : star;
+  }
+  if (xmp.rating < 0) stars = "Rejected";
+  lines.push(`<strong>Rating:</strong> ${stars}`);
+
+  if (xmp.label) {
+    lines.push(`<strong>Label:</strong> ${escapeHtml(xmp.label)}`);
+  }
+
+  const keywords = (xmp.keywords || []).join(", ");
+  if (editEnabled) {
+    lines.push(
+      `<strong>Keywords:</strong> <input type="text" class="keyword-input" value="${escapeHtml(keywords)}" placeholder="Comma-separated" onchange="updateSidecar({keywords: this.value.split(',')})">`,
+    );
+  } else if (keywords) {
+    lines.push(`<strong>Keywords:</strong> ${escapeHtml(keywords)}`);
+  }
+  return lines;
+}
+
+/**
+ * Write changes to the XMP sidecar of the image shown in the modal
+ * @param {Object} changes - rating, label and/or keywords to set
+ */
+async function updateSidecar(changes) {
+  const file = data[modalIndex];
+  try {
+    const response = await fetch(
+      `/sidecar/${encodeURIComponent(file.path.substring(7))}`,
+      {
+        method: "PUT",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(changes),
+      },
+    );
+    if (!response.ok) {
+      throw new Error(await response.text());
+    }
+    file.xmp = await response.json();
+    toggleExif(true);
+  } catch (error) {
+    console.error("Error updating sidecar:", error);
+    alert(`Could not update the sidecar: ${error.message}`);
+  }
+}
+
+/**
+ * Format the EXIF summary sent with a file by the server
+ * @param {Object} exif - The exif field of a file from /api/files
+ * @returns {string[]} HTML lines
+ */
+function formatServerExif(exif) {
+  const lines = [];
+
+  if (exif.taken) {
+    // Show the camera's wall clock time as recorded, not converted to the browser's zone
+    const taken = exif.taken.slice(0, 19).replace("T", " ");
+    lines.push(`<strong>Date Taken:</strong> ${escapeHtml(taken)}`);
+  }
+  if (exif.make || exif.model) {
+    // Most models already start with the brand
+    const model = exif.model || "";
+    const camera =
+      exif.make && !model.toLowerCase().startsWith(exif.make.toLowerCase())
+        ? `${exif.make} ${model}`
+        : model || exif.make;
+    lines.push(`<strong>Camera:</strong> ${escapeHtml(camera.trim())}`);
+  }
+  if (exif.lens) {
+    lines.push(`<strong>Lens:</strong> ${escapeHtml(exif.lens)}`);
+  }
+  if (exif.exposure) {
+    const exposure =
+      exif.exposure < 1
+        ? `1/${Math.round(1 / exif.exposure)}`
+        : exif.exposure;
+    lines.push(`<strong>Exposure:</strong> ${exposure} sec`);
+  }
+  if (exif.fnumber) {
+    lines.push(`<strong>Aperture:</strong> f/${exif.fnumber}`);
+  }
+  if (exif.iso) {
+    lines.push(`<strong>ISO:</strong> ${exif.iso}`);
+  }
+  if (exif.focal_length) {
+    lines.push(`<strong>Focal Length:</strong> ${exif.focal_length}mm`);
+  }
+  if (exif.orientation > 1) {
+    lines.push(`<strong>Orientation:</strong> ${exif.orientation}`);
+  }
+  if (exif.gps) {
+    const lat = exif.gps.lat.toFixed(6);
+    const lon = exif.gps.lon.toFixed(6);
+    lines.push(`<strong>GPS:</strong> ${lat}, ${lon}`);
+    if (exif.gps.alt) {
+      lines.push(`<strong>Altitude:</strong> ${Math.round(exif.gps.alt)} m`);
+    }
+    lines.push(
+      `<a href="https://maps.google.com/?q=${lat},${lon}" target="_blank">View on Map</a>`,
+    );
+  }
+
+  return lines;
+}
+
 /**
  * Show file modal for text/code files
  * @param {Object} file - File data
@@ -216,16 +362,20 @@ async function showFileModal(file) {
   modal.style.display = "flex";
 
   try {
-    const response = await fetch(file.path);
-    if (!response.ok) throw new Error(`HTTP error ${response.status}`);
-    const text = await response.text();
+    // Very long files are cut, the download button gets the whole file
+    const snippet = await fetchSnippet(file, 5000);
+    const text = snippet.content;
+    const about = snippet.total_lines_estimated ? "about " : "";
+    const note = snippet.truncated
+      ? `<p class="snippet-note">Showing ${snippet.lines} of ${about}${snippet.total_lines} lines (${snippet.encoding}). Download the file to see all of it.</p>`
+      : "";
 
     if (file.extension === "md") {
       // Render markdown
       const contentDiv = document.createElement("div");
       contentDiv.className = "markdown-content";
       contentDiv.innerHTML = marked.parse(text);
-      modalBody.innerHTML = "";
+      modalBody.innerHTML = note;
       modalBody.appendChild(contentDiv);
     } else {
       // Render code with syntax highlighting
@@ -266,7 +416,7 @@ async function showFileModal(file) {
       code.textContent = text;
 
       pre.appendChild(code);
-      modalBody.innerHTML = "";
+      modalBody.innerHTML = note;
       modalBody.appendChild(pre);
 
       // Apply syntax highlighting
@@ -283,6 +433,46 @@ async function showFileModal(file) {
   }
 }
 
+/**
+ * Show a PDF with its document information
+ * @param {Object} file - File data
+ */
+function showPdfModal(file) {
+  const pdf = file.pdf || {};
+  document.getElementById("fileModalTitle").textContent =
+    pdf.title || file.name;
+  const downloadBtn = document.getElementById("fileDownloadBtn");
+  downloadBtn.href = file.path;
+  downloadBtn.download = file.name;
+
+  const rows = [
+    ["File", file.name],
+    ["Title", pdf.title],
+    ["Author", pdf.author],
+    ["Subject", pdf.subject],
+    ["Keywords", pdf.keywords],
+    ["Pages", pdf.pages],
+    ["Created", pdf.created && new Date(pdf.created).toLocaleString()],
+    ["Modified", pdf.modified && new Date(pdf.modified).toLocaleString()],
+    ["Creator", pdf.creator],
+    ["Producer", pdf.producer],
+    ["PDF Version", pdf.version],
+    ["Encrypted", pdf.encrypted && "Yes"],
+    ["Size", formatFileSize(file.size)],
+  ].filter(([, value]) => value);
+
+  const table = rows
+    .map(
+      ([label, value]) =>
+        `<tr><td><strong>${label}</strong></td><td>${escapeHtml(value)}</td></tr>`,
+    )
+    .join("");
+  document.getElementById("fileModalBody").innerHTML = `
+    <table class="pdf-details">${table}</table>
+    <iframe class="pdf-frame" src="${file.path}" title="${escapeHtml(file.name)}"></iframe>`;
+  document.getElementById("fileModal").style.display = "flex";
+}
+
 /**
  * Hide modal
  * @param {string} modalId - ID of the modal to hide
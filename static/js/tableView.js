@@ -8,7 +8,11 @@
  * @returns {boolean} True if table view should be used
  */
 function shouldUseTableView(fileType) {
-  return ["audio", "archive", "other"].includes(fileType);
+  // Folders mix all types, cards show each one the way its category does
+  if (fileType === "folder") return false;
+
+  const category = getCategory(fileType);
+  return !category || category.view === "table";
 }
 
 /**
@@ -63,6 +67,11 @@ function createTableRow(file, i) {
   downloadLink.className = "table-file-link";
   nameCell.appendChild(downloadLink);
 
+  // Show the tags of audio files below the file name
+  if (file.audio) {
+    nameCell.appendChild(createTrackInfo(file));
+  }
+
   // Create size cell
   const sizeCell = document.createElement("td");
   sizeCell.textContent = formatFileSize(file.size);
@@ -94,6 +103,50 @@ function createTableRow(file, i) {
   return row;
 }
 
+/**
+ * Create the tag summary of an audio file with its cover art
+ * @param {Object} file - File data with an audio field
+ * @returns {HTMLElement} Track info element
+ */
+function createTrackInfo(file) {
+  const audio = file.audio;
+  const info = document.createElement("div");
+  info.className = "track-info";
+
+  if (audio.cover) {
+    const cover = document.createElement("img");
+    cover.className = "track-cover";
+    cover.loading = "lazy";
+    cover.alt = "";
+    cover.src = `/thumbnail/${encodeURIComponent(file.path.substring(7))}`;
+    info.appendChild(cover);
+  }
+
+  const title = audio.track
+    ? `${audio.track}. ${audio.title || file.name}`
+    : audio.title || file.name;
+  const details = [audio.artist, audio.album, audio.year]
+    .filter(Boolean)
+    .join(" • ");
+
+  const text = document.createElement("div");
+  const titleLine = document.createElement("div");
+  titleLine.className = "track-title";
+  titleLine.textContent = audio.duration
+    ? `${title} (${formatDuration(audio.duration)})`
+    : title;
+  text.appendChild(titleLine);
+  if (details) {
+    const detailLine = document.createElement("div");
+    detailLine.className = "track-details";
+    detailLine.textContent = details;
+    text.appendChild(detailLine);
+  }
+  info.appendChild(text);
+
+  return info;
+}
+
 /**
  * Play/pause audio in table view
  * @param {HTMLElement} button - Play button
@@ -15,6 +15,89 @@ function formatFileSize(bytes) {
   return parseFloat((bytes / Math.pow(k, i)).toFixed(2)) + " " + sizes[i];
 }
 
+/**
+ * Format a duration as h:mm:ss or m:ss
+ * @param {number} seconds - Duration in seconds
+ * @returns {string} Formatted duration
+ */
+function formatDuration(seconds) {
+  const total = Math.round(seconds);
+  const h = Math.floor(total / 3600);
+  const m = Math.floor((total % 3600) / 60);
+  const s = String(total % 60).padStart(2, "0");
+  return h > 0 ? `${h}:${String(m).padStart(2, "0")}:${s}` : `${m}:${s}`;
+}
+
+/**
+ * Escape text for use in HTML markup
+ * @param {string} text - Untrusted text, e.g. from file metadata
+ * @returns {string} Escaped text
+ */
+function escapeHtml(text) {
+  return String(text)
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;");
+}
+
+/**
+ * Fetch the first lines of a text file, transcoded to UTF-8 by the server
+ * @param {Object} file - File data
+ * @param {number} lines - Number of lines to fetch
+ * @returns {Promise<Object>} content, lines, total_lines (total_lines_estimated
+ *   for long files), encoding and truncated
+ */
+async function fetchSnippet(file, lines) {
+  const params = new URLSearchParams({ path: file.path, lines: lines });
+  const response = await fetch(`/api/snippet?${params}`);
+  if (!response.ok) throw new Error(`HTTP error ${response.status}`);
+  return response.json();
+}
+
+/**
+ * Fetch the storyboard of a video: the cues of its WebVTT thumbnails track
+ * @param {Object} file - File data
+ * @returns {Promise<Array>} Cues with start, end (seconds), src of the sprite sheet and x, y, w, h of the frame
+ */
+async function fetchStoryboard(file) {
+  const url = `/storyboard/${encodeURIComponent(file.path.substring(7))}`;
+  const response = await fetch(url);
+  if (!response.ok) throw new Error(`HTTP error ${response.status}`);
+  const text = await response.text();
+  const base = new URL(url, location.href);
+
+  const cues = [];
+  for (const block of text.split(/\r?\n\r?\n/)) {
+    const lines = block.trim().split(/\r?\n/);
+    const timing = lines.findIndex((line) => line.includes("-->"));
+    if (timing < 0 || !lines[timing + 1]) continue;
+
+    const [start, end] = lines[timing].split("-->").map(parseVttTime);
+    // The sprite sheet is given relative to the track, with the frame as fragment
+    const target = new URL(lines[timing + 1].trim(), base);
+    const [x, y, w, h] = target.hash
+      .replace("#xywh=", "")
+      .split(",")
+      .map(Number);
+    target.hash = "";
+    cues.push({ start, end, src: target.href, x, y, w, h });
+  }
+  return cues;
+}
+
+/**
+ * Parse a WebVTT timestamp such as 01:02:03.450 or 02:03.450
+ * @param {string} timestamp - Timestamp
+ * @returns {number} Seconds
+ */
+function parseVttTime(timestamp) {
+  return timestamp
+    .trim()
+    .split(":")
+    .reduce((seconds, part) => seconds * 60 + parseFloat(part), 0);
+}
+
 /**
  * Get appropriate icon for file type based on extension
  * @param {string} extension - File extension
@@ -45,11 +128,17 @@ function getFileIcon(extension) {
 }
 
 /**
- * Get a default aspect ratio for images
- * @returns {string} Default aspect ratio
+ * Get the aspect ratio of an image or video from the size in the index
+ * @param {Object} file - File data
+ * @returns {string} Aspect ratio for the CSS aspect-ratio property
  */
-function getImageAspectRatio() {
-  return "3/2"; // Common aspect ratio for photos (landscape orientation)
+function getImageAspectRatio(file) {
+  if (!file || !file.width || !file.height) {
+    return "3/2"; // Common aspect ratio for photos (landscape orientation)
+  }
+  // Keep panoramas and long screenshots from producing extreme cards
+  const ratio = Math.min(Math.max(file.width / file.height, 1 / 3), 3);
+  return String(ratio);
 }
 
 /**
@@ -0,0 +1,208 @@
+// File: storyboard.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/u2takey/ffmpeg-go"
+)
+
+// Storyboard settings
+const (
+	storyboardFrames    = 25  // Evenly spaced frames per video
+	storyboardColumns   = 5   // Frames per row of the sprite sheet
+	storyboardTileWidth = 160 // Width of one frame, the height follows the video
+)
+
+// storyboardFlights merges concurrent requests for the storyboard of the same video
+var storyboardFlights flightGroup
+
+// GetOrCreateStoryboard returns the paths of the sprite sheet and the WebVTT
+// thumbnails track of a video, creating them if needed. They are cached like
+// thumbnails, by the video signature.
+func GetOrCreateStoryboard(videoPath string) (spritePath, vttPath string, err error) {
+	if !ThumbnailEnabled {
+		return "", "", fmt.Errorf("thumbnail generation is disabled")
+	}
+
+	signature, err := GetVideoSignature(videoPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get video signature: %w", err)
+	}
+	key := GetSignatureHash(signature) + "-storyboard"
+	spritePath = filepath.Join(ThumbnailConfig.CacheDir, key+".jpg")
+	vttPath = filepath.Join(ThumbnailConfig.CacheDir, key+".vtt")
+
+	_, cached := lookupThumbnail(key)
+	// The track is written last, so the sprite sheet exists when it does
+	if _, err := os.Stat(vttPath); err == nil {
+		if !cached {
+			storeThumbnail(key, videoPath, spritePath, vttPath)
+		}
+		return spritePath, vttPath, nil
+	}
+
+	if err := os.MkdirAll(ThumbnailConfig.CacheDir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+	// Several cards or tabs may ask for the same storyboard at once
+	err = storyboardFlights.Do(spritePath, func() error {
+		return GenerateStoryboard(videoPath, spritePath, vttPath)
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	storeThumbnail(key, videoPath, spritePath, vttPath)
+	return spritePath, vttPath, nil
+}
+
+// GenerateStoryboard grabs evenly spaced frames of a video, tiles them into a
+// sprite sheet and writes a WebVTT track that maps each part of the video to
+// its frame in the sheet
+func GenerateStoryboard(videoPath, spritePath, vttPath string) error {
+	ThumbnailSemaphore <- struct{}{}
+	defer func() { <-ThumbnailSemaphore }()
+
+	// Another request may have made the storyboard while this one waited
+	if _, err := os.Stat(vttPath); err == nil {
+		return nil
+	}
+
+	duration := videoDuration(videoPath)
+	if duration <= 0 {
+		return fmt.Errorf("unknown video duration")
+	}
+	step := duration / storyboardFrames
+
+	// Frames that cannot be read, e.g. past the last keyframe, stay black
+	frames := make([]image.Image, storyboardFrames)
+	var tile image.Point
+	for i := range frames {
+		frame, err := grabFrame(videoPath, (float64(i)+0.5)*step)
+		if err != nil {
+			debugLog("Could not grab frame %d of %s: %v", i, videoPath, err)
+			continue
+		}
+		frames[i] = frame
+		if tile == (image.Point{}) {
+			tile = frame.Bounds().Size()
+		}
+	}
+	if tile == (image.Point{}) {
+		return fmt.Errorf("no frames could be read")
+	}
+
+	rows := (storyboardFrames + storyboardColumns - 1) / storyboardColumns
+	sprite := image.NewRGBA(image.Rect(0, 0, storyboardColumns*tile.X, rows*tile.Y))
+	draw.Draw(sprite, sprite.Bounds(), image.Black, image.Point{}, draw.Src)
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	for i, frame := range frames {
+		at := image.Pt(i%storyboardColumns*tile.X, i/storyboardColumns*tile.Y)
+		if frame != nil {
+			draw.Draw(sprite, image.Rectangle{Min: at, Max: at.Add(tile)}, frame, frame.Bounds().Min, draw.Src)
+		}
+		// The handler puts the path of the video in front, so renamed videos share the track
+		fmt.Fprintf(&vtt, "\n%s --> %s\n?sprite#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(float64(i)*step), vttTimestamp(float64(i+1)*step), at.X, at.Y, tile.X, tile.Y)
+	}
+
+	if err := writeImage(spritePath, sprite, false); err != nil {
+		return err
+	}
+	tmpPath := vttPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(vtt.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write storyboard track: %w", err)
+	}
+	if err := os.Rename(tmpPath, vttPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write storyboard track: %w", err)
+	}
+	return nil
+}
+
+// grabFrame returns the frame of a video at a time in seconds, scaled to the
+// storyboard tile width
+func grabFrame(videoPath string, seconds float64) (image.Image, error) {
+	var buf bytes.Buffer
+	err := ffmpeg_go.Input(videoPath, ffmpeg_go.KwArgs{
+		"ss":              seconds,
+		"noaccurate_seek": "",
+	}).
+		Output("pipe:", ffmpeg_go.KwArgs{
+			"map":      "0:v:0",
+			"vframes":  1,
+			"format":   "image2",
+			"vcodec":   "mjpeg",
+			"vf":       scaleFilter(ThumbSize{Width: storyboardTileWidth, Fit: FitWidth}),
+			"qscale:v": 5,
+		}).
+		GlobalArgs("-loglevel", "quiet").
+		WithOutput(&buf, getOutputWriter()).
+		Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg frame extraction failed: %w", err)
+	}
+	return jpeg.Decode(&buf)
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp, e.g. 01:02:03.450
+func vttTimestamp(seconds float64) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// StoryboardHandler serves the WebVTT thumbnails track of a video, or with the
+// sprite parameter the sprite sheet its cues point to
+func StoryboardHandler(libs Libraries, index *MediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decodedPath, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/storyboard/"))
+		if err != nil {
+			http.Error(w, "Invalid path encoding", http.StatusBadRequest)
+			return
+		}
+
+		// The path is relative to /media; only indexed videos are accepted
+		file, videoPath, ok := libs.ResolveIndexed(index, decodedPath)
+		if !ok || file.Type != "video" {
+			http.Error(w, "Video not found", http.StatusNotFound)
+			return
+		}
+
+		if !ThumbnailEnabled {
+			http.Error(w, "Thumbnail generation is disabled", http.StatusNotFound)
+			return
+		}
+
+		spritePath, vttPath, err := GetOrCreateStoryboard(videoPath)
+		if err != nil {
+			http.Error(w, "Failed to generate storyboard: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Has("sprite") {
+			http.ServeFile(w, r, spritePath)
+			return
+		}
+		data, err := os.ReadFile(vttPath)
+		if err != nil {
+			http.Error(w, "Failed to read storyboard", http.StatusInternalServerError)
+			return
+		}
+		// Players resolve relative cue URLs differently, so make them absolute
+		data = bytes.ReplaceAll(data, []byte("\n?sprite#"), []byte("\n"+r.URL.EscapedPath()+"?sprite#"))
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		w.Write(data)
+	}
+}
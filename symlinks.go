@@ -0,0 +1,225 @@
+// File: symlinks.go
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// errLinkPolicy is returned for paths the symlink policy does not allow
+var errLinkPolicy = errors.New("path is outside the symlink policy")
+
+// LinkPolicy decides which symbolic links the scanner, the watcher and the file servers follow.
+// Links to files inside the root are always followed, as they were before the policy
+// existed; the settings only concern links to directories and targets outside the root.
+type LinkPolicy struct {
+	Follow        bool   // Follow symlinked directories
+	AllowExternal bool   // With Follow, allow link targets outside the root
+	root          string // Absolute root as configured
+	realRoot      string // Root with its own symlinks resolved
+}
+
+// NewLinkPolicy creates the policy for a root directory
+func NewLinkPolicy(root string, follow, allowExternal bool) *LinkPolicy {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		realRoot = absRoot
+	}
+	return &LinkPolicy{
+		Follow:        follow,
+		AllowExternal: allowExternal,
+		root:          absRoot,
+		realRoot:      realRoot,
+	}
+}
+
+// Fingerprint describes the policy for the stored index, which is rebuilt when it changes
+func (p *LinkPolicy) Fingerprint() string {
+	switch {
+	case !p.following():
+		return ""
+	case p.AllowExternal:
+		return "follow,external"
+	default:
+		return "follow"
+	}
+}
+
+// within reports whether target lies inside the resolved root
+func (p *LinkPolicy) within(target string) bool {
+	rel, err := filepath.Rel(p.realRoot, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) && !filepath.IsAbs(rel)
+}
+
+// external reports whether the policy refuses a link target because it is outside the root
+func (p *LinkPolicy) external(target string) bool {
+	return !(p.Follow && p.AllowExternal) && !p.within(target)
+}
+
+// Resolve returns the target of the symlink at fullPath if the policy allows following it
+func (p *LinkPolicy) Resolve(fullPath string) (os.FileInfo, bool) {
+	if p == nil {
+		return nil, false
+	}
+
+	target, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		debugLog("Skipping broken symlink %s: %v", fullPath, err)
+		return nil, false
+	}
+	if p.external(target) {
+		debugLog("Skipping symlink %s pointing outside the root to %s", fullPath, target)
+		return nil, false
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, false
+	}
+	if info.IsDir() && !p.Follow {
+		debugLog("Skipping symlinked directory %s, following symlinks is off", fullPath)
+		return nil, false
+	}
+	return info, true
+}
+
+// following reports whether symlinked directories are followed
+func (p *LinkPolicy) following() bool {
+	return p != nil && p.Follow
+}
+
+// Entry returns the info to index a directory entry with. Symlinks are resolved to
+// their targets when the policy allows it; linked reports whether that happened.
+// ok is false for entries that have to be skipped.
+func (p *LinkPolicy) Entry(dir string, entry os.DirEntry) (info os.FileInfo, linked, ok bool) {
+	if entry.Type()&os.ModeSymlink == 0 {
+		info, err := entry.Info()
+		return info, false, err == nil // An error means it was removed after the listing
+	}
+	info, ok = p.Resolve(filepath.Join(dir, entry.Name()))
+	return info, true, ok
+}
+
+// CheckPath verifies that fullPath, a path below the root, may be served.
+// The resolved path has to stay inside the root unless external targets are allowed.
+// Without following, only the file itself may be a symlink, not a directory on the way to it.
+func (p *LinkPolicy) CheckPath(fullPath string) error {
+	if p == nil {
+		return nil
+	}
+
+	absPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(p.root, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return errLinkPolicy
+	}
+
+	real, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return err
+	}
+	if p.external(real) {
+		return errLinkPolicy
+	}
+	if p.Follow || rel == "." {
+		return nil
+	}
+
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(absPath))
+	if err != nil {
+		return err
+	}
+	if realDir != filepath.Join(p.realRoot, filepath.Dir(rel)) {
+		return errLinkPolicy
+	}
+	if real != filepath.Join(p.realRoot, rel) {
+		// The file itself is a link; it has to point to a file
+		info, err := os.Stat(real)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return errLinkPolicy
+		}
+	}
+	return nil
+}
+
+// dirTracker remembers the directories seen during a walk so symlink loops are entered only once
+type dirTracker struct {
+	mu   sync.Mutex
+	seen map[fileID]string // Identity -> first relative path it was seen at
+}
+
+func newDirTracker() *dirTracker {
+	return &dirTracker{seen: make(map[fileID]string)}
+}
+
+// Visit records the directory at fullPath and reports whether it is new.
+// A directory reachable under another relative path is a loop or a duplicate.
+func (t *dirTracker) Visit(relDir, fullPath string, info os.FileInfo) bool {
+	id, ok := fileIdentity(fullPath, info)
+	if !ok {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if first, exists := t.seen[id]; exists && first != relDir {
+		return false
+	}
+	t.seen[id] = relDir
+	return true
+}
+
+// Forget drops relDir and everything below it
+func (t *dirTracker) Forget(relDir string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prefix := relDir + string(os.PathSeparator)
+	for id, dir := range t.seen {
+		if dir == relDir || strings.HasPrefix(dir, prefix) {
+			delete(t.seen, id)
+		}
+	}
+}
+
+// policyFileSystem is an http.FileSystem that refuses paths outside the symlink policy
+type policyFileSystem struct {
+	root   string
+	policy *LinkPolicy
+}
+
+// PolicyFileServer serves root like http.FileServer while enforcing the symlink policy
+func PolicyFileServer(root string, policy *LinkPolicy) http.Handler {
+	return http.FileServer(policyFileSystem{root: root, policy: policy})
+}
+
+func (p policyFileSystem) Open(name string) (http.File, error) {
+	fullPath := filepath.Join(p.root, filepath.FromSlash(path.Clean("/"+name)))
+	if err := p.policy.CheckPath(fullPath); err != nil {
+		if errors.Is(err, errLinkPolicy) {
+			debugLog("Refusing to serve %s: %v", fullPath, err)
+		}
+		return nil, fs.ErrNotExist
+	}
+	return http.Dir(p.root).Open(name)
+}
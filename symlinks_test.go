@@ -0,0 +1,87 @@
+// File: symlinks_test.go
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// linkTree creates a root with a file, a directory and links to them and to a
+// directory outside it
+func linkTree(t *testing.T) (root string) {
+	t.Helper()
+	root, outside := t.TempDir(), t.TempDir()
+	writeTree(t, root, map[string]string{"a.jpg": "a", "real/b.jpg": "b"})
+	writeTree(t, outside, map[string]string{"o.jpg": "o"})
+	symlink(t, filepath.Join(root, "a.jpg"), filepath.Join(root, "file-link.jpg"))
+	symlink(t, filepath.Join(root, "real"), filepath.Join(root, "dir-link"))
+	symlink(t, filepath.Join(outside, "o.jpg"), filepath.Join(root, "ext-link.jpg"))
+	symlink(t, outside, filepath.Join(root, "ext-dir"))
+	symlink(t, filepath.Join(root, "missing.jpg"), filepath.Join(root, "broken.jpg"))
+	return root
+}
+
+func TestLinkPolicyScan(t *testing.T) {
+	tests := []struct {
+		name           string
+		follow, extern bool
+		expected       []string
+	}{
+		{"default", false, false, []string{"/media/a.jpg", "/media/file-link.jpg", "/media/real/b.jpg"}},
+		{"external without following", false, true, []string{"/media/a.jpg", "/media/file-link.jpg", "/media/real/b.jpg"}},
+		{"follow", true, false, []string{"/media/a.jpg", "/media/file-link.jpg", "/media/real/b.jpg"}},
+		{"follow external", true, true, []string{
+			"/media/a.jpg", "/media/ext-dir/o.jpg", "/media/ext-link.jpg", "/media/file-link.jpg", "/media/real/b.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := linkTree(t)
+			opts := ScanOptions{Root: root, BaseURL: "/media", Recursive: true, Workers: 2,
+				Links: NewLinkPolicy(root, tt.follow, tt.extern)}
+			if got := scanPaths(t, opts); !slices.Equal(got, tt.expected) {
+				t.Errorf("found %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLinkPolicyCheckPath(t *testing.T) {
+	tests := []struct {
+		path           string
+		follow, extern bool
+		allowed        bool
+	}{
+		{"a.jpg", false, false, true},
+		{"real/b.jpg", false, false, true},
+		{".", false, false, true},
+		{"file-link.jpg", false, false, true},
+		{"dir-link", false, false, false},
+		{"dir-link/b.jpg", false, false, false},
+		{"ext-link.jpg", false, false, false},
+		{"ext-link.jpg", false, true, false},
+		{"ext-dir/o.jpg", false, false, false},
+		{"broken.jpg", false, false, false},
+		{"../escape.jpg", false, false, false},
+		{"dir-link/b.jpg", true, false, true},
+		{"ext-link.jpg", true, false, false},
+		{"ext-dir/o.jpg", true, false, false},
+		{"ext-link.jpg", true, true, true},
+		{"ext-dir/o.jpg", true, true, true},
+	}
+
+	root := linkTree(t)
+	for _, tt := range tests {
+		p := NewLinkPolicy(root, tt.follow, tt.extern)
+		err := p.CheckPath(filepath.Join(root, filepath.FromSlash(tt.path)))
+		if (err == nil) != tt.allowed {
+			t.Errorf("CheckPath(%q) with follow %v, external %v = %v, want allowed %v", tt.path, tt.follow, tt.extern, err, tt.allowed)
+		}
+	}
+
+	var none *LinkPolicy
+	if err := none.CheckPath(filepath.Join(root, "dir-link", "b.jpg")); err != nil {
+		t.Errorf("nil policy CheckPath() = %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+// File: thumbcache.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// thumbPruneInterval is how often entries of deleted and changed files are removed
+const thumbPruneInterval = time.Hour
+
+// ThumbEntry is one cached thumbnail, storyboard or preview clip
+type ThumbEntry struct {
+	Files    []string  `json:"files"`            // Files on disk, the first one is served
+	Source   string    `json:"source,omitempty"` // Full path of the file it was made from
+	SrcSize  int64     `json:"source_size"`      // Size of the source when it was made
+	SrcTime  time.Time `json:"source_modified"`  // Modification time of the source when it was made
+	Size     int64     `json:"size"`             // Bytes of all files
+	Accessed time.Time `json:"accessed"`         // Last time it was served, for LRU eviction
+}
+
+// CacheStats is the JSON body returned by /api/cache
+type CacheStats struct {
+	Entries  int     `json:"entries"`
+	Bytes    int64   `json:"bytes"`
+	MaxBytes int64   `json:"max_bytes"` // Size limit, 0 without one
+	Hits     int64   `json:"hits"`      // Lookups that found a cached file since the start
+	Misses   int64   `json:"misses"`
+	HitRate  float64 `json:"hit_rate"` // Hits per lookup, 0 before the first lookup
+	Evicted  int64   `json:"evicted"`  // Entries removed to stay below MaxBytes
+	Pruned   int64   `json:"pruned"`   // Entries removed because their source was deleted or changed
+}
+
+// flightGroup merges concurrent generations of the same file, so that requests
+// arriving while one runs wait for it and share its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a generation in progress
+type flightCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Do runs fn unless a call with the same key is running, in which case it waits
+// for that call and returns its error
+func (g *flightGroup) Do(key string, fn func() error) error {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// Cache statistics since the start. The size, evictions and prunes are protected
+// by ThumbnailCacheMutex, lookups are counted without it.
+var (
+	thumbCacheBytes int64
+	thumbStats      CacheStats
+	thumbHits       atomic.Int64
+	thumbMisses     atomic.Int64
+)
+
+// lookupThumbnail returns the served file of a cache entry if it is still on disk,
+// and records the access. Accesses alone do not make the cache save, they are
+// written with the next added or removed entry.
+func lookupThumbnail(key string) (string, bool) {
+	ThumbnailCacheMutex.Lock()
+	entry, exists := ThumbnailCache[key]
+	if exists {
+		entry.Accessed = time.Now()
+	}
+	ThumbnailCacheMutex.Unlock()
+
+	if !exists {
+		thumbMisses.Add(1)
+		return "", false
+	}
+
+	// Check the file without holding the lock, so other requests do not wait for the disk
+	if _, err := os.Stat(entry.Files[0]); err != nil {
+		var files []string
+		ThumbnailCacheMutex.Lock()
+		if ThumbnailCache[key] == entry {
+			files = removeThumbnail(key)
+		}
+		ThumbnailCacheMutex.Unlock()
+		removeFiles(files)
+		thumbMisses.Add(1)
+		return "", false
+	}
+	thumbHits.Add(1)
+	return entry.Files[0], true
+}
+
+// storeThumbnail records files made from sourcePath in the cache, the first of
+// them being the one served, and evicts the least recently used entries when the
+// cache grows beyond its size limit
+func storeThumbnail(key, sourcePath string, files ...string) {
+	entry := &ThumbEntry{Files: files, Source: sourcePath, Accessed: time.Now()}
+	if info, err := os.Stat(sourcePath); err == nil {
+		entry.SrcSize, entry.SrcTime = info.Size(), info.ModTime()
+	}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			entry.Size += info.Size()
+		}
+	}
+
+	var evicted []string
+	ThumbnailCacheMutex.Lock()
+	if old, exists := ThumbnailCache[key]; exists {
+		thumbCacheBytes -= old.Size
+	}
+	ThumbnailCache[key] = entry
+	thumbCacheBytes += entry.Size
+	thumbnailChanged = true
+
+	if ThumbnailConfig.MaxBytes > 0 && thumbCacheBytes > ThumbnailConfig.MaxBytes {
+		evicted = evictThumbnails(key)
+	}
+	ThumbnailCacheMutex.Unlock()
+
+	removeFiles(evicted)
+}
+
+// evictThumbnails removes the least recently used entries until the cache fits
+// its size limit, keeping the entry just stored, and returns the files to delete.
+// The caller holds ThumbnailCacheMutex.
+func evictThumbnails(keep string) []string {
+	keys := make([]string, 0, len(ThumbnailCache))
+	for key := range ThumbnailCache {
+		if key != keep {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return ThumbnailCache[a].Accessed.Compare(ThumbnailCache[b].Accessed)
+	})
+
+	var files []string
+	for _, key := range keys {
+		if thumbCacheBytes <= ThumbnailConfig.MaxBytes {
+			break
+		}
+		files = append(files, removeThumbnail(key)...)
+		thumbStats.Evicted++
+	}
+	debugLog("Thumbnail cache is %d bytes after eviction", thumbCacheBytes)
+	return files
+}
+
+// removeThumbnail deletes a cache entry and returns its files, which the caller
+// removes with removeFiles after releasing ThumbnailCacheMutex, so lookups do not
+// wait for the disk. Should the entry be made again in between, the next lookup
+// finds its file missing and makes it once more. The caller holds ThumbnailCacheMutex.
+func removeThumbnail(key string) []string {
+	entry := ThumbnailCache[key]
+	thumbCacheBytes -= entry.Size
+	delete(ThumbnailCache, key)
+	thumbnailChanged = true
+	return entry.Files
+}
+
+// removeFiles deletes the files of removed cache entries
+func removeFiles(files []string) {
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			debugLog("Could not remove %s: %v", file, err)
+		}
+	}
+}
+
+// pruneThumbnails removes the entries whose source file was deleted or changed
+// since the entry was made. Entries of older versions without a known source are
+// kept; they leave the cache through LRU eviction.
+func pruneThumbnails() {
+	ThumbnailCacheMutex.RLock()
+	entries := make(map[string]ThumbEntry, len(ThumbnailCache))
+	for key, entry := range ThumbnailCache {
+		entries[key] = *entry
+	}
+	ThumbnailCacheMutex.RUnlock()
+
+	// Check the sources without holding the lock, they may be on slow disks
+	var stale []string
+	for key, entry := range entries {
+		if entry.Source == "" {
+			continue
+		}
+		info, err := os.Stat(entry.Source)
+		if os.IsNotExist(err) || (err == nil && (info.Size() != entry.SrcSize || !info.ModTime().Equal(entry.SrcTime))) {
+			stale = append(stale, key)
+		}
+	}
+
+	var files []string
+	ThumbnailCacheMutex.Lock()
+	for _, key := range stale {
+		// Skip entries made again in the meantime
+		if entry, exists := ThumbnailCache[key]; exists && entry.Source == entries[key].Source &&
+			entry.SrcTime.Equal(entries[key].SrcTime) {
+			files = append(files, removeThumbnail(key)...)
+			thumbStats.Pruned++
+		}
+	}
+	ThumbnailCacheMutex.Unlock()
+	removeFiles(files)
+
+	if len(stale) > 0 {
+		debugLog("Pruned %d thumbnail cache entries of deleted or changed files", len(stale))
+		saveThumbnailCache()
+	}
+}
+
+// startCachePruner prunes the cache now and then every thumbPruneInterval
+func startCachePruner() {
+	go func() {
+		for {
+			pruneThumbnails()
+			time.Sleep(thumbPruneInterval)
+		}
+	}()
+}
+
+// thumbnailCacheStats returns the current cache statistics
+func thumbnailCacheStats() CacheStats {
+	ThumbnailCacheMutex.RLock()
+	defer ThumbnailCacheMutex.RUnlock()
+
+	stats := thumbStats
+	stats.Hits, stats.Misses = thumbHits.Load(), thumbMisses.Load()
+	stats.Entries = len(ThumbnailCache)
+	stats.Bytes = thumbCacheBytes
+	stats.MaxBytes = ThumbnailConfig.MaxBytes
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// CacheStatsHandler reports the size and hit rate of the thumbnail cache
+func CacheStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, thumbnailCacheStats())
+	}
+}
+
+// parseThumbnailCache reads cache.json. Older versions stored only the path of
+// each thumbnail; such entries are kept with the size and time of the file on
+// disk, and without a source.
+func parseThumbnailCache(data []byte) (map[string]*ThumbEntry, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	cache := make(map[string]*ThumbEntry, len(raw))
+	for key, value := range raw {
+		var path string
+		if json.Unmarshal(value, &path) == nil {
+			entry := &ThumbEntry{Files: []string{path}}
+			if info, err := os.Stat(path); err == nil {
+				entry.Size, entry.Accessed = info.Size(), info.ModTime()
+			}
+			cache[key] = entry
+			continue
+		}
+
+		var entry ThumbEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return nil, err
+		}
+		if len(entry.Files) > 0 {
+			cache[key] = &entry
+		}
+	}
+	return cache, nil
+}
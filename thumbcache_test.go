@@ -0,0 +1,206 @@
+// File: thumbcache_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestCache replaces the thumbnail cache with an empty one in a temporary
+// directory for the duration of a test
+func useTestCache(t *testing.T, maxBytes int64) string {
+	t.Helper()
+	cache, config, bytes, stats := ThumbnailCache, ThumbnailConfig, thumbCacheBytes, thumbStats
+	t.Cleanup(func() {
+		ThumbnailCache, ThumbnailConfig, thumbCacheBytes, thumbStats = cache, config, bytes, stats
+	})
+
+	ThumbnailConfig = ThumbConfig{CacheDir: t.TempDir(), MaxBytes: maxBytes}
+	ThumbnailCache = make(map[string]*ThumbEntry)
+	thumbCacheBytes, thumbStats = 0, CacheStats{}
+	return ThumbnailConfig.CacheDir
+}
+
+// cacheKeys returns the sorted keys of the thumbnail cache
+func cacheKeys() []string {
+	var keys []string
+	for key := range ThumbnailCache {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestEvictThumbnails(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		keep     string
+		expected []string
+	}{
+		{"within the limit", 300, "", []string{"a", "b", "c"}},
+		{"least recently used first", 250, "", []string{"b", "c"}},
+		{"several", 100, "", []string{"c"}},
+		{"entry just stored is kept", 100, "a", []string{"a"}},
+		{"everything", 1, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useTestCache(t, tt.maxBytes)
+			// a was served longest ago, c most recently
+			for i, key := range []string{"a", "b", "c"} {
+				file := filepath.Join(dir, key+".jpg")
+				writeTree(t, dir, map[string]string{key + ".jpg": strings.Repeat("x", 100)})
+				ThumbnailCache[key] = &ThumbEntry{Files: []string{file}, Size: 100, Accessed: time.Now().Add(time.Duration(i-3) * time.Hour)}
+				thumbCacheBytes += 100
+			}
+
+			ThumbnailCacheMutex.Lock()
+			files := evictThumbnails(tt.keep)
+			ThumbnailCacheMutex.Unlock()
+			// The files are still there until the caller removes them
+			for _, file := range files {
+				if _, err := os.Stat(file); err != nil {
+					t.Errorf("%s was removed under the lock: %v", file, err)
+				}
+			}
+			removeFiles(files)
+
+			if got := cacheKeys(); !slices.Equal(got, tt.expected) {
+				t.Errorf("cache = %v, want %v", got, tt.expected)
+			}
+			if thumbCacheBytes != int64(100*len(tt.expected)) || thumbStats.Evicted != int64(3-len(tt.expected)) {
+				t.Errorf("%d bytes, %d evicted", thumbCacheBytes, thumbStats.Evicted)
+			}
+			for _, key := range []string{"a", "b", "c"} {
+				_, err := os.Stat(filepath.Join(dir, key+".jpg"))
+				if kept := slices.Contains(tt.expected, key); kept != (err == nil) {
+					t.Errorf("file of %s exists = %v, want %v", key, err == nil, kept)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreThumbnailEvicts(t *testing.T) {
+	dir := useTestCache(t, 250)
+	writeTree(t, dir, map[string]string{"src.mp4": "video", "a.jpg": strings.Repeat("x", 100),
+		"b.jpg": strings.Repeat("x", 100), "c.jpg": strings.Repeat("x", 100)})
+	src := filepath.Join(dir, "src.mp4")
+
+	storeThumbnail("a", src, filepath.Join(dir, "a.jpg"))
+	storeThumbnail("b", src, filepath.Join(dir, "b.jpg"))
+	ThumbnailCache["a"].Accessed = time.Now().Add(time.Hour) // Served after b
+	storeThumbnail("c", src, filepath.Join(dir, "c.jpg"))
+
+	if got := cacheKeys(); !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("cache = %v, want a and c", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.jpg")); !os.IsNotExist(err) {
+		t.Errorf("evicted file still exists: %v", err)
+	}
+	if _, ok := lookupThumbnail("b"); ok {
+		t.Error("lookupThumbnail() found an evicted entry")
+	}
+}
+
+func TestPruneThumbnails(t *testing.T) {
+	dir := useTestCache(t, 0)
+	writeTree(t, dir, map[string]string{"same.mp4": "s", "changed.mp4": "c", "deleted.mp4": "d"})
+
+	entry := func(source string) *ThumbEntry {
+		file := filepath.Join(dir, source+".jpg")
+		writeTree(t, dir, map[string]string{source + ".jpg": "thumb"})
+		e := &ThumbEntry{Files: []string{file}, Size: 5}
+		if source != "" {
+			e.Source = filepath.Join(dir, source)
+			if info, err := os.Stat(e.Source); err == nil {
+				e.SrcSize, e.SrcTime = info.Size(), info.ModTime()
+			}
+		}
+		return e
+	}
+
+	tests := []struct {
+		key    string
+		entry  *ThumbEntry
+		change func()
+		kept   bool
+	}{
+		{"same", entry("same.mp4"), nil, true},
+		{"changed", entry("changed.mp4"), func() { writeTree(t, dir, map[string]string{"changed.mp4": "longer"}) }, false},
+		{"deleted", entry("deleted.mp4"), func() { os.Remove(filepath.Join(dir, "deleted.mp4")) }, false},
+		{"legacy", entry(""), nil, true}, // Made by an older version, without a source
+	}
+	for _, tt := range tests {
+		ThumbnailCache[tt.key] = tt.entry
+		thumbCacheBytes += tt.entry.Size
+		if tt.change != nil {
+			tt.change()
+		}
+	}
+
+	pruneThumbnails()
+
+	for _, tt := range tests {
+		_, cached := ThumbnailCache[tt.key]
+		_, err := os.Stat(tt.entry.Files[0])
+		if cached != tt.kept || (err == nil) != tt.kept {
+			t.Errorf("%s: cached %v, file exists %v, want %v", tt.key, cached, err == nil, tt.kept)
+		}
+	}
+	if thumbStats.Pruned != 2 || thumbCacheBytes != 10 {
+		t.Errorf("pruned %d, %d bytes left, want 2 and 10", thumbStats.Pruned, thumbCacheBytes)
+	}
+}
+
+func TestParseThumbnailCache(t *testing.T) {
+	legacy := writeTestFile(t, "legacy.jpg", []byte("thumbnail"))
+
+	tests := []struct {
+		name     string
+		data     string
+		expected map[string]ThumbEntry // nil when an error is expected
+	}{
+		{"current", `{"k":{"files":["/c/k.jpg","/c/k.vtt"],"source":"/v.mp4","source_size":5,"size":7}}`,
+			map[string]ThumbEntry{"k": {Files: []string{"/c/k.jpg", "/c/k.vtt"}, Source: "/v.mp4", SrcSize: 5, Size: 7}}},
+		{"legacy path", `{"k":"` + filepath.ToSlash(legacy) + `"}`,
+			map[string]ThumbEntry{"k": {Files: []string{filepath.ToSlash(legacy)}, Size: 9}}},
+		{"legacy path of a missing file", `{"k":"/missing.jpg"}`,
+			map[string]ThumbEntry{"k": {Files: []string{"/missing.jpg"}}}},
+		{"entry without files", `{"k":{"files":[]}}`, map[string]ThumbEntry{}},
+		{"empty", `{}`, map[string]ThumbEntry{}},
+		{"wrong type", `{"k":42}`, nil},
+		{"not JSON", `not json`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, err := parseThumbnailCache([]byte(tt.data))
+			if tt.expected == nil {
+				if err == nil {
+					t.Errorf("parseThumbnailCache() = %v, want an error", cache)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseThumbnailCache() error = %v", err)
+			}
+			if len(cache) != len(tt.expected) {
+				t.Fatalf("parseThumbnailCache() = %d entries, want %d", len(cache), len(tt.expected))
+			}
+			for key, want := range tt.expected {
+				got := cache[key]
+				if got == nil || !slices.Equal(got.Files, want.Files) || got.Source != want.Source ||
+					got.SrcSize != want.SrcSize || got.Size != want.Size {
+					t.Errorf("entry %s = %+v, want %+v", key, got, want)
+				}
+			}
+		})
+	}
+}
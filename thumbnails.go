@@ -2,6 +2,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/json"
 	"fmt"
@@ -11,6 +12,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,9 +27,97 @@ type ThumbConfig struct {
 	Enabled       bool   // Whether thumbnails are enabled (default: false)
 	CacheDir      string // Directory to store cached thumbnails
 	PreGenerate   int    // Number of thumbnails to pre-generate at startup
-	Width         int    // Thumbnail width
-	Height        int    // Thumbnail height
+	Width         int    // Default width of video thumbnails
+	Height        int    // Default height of video thumbnails
 	MaxConcurrent int    // Maximum concurrent thumbnail generations
+	MaxPreviews   int    // Maximum concurrent preview clip generations
+	ImageWidth    int    // Default width of image thumbnails, the height follows the aspect ratio
+	ImageDecoders int    // Maximum concurrent image decodes
+	MaxBytes      int64  // Size limit of the cache directory, 0 for no limit
+}
+
+// Fit modes of thumbnails. All of them keep the aspect ratio.
+const (
+	FitCover   = "cover"   // Fill Width x Height, cropping the center
+	FitContain = "contain" // Fit inside Width x Height
+	FitWidth   = "width"   // Scale to Width, the height follows
+)
+
+// ThumbSize is the size of one thumbnail, cached separately from other sizes
+type ThumbSize struct {
+	Width  int
+	Height int // 0 for FitWidth
+	Fit    string
+}
+
+// key returns the cache key of the thumbnail of this size for a file signature
+func (size ThumbSize) key(sigHash string) string {
+	return fmt.Sprintf("%s-%dx%d-%s", sigHash, size.Width, size.Height, size.Fit)
+}
+
+// String returns the size as listed in errors, e.g. 320x180 cover or 640 width
+func (size ThumbSize) String() string {
+	if size.Height == 0 {
+		return fmt.Sprintf("%d %s", size.Width, size.Fit)
+	}
+	return fmt.Sprintf("%dx%d %s", size.Width, size.Height, size.Fit)
+}
+
+// thumbPresets are the sizes that may be requested. Each one is cached separately
+// for every file, so the list is kept short to stop clients from filling the cache.
+var thumbPresets = []ThumbSize{
+	{Width: 160, Height: 90, Fit: FitCover},
+	{Width: 320, Height: 180, Fit: FitCover}, // Video cards
+	{Width: 640, Height: 360, Fit: FitCover},
+	{Width: 120, Height: 120, Fit: FitCover},
+	{Width: 320, Height: 320, Fit: FitContain},
+	{Width: 640, Height: 640, Fit: FitContain},
+	{Width: 320, Fit: FitWidth}, // Image cards at each grid zoom level
+	{Width: 480, Fit: FitWidth},
+	{Width: 640, Fit: FitWidth},
+	{Width: 960, Fit: FitWidth},
+	{Width: 1280, Fit: FitWidth},
+}
+
+// parseThumbSize reads the w, h and fit parameters of a thumbnail request.
+// Without w and h the default size is used, its fit applies when fit is unset.
+// Other sizes have to be one of thumbPresets.
+func parseThumbSize(values url.Values, def ThumbSize) (ThumbSize, error) {
+	size := def
+	if fit := values.Get("fit"); fit != "" {
+		if fit != FitCover && fit != FitContain && fit != FitWidth {
+			return size, fmt.Errorf("invalid fit %q", fit)
+		}
+		size.Fit = fit
+	}
+
+	w, h := values.Get("w"), values.Get("h")
+	if w == "" && h == "" {
+		if size.Fit != def.Fit {
+			return size, fmt.Errorf("w is required with fit %q", size.Fit)
+		}
+		return size, nil
+	}
+
+	size.Width, size.Height = 0, 0
+	for name, dst := range map[string]*int{"w": &size.Width, "h": &size.Height} {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return size, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+
+	if !slices.Contains(thumbPresets, size) {
+		allowed := make([]string, len(thumbPresets))
+		for i, preset := range thumbPresets {
+			allowed[i] = preset.String()
+		}
+		return size, fmt.Errorf("unsupported size %s, allowed: %s", size, strings.Join(allowed, ", "))
+	}
+	return size, nil
 }
 
 // VideoSignature holds identifying information for videos
@@ -38,30 +129,16 @@ type VideoSignature struct {
 
 // Global variables
 var (
-	ThumbnailCache      = make(map[string]string)
+	ThumbnailCache      = make(map[string]*ThumbEntry)
 	ThumbnailCacheMutex sync.RWMutex
 	ThumbnailSemaphore  chan struct{}
 	ThumbnailConfig     ThumbConfig
 	ThumbnailEnabled    bool // Simple flag to check from main.go
-	thumbnailChanged    bool // Still private, only used internally
+	thumbnailChanged    bool // Entries were added or removed since the last save, protected by ThumbnailCacheMutex
+	thumbnailSaveMutex  sync.Mutex
 	debugLogging        bool
-	originalLogOutput   io.Writer
 )
 
-func withSuppressedLogging(f func() error) error {
-	if !debugLogging {
-		// Save the current log output
-		originalLogOutput = log.Writer()
-		// Disable logging
-		log.SetOutput(io.Discard)
-		// Restore logging when done
-		defer log.SetOutput(originalLogOutput)
-	}
-
-	// Execute the function with logging suppressed
-	return f()
-}
-
 // GetVideoSignature generates a signature for duplicate detection
 func GetVideoSignature(videoPath string) (VideoSignature, error) {
 	// Get basic file info
@@ -111,45 +188,70 @@ func loadThumbnailCache() {
 	cacheFile := filepath.Join(ThumbnailConfig.CacheDir, "cache.json")
 	data, err := os.ReadFile(cacheFile)
 	if err != nil {
-		log.Printf("No existing thumbnail cache found or error reading it: %v", err)
+		if os.IsNotExist(err) {
+			debugLog("No existing thumbnail cache found")
+		} else {
+			log.Printf("Error reading thumbnail cache: %v", err)
+		}
 		return
 	}
 
 	ThumbnailCacheMutex.Lock()
 	defer ThumbnailCacheMutex.Unlock()
 
-	if err := json.Unmarshal(data, &ThumbnailCache); err != nil {
+	cache, err := parseThumbnailCache(data)
+	if err != nil {
 		log.Printf("Error parsing thumbnail cache: %v", err)
 		// Start with empty cache
-		ThumbnailCache = make(map[string]string)
+		cache = make(map[string]*ThumbEntry)
 	}
+	ThumbnailCache = cache
 
-	debugLog("Loaded %d entries from thumbnail cache", len(ThumbnailCache))
+	thumbCacheBytes = 0
+	for _, entry := range ThumbnailCache {
+		thumbCacheBytes += entry.Size
+	}
+
+	debugLog("Loaded %d entries (%d bytes) from thumbnail cache", len(ThumbnailCache), thumbCacheBytes)
 }
 
 // saveThumbnailCache persists the cache to disk
 func saveThumbnailCache() {
+	// The saver, the pruner and pre-generation may save at the same time
+	thumbnailSaveMutex.Lock()
+	defer thumbnailSaveMutex.Unlock()
+
+	ThumbnailCacheMutex.Lock()
 	if !thumbnailChanged {
+		ThumbnailCacheMutex.Unlock()
 		return // Don't save if no changes
 	}
-
-	ThumbnailCacheMutex.RLock()
 	data, err := json.Marshal(ThumbnailCache)
-	ThumbnailCacheMutex.RUnlock()
+	entries := len(ThumbnailCache)
+	thumbnailChanged = false
+	ThumbnailCacheMutex.Unlock()
 
 	if err != nil {
 		log.Printf("Error serializing thumbnail cache: %v", err)
 		return
 	}
 
+	// Write to a temporary file first so a crash never leaves a truncated cache
 	cacheFile := filepath.Join(ThumbnailConfig.CacheDir, "cache.json")
-	if err := os.WriteFile(cacheFile, data, 0644); err != nil {
+	err = os.WriteFile(cacheFile+".tmp", data, 0644)
+	if err == nil {
+		err = os.Rename(cacheFile+".tmp", cacheFile)
+	}
+	if err != nil {
 		log.Printf("Error writing thumbnail cache: %v", err)
+		// Try again with the next save
+		ThumbnailCacheMutex.Lock()
+		thumbnailChanged = true
+		ThumbnailCacheMutex.Unlock()
 		return
 	}
 
-	thumbnailChanged = false
-	debugLog("Saved %d entries to thumbnail cache", len(ThumbnailCache))
+	debugLog("Saved %d entries to thumbnail cache", entries)
 }
 
 // startCacheSaver starts a goroutine to periodically save the cache
@@ -171,8 +273,37 @@ func getOutputWriter() io.Writer {
 	return io.Discard // Discard output when debug logging is disabled
 }
 
+// videoDuration returns the length of a video in seconds, or 0 when it is unknown.
+// It reuses the duration found by the video prober, or tries a quick duration check.
+func videoDuration(videoPath string) float64 {
+	if probed := videoProber.Lookup(videoPath); probed != nil {
+		return probed.Duration
+	}
+
+	data, err := ffmpeg_go.ProbeWithTimeout(videoPath, time.Second*2, ffmpeg_go.KwArgs{
+		"show_entries":   "format=duration",
+		"select_streams": "v:0",
+		"of":             "json",
+	})
+	if err != nil {
+		return 0
+	}
+
+	// Try to parse duration
+	var probeData struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if json.Unmarshal([]byte(data), &probeData) != nil {
+		return 0
+	}
+	duration, _ := strconv.ParseFloat(probeData.Format.Duration, 64)
+	return duration
+}
+
 // GenerateVideoThumbnail creates an optimized thumbnail for a video
-func GenerateVideoThumbnail(videoPath, outputPath string) error {
+func GenerateVideoThumbnail(videoPath, outputPath string, size ThumbSize) error {
 	// Acquire a semaphore slot to limit concurrent processing
 	ThumbnailSemaphore <- struct{}{}
 	defer func() { <-ThumbnailSemaphore }()
@@ -180,63 +311,60 @@ func GenerateVideoThumbnail(videoPath, outputPath string) error {
 	// Calculate seek time (10% into the video or 3 seconds, whichever is greater)
 	seekTime := 3.0 // Default to 3 seconds
 
-	// Run the probe with suppressed logging if needed
-	return withSuppressedLogging(func() error {
-		// Try a quick duration check if possible
-		data, probeErr := ffmpeg_go.ProbeWithTimeout(videoPath, time.Second*2, ffmpeg_go.KwArgs{
-			"show_entries":   "format=duration",
-			"select_streams": "v:0",
-			"of":             "json",
-		})
-
-		// Process the result
-		if probeErr == nil {
-			// Try to parse duration
-			var probeData struct {
-				Format struct {
-					Duration string `json:"duration"`
-				} `json:"format"`
-			}
-
-			if json.Unmarshal([]byte(data), &probeData) == nil {
-				if duration, err := strconv.ParseFloat(probeData.Format.Duration, 64); err == nil && duration > 0 {
-					seekTime = duration * 0.1 // 10% into the video
-					if seekTime < 3.0 {
-						seekTime = 3.0 // Minimum 3 seconds in
-					}
-				}
-			}
+	if duration := videoDuration(videoPath); duration > 0 {
+		seekTime = duration * 0.1 // 10% into the video
+		if seekTime < 3.0 {
+			seekTime = 3.0 // Minimum 3 seconds in
 		}
+	}
 
-		// Build optimized ffmpeg command
-		ffmpegCmd := ffmpeg_go.Input(videoPath, ffmpeg_go.KwArgs{
-			"ss":              seekTime,
-			"noaccurate_seek": "",
+	// Build optimized ffmpeg command
+	ffmpegCmd := ffmpeg_go.Input(videoPath, ffmpeg_go.KwArgs{
+		"ss":              seekTime,
+		"noaccurate_seek": "",
+	}).
+		Output(outputPath, ffmpeg_go.KwArgs{
+			"map":      "0:v:0",  // Only first video stream
+			"vframes":  1,        // Single frame
+			"format":   "image2", // Output as image
+			"vcodec":   "mjpeg",  // Use MJPEG codec
+			"vf":       scaleFilter(size),
+			"qscale:v": 5, // Quality setting (1-31, lower is better)
 		}).
-			Output(outputPath, ffmpeg_go.KwArgs{
-				"map":      "0:v:0",  // Only first video stream
-				"vframes":  1,        // Single frame
-				"format":   "image2", // Output as image
-				"vcodec":   "mjpeg",  // Use MJPEG codec
-				"s":        fmt.Sprintf("%dx%d", ThumbnailConfig.Width, ThumbnailConfig.Height),
-				"qscale:v": 5, // Quality setting (1-31, lower is better)
-			}).
-			GlobalArgs("-loglevel", "quiet").
-			OverWriteOutput()
-
-		// Run the command
-		err := ffmpegCmd.Run()
+		GlobalArgs("-loglevel", "quiet").
+		WithErrorOutput(getOutputWriter()).
+		OverWriteOutput()
 
-		if err != nil {
-			return fmt.Errorf("ffmpeg thumbnail generation failed: %w", err)
-		}
+	// Run the command
+	err := ffmpegCmd.Run()
 
-		return nil
-	})
+	if err != nil {
+		return fmt.Errorf("ffmpeg thumbnail generation failed: %w", err)
+	}
+
+	return nil
+}
+
+// scaleFilter returns the ffmpeg filter that scales a frame to a thumbnail size
+// without distorting it. Rotated videos are turned upright before it runs.
+func scaleFilter(size ThumbSize) string {
+	switch size.Fit {
+	case FitCover:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d",
+			size.Width, size.Height, size.Width, size.Height)
+	case FitContain:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", size.Width, size.Height)
+	}
+	return fmt.Sprintf("scale=%d:-2", size.Width)
+}
+
+// defaultVideoThumbSize returns the size of video thumbnails without w and h parameters
+func defaultVideoThumbSize() ThumbSize {
+	return ThumbSize{Width: ThumbnailConfig.Width, Height: ThumbnailConfig.Height, Fit: FitCover}
 }
 
 // GetOrCreateThumbnail checks for duplicates before generating thumbnails
-func GetOrCreateThumbnail(videoPath string) (string, error) {
+func GetOrCreateThumbnail(videoPath string, size ThumbSize) (string, error) {
 	if !ThumbnailEnabled {
 		return "", fmt.Errorf("thumbnail generation is disabled")
 	}
@@ -247,32 +375,21 @@ func GetOrCreateThumbnail(videoPath string) (string, error) {
 		return "", fmt.Errorf("failed to get video signature: %w", err)
 	}
 
-	// Generate hash for the signature
-	sigHash := GetSignatureHash(signature)
+	// Generate hash for the signature, each size is cached under its own key
+	key := size.key(GetSignatureHash(signature))
 
-	// Check cache first (read lock)
-	ThumbnailCacheMutex.RLock()
-	cachedPath, exists := ThumbnailCache[sigHash]
-	ThumbnailCacheMutex.RUnlock()
-
-	if exists {
-		// Verify the cached thumbnail exists
-		if _, err := os.Stat(cachedPath); err == nil {
-			return cachedPath, nil
-		}
-		// If file doesn't exist, continue to regenerate
+	// Check cache first, a missing file is regenerated
+	if cachedPath, exists := lookupThumbnail(key); exists {
+		return cachedPath, nil
 	}
 
 	// Generate thumbnail path
-	thumbnailPath := filepath.Join(ThumbnailConfig.CacheDir, sigHash+".jpg")
+	thumbnailPath := filepath.Join(ThumbnailConfig.CacheDir, key+".jpg")
 
 	// Check if thumbnail already exists on disk but not in cache
 	if _, err := os.Stat(thumbnailPath); err == nil {
 		// Store in cache and return
-		ThumbnailCacheMutex.Lock()
-		ThumbnailCache[sigHash] = thumbnailPath
-		thumbnailChanged = true
-		ThumbnailCacheMutex.Unlock()
+		storeThumbnail(key, videoPath, thumbnailPath)
 		return thumbnailPath, nil
 	}
 
@@ -282,27 +399,19 @@ func GetOrCreateThumbnail(videoPath string) (string, error) {
 	}
 
 	// Generate thumbnail
-	if err := GenerateVideoThumbnail(videoPath, thumbnailPath); err != nil {
+	if err := GenerateVideoThumbnail(videoPath, thumbnailPath, size); err != nil {
 		return "", err
 	}
 
 	// Store in cache
-	ThumbnailCacheMutex.Lock()
-	ThumbnailCache[sigHash] = thumbnailPath
-	thumbnailChanged = true
-	ThumbnailCacheMutex.Unlock()
+	storeThumbnail(key, videoPath, thumbnailPath)
 
 	return thumbnailPath, nil
 }
 
-// ThumbnailHandler serves video thumbnails via HTTP
-func ThumbnailHandler(inputDir string) http.HandlerFunc {
+// ThumbnailHandler serves video and image thumbnails and the cover art of audio files via HTTP
+func ThumbnailHandler(libs Libraries, index *MediaIndex) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !ThumbnailEnabled {
-			http.Error(w, "Thumbnail generation is disabled", http.StatusNotFound)
-			return
-		}
-
 		// Extract video path from URL (remove "/thumbnail/" prefix)
 		videoPathBase := r.URL.Path[len("/thumbnail/"):]
 		decodedPath, err := url.PathUnescape(videoPathBase)
@@ -311,24 +420,48 @@ func ThumbnailHandler(inputDir string) http.HandlerFunc {
 			return
 		}
 
-		videoPath := filepath.Join(inputDir, decodedPath)
+		// The path is relative to /media. Only indexed files that may be served have
+		// thumbnails, and their detected type decides how the thumbnail is made.
+		file, videoPath, ok := libs.ResolveIndexed(index, decodedPath)
+		if !ok {
+			http.Error(w, "Video not found", http.StatusNotFound)
+			return
+		}
 
-		// Security check: ensure the path is within the input directory
-		absInputDir, _ := filepath.Abs(inputDir)
-		absVideoPath, _ := filepath.Abs(videoPath)
-		if !strings.HasPrefix(absVideoPath, absInputDir) {
-			http.Error(w, "Invalid path", http.StatusBadRequest)
+		// Cover art is read from the tags and needs no FFmpeg
+		if file.Type == "audio" {
+			serveAudioCover(w, r, videoPath)
 			return
 		}
 
-		// Check if the video exists
-		if _, err := os.Stat(videoPath); os.IsNotExist(err) {
-			http.Error(w, "Video not found", http.StatusNotFound)
+		// Images are scaled in Go and need no FFmpeg either
+		isImage := file.Type == "image"
+		if !isImage && file.Type != "video" {
+			http.Error(w, "No thumbnail for this file type", http.StatusNotFound)
+			return
+		}
+		if (isImage && !ImageThumbnailsEnabled) || (!isImage && !ThumbnailEnabled) {
+			http.Error(w, "Thumbnail generation is disabled", http.StatusNotFound)
+			return
+		}
+
+		defaultSize := defaultVideoThumbSize()
+		if isImage {
+			defaultSize = defaultImageThumbSize()
+		}
+		size, err := parseThumbSize(r.URL.Query(), defaultSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if isImage {
+			serveImageThumbnail(w, r, videoPath, size)
 			return
 		}
 
 		// Generate or retrieve thumbnail
-		thumbnailPath, err := GetOrCreateThumbnail(videoPath)
+		thumbnailPath, err := GetOrCreateThumbnail(videoPath, size)
 		if err != nil {
 			http.Error(w, "Failed to generate thumbnail: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -339,8 +472,36 @@ func ThumbnailHandler(inputDir string) http.HandlerFunc {
 	}
 }
 
+// serveAudioCover sends the embedded cover art of an audio file
+func serveAudioCover(w http.ResponseWriter, r *http.Request, audioPath string) {
+	info, err := os.Stat(audioPath)
+	if err != nil {
+		http.Error(w, "Audio file not found", http.StatusNotFound)
+		return
+	}
+	cover, err := readAudioCover(audioPath)
+	if err != nil {
+		http.Error(w, "No cover art", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", cover.MimeType)
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(cover.Data))
+}
+
+// serveImageThumbnail sends the thumbnail of an image, or the image itself when
+// it has none, e.g. because its format has no decoder
+func serveImageThumbnail(w http.ResponseWriter, r *http.Request, imagePath string, size ThumbSize) {
+	thumbnailPath, err := GetOrCreateImageThumbnail(imagePath, size)
+	if err != nil {
+		debugLog("No thumbnail for %s: %v", imagePath, err)
+		thumbnailPath = imagePath
+	}
+	http.ServeFile(w, r, thumbnailPath)
+}
+
 // PreGenerateThumbnails generates thumbnails for the first n videos
-func PreGenerateThumbnails(videos []FileInfo, inputDir string) {
+func PreGenerateThumbnails(videos []FileInfo, libs Libraries) {
 	if !ThumbnailEnabled || ThumbnailConfig.PreGenerate <= 0 {
 		return
 	}
@@ -351,9 +512,6 @@ func PreGenerateThumbnails(videos []FileInfo, inputDir string) {
 	count := 0
 	processed := 0
 
-	// Start cache saver
-	startCacheSaver()
-
 	for _, file := range videos {
 		if file.Type != "video" {
 			continue
@@ -367,11 +525,14 @@ func PreGenerateThumbnails(videos []FileInfo, inputDir string) {
 
 		// Get full path to the video
 		// The file.Path will be like "/media/subdir/video.mp4", need to remove "/media/" prefix
-		videoPath := filepath.Join(inputDir, file.Path[len("/media/"):])
+		_, videoPath, ok := libs.Resolve(strings.TrimPrefix(file.Path, mediaURL))
+		if !ok {
+			continue
+		}
 
 		// Generate thumbnail in a separate goroutine to allow concurrent processing
 		go func(vPath string) {
-			_, err := GetOrCreateThumbnail(vPath)
+			_, err := GetOrCreateThumbnail(vPath, defaultVideoThumbSize())
 			if err != nil {
 				log.Printf("Failed to pre-generate thumbnail for %s: %v", filepath.Base(vPath), err)
 			} else {
@@ -391,11 +552,14 @@ func PreGenerateThumbnails(videos []FileInfo, inputDir string) {
 }
 
 // InitThumbnails initializes the thumbnail system
-func InitThumbnails(enableThumbnails bool, cacheDir string, preGenerate int, debug bool) {
+func InitThumbnails(enableThumbnails bool, enableImages bool, cacheDir string, cacheSizeMB int, preGenerate int, debug bool) {
 	debugLogging = debug
+	// ffmpeg-go logs every command it runs to the standard logger
+	ffmpeg_go.LogCompiledCommand = debug
 	ThumbnailEnabled = enableThumbnails
+	ImageThumbnailsEnabled = enableImages
 
-	if !ThumbnailEnabled {
+	if !ThumbnailEnabled && !ImageThumbnailsEnabled {
 		return
 	}
 
@@ -406,21 +570,48 @@ func InitThumbnails(enableThumbnails bool, cacheDir string, preGenerate int, deb
 		Width:         320,
 		Height:        180,
 		MaxConcurrent: 2, // Limit concurrent generations
+		MaxPreviews:   1,
+		ImageWidth:    640,
+		ImageDecoders: min(runtime.NumCPU(), 4),
+		MaxBytes:      int64(cacheSizeMB) << 20,
 	}
 
-	// Initialize semaphore for concurrency control
+	// Initialize semaphores for concurrency control
 	ThumbnailSemaphore = make(chan struct{}, ThumbnailConfig.MaxConcurrent)
+	PreviewSemaphore = make(chan struct{}, ThumbnailConfig.MaxPreviews)
+	imageThumbSemaphore = make(chan struct{}, ThumbnailConfig.ImageDecoders)
 
 	// Create cache directory if it doesn't exist
 	if err := os.MkdirAll(ThumbnailConfig.CacheDir, 0755); err != nil {
 		log.Printf("Warning: Failed to create thumbnail cache directory: %v", err)
 		ThumbnailEnabled = false
+		ImageThumbnailsEnabled = false
 		return
 	}
 
 	// Try to load existing cache
 	loadThumbnailCache()
 
+	// The size limit may have been lowered since the last run
+	var evicted []string
+	ThumbnailCacheMutex.Lock()
+	if ThumbnailConfig.MaxBytes > 0 && thumbCacheBytes > ThumbnailConfig.MaxBytes {
+		evicted = evictThumbnails("")
+	}
+	ThumbnailCacheMutex.Unlock()
+	removeFiles(evicted)
+
+	// Start cache saver and remove thumbnails of deleted and changed files
+	startCacheSaver()
+	startCachePruner()
+
+	if ImageThumbnailsEnabled {
+		debugLog("Image thumbnail generation enabled (width: %d)", ThumbnailConfig.ImageWidth)
+	}
+	if !ThumbnailEnabled {
+		return
+	}
+
 	// This log should always be visible, so we'll use fmt.Printf instead
 	if debugLogging {
 		log.Printf("Video thumbnail generation enabled (cache: %s, pre-generate: %d)",
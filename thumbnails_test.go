@@ -0,0 +1,61 @@
+// File: thumbnails_test.go
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestParseThumbSize(t *testing.T) {
+	def := ThumbSize{Width: 320, Height: 180, Fit: FitCover}
+
+	tests := []struct {
+		query    string
+		expected ThumbSize // Zero when an error is expected
+	}{
+		{"", def},
+		{"fit=cover", def},
+		{"w=640&h=360", ThumbSize{Width: 640, Height: 360, Fit: FitCover}},
+		{"w=320&h=320&fit=contain", ThumbSize{Width: 320, Height: 320, Fit: FitContain}},
+		{"w=960&fit=width", ThumbSize{Width: 960, Fit: FitWidth}},
+		{"w=120&h=120", ThumbSize{Width: 120, Height: 120, Fit: FitCover}},
+		{"fit=contain", ThumbSize{}}, // A size is required with another fit
+		{"w=960", ThumbSize{}},       // Width only is not a cover preset
+		{"h=180", ThumbSize{}},       // Nor is height only
+		{"w=321&h=180", ThumbSize{}}, // Not a preset
+		{"w=320&h=180&fit=stretch", ThumbSize{}},
+		{"w=big&h=180", ThumbSize{}},
+		{"w=320&h=-180", ThumbSize{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size, err := parseThumbSize(values, def)
+			if tt.expected == (ThumbSize{}) {
+				if err == nil {
+					t.Errorf("parseThumbSize() = %v, want an error", size)
+				}
+				return
+			}
+			if err != nil || size != tt.expected {
+				t.Errorf("parseThumbSize() = %v, %v, want %v", size, err, tt.expected)
+			}
+		})
+	}
+
+	// Every preset can be requested
+	for _, preset := range thumbPresets {
+		values := url.Values{"w": {strconv.Itoa(preset.Width)}, "fit": {preset.Fit}}
+		if preset.Height != 0 {
+			values.Set("h", strconv.Itoa(preset.Height))
+		}
+		if size, err := parseThumbSize(values, def); err != nil || size != preset {
+			t.Errorf("preset %s: parseThumbSize() = %v, %v", preset, size, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// File: timeline.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources of the capture time of a file, from most to least reliable
+const (
+	TakenFromExif     = "exif"
+	TakenFromVideo    = "video"
+	TakenFromFilename = "filename"
+	TakenFromModified = "modified"
+)
+
+// Granularities of the timeline and the length of their bucket keys
+var timelineGroups = map[string]int{
+	"year":  len("2006"),
+	"month": len("2006-01"),
+	"day":   len("2006-01-02"),
+}
+
+// filenameDate matches the dates cameras, phones and messengers put in file names,
+// e.g. IMG_20230714_183205, PXL_20230714_183205123, 2023-07-14 18.32.05 or IMG-20230714-WA0001
+var filenameDate = regexp.MustCompile(`(?:^|\D)((?:19|20)\d{2})[-_.]?(0[1-9]|1[0-2])[-_.]?(0[1-9]|[12]\d|3[01])` +
+	`(?:(?:[-_ T.]|\sat\s)?([01]\d|2[0-3])[-_.:h]?([0-5]\d)[-_.:m]?([0-5]\d)\d{0,3})?(?:\D|$)`)
+
+// parseFilenameDate returns the date in a file name, interpreted in the server's time zone
+func parseFilenameDate(name string) (time.Time, bool) {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	m := filenameDate.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	n := make([]int, 6)
+	for i, s := range m[1:] {
+		n[i], _ = strconv.Atoi(s) // Missing time parts stay 0
+	}
+	ts := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, time.Local)
+
+	// Reject dates that do not exist (they would be normalized) and dates in the future
+	if ts.Day() != n[2] || ts.After(time.Now().Add(24*time.Hour)) {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+// setCaptureTime picks the most reliable capture time of f: the EXIF
+// DateTimeOriginal or the video creation_time, then a date in the file name,
+// then the modification time
+func (f *FileInfo) setCaptureTime() {
+	if f.Exif != nil && f.Exif.Taken != nil {
+		f.Taken, f.TakenFrom = *f.Exif.Taken, TakenFromExif
+		return
+	}
+	if f.Video != nil && f.Video.Created != nil {
+		f.Taken, f.TakenFrom = *f.Video.Created, TakenFromVideo
+		return
+	}
+	if ts, ok := parseFilenameDate(f.Name); ok {
+		f.Taken, f.TakenFrom = ts, TakenFromFilename
+		return
+	}
+	f.Taken, f.TakenFrom = f.Modified, TakenFromModified
+}
+
+// bucketKey returns the timeline bucket of a file for a key length from timelineGroups
+func bucketKey(f *FileInfo, keyLen int) string {
+	return f.Taken.Format("2006-01-02")[:keyLen]
+}
+
+// TimelineBucket is one year, month or day of the timeline
+type TimelineBucket struct {
+	Key   string `json:"key"` // 2023, 2023-07 or 2023-07-14
+	Count int    `json:"count"`
+}
+
+// TimelineResponse is the JSON body returned by /api/timeline without a bucket
+type TimelineResponse struct {
+	Group   string           `json:"group"` // Granularity of the buckets
+	Total   int              `json:"total"` // Files matching the query
+	Buckets []TimelineBucket `json:"buckets"`
+}
+
+// TimelineBucketResponse is the JSON body returned by /api/timeline for one bucket
+type TimelineBucketResponse struct {
+	Bucket string     `json:"bucket"`
+	Total  int        `json:"total"` // Files in the bucket
+	Offset int        `json:"offset"`
+	Limit  int        `json:"limit"`
+	Files  []FileInfo `json:"files"`
+}
+
+// TimelineHandler groups the index by capture time. Without a bucket parameter it
+// returns the number of files per year, month or day (group); with one it returns a
+// page of the files in that bucket. The filters of /api/files apply to both, and
+// order defaults to newest first.
+func TimelineHandler(index *MediaIndex) http.HandlerFunc {
+	cache := &listingCache{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q, err := parseFileQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		values := r.URL.Query()
+		q.Sort = "taken"
+		q.Desc = values.Get("order") != "asc"
+
+		result := cache.get(index, q)
+
+		bucket := values.Get("bucket")
+		if bucket == "" {
+			group := values.Get("group")
+			if group == "" {
+				group = "month"
+			}
+			keyLen, ok := timelineGroups[group]
+			if !ok {
+				http.Error(w, fmt.Sprintf("invalid group %q", group), http.StatusBadRequest)
+				return
+			}
+
+			// Keys follow the sort order, except where files recorded in different
+			// time zones interleave, so buckets are looked up rather than appended
+			buckets := make([]TimelineBucket, 0)
+			positions := make(map[string]int)
+			for i := range result {
+				key := bucketKey(&result[i], keyLen)
+				if pos, ok := positions[key]; ok {
+					buckets[pos].Count++
+					continue
+				}
+				positions[key] = len(buckets)
+				buckets = append(buckets, TimelineBucket{Key: key, Count: 1})
+			}
+
+			writeJSON(w, TimelineResponse{Group: group, Total: len(result), Buckets: buckets})
+			return
+		}
+
+		if !validBucket(bucket) {
+			http.Error(w, fmt.Sprintf("invalid bucket %q", bucket), http.StatusBadRequest)
+			return
+		}
+		keyLen := len(bucket)
+
+		files := make([]FileInfo, 0)
+		for i := range result {
+			if bucketKey(&result[i], keyLen) == bucket {
+				files = append(files, result[i])
+			}
+		}
+
+		from := min(q.Offset, len(files))
+		to := min(from+q.Limit, len(files))
+
+		writeJSON(w, TimelineBucketResponse{
+			Total:  len(files),
+			Bucket: bucket,
+			Offset: q.Offset,
+			Limit:  q.Limit,
+			Files:  files[from:to],
+		})
+	}
+}
+
+// validBucket reports whether a bucket key is a year, month or day
+func validBucket(bucket string) bool {
+	for _, layout := range []string{"2006", "2006-01", "2006-01-02"} {
+		if len(bucket) == len(layout) {
+			_, err := time.Parse(layout, bucket)
+			return err == nil
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+// File: tree.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TreeNode is one directory in the response of /api/tree. Counts and sizes include
+// everything below the directory.
+type TreeNode struct {
+	Name     string         `json:"name"`
+	Path     string         `json:"path"`  // Web directory, usable as the dir parameter of /api/files
+	Files    int            `json:"files"` // Files directly inside the directory
+	Total    int            `json:"total"` // Files in the directory and all subdirectories
+	Size     int64          `json:"size"`  // Bytes in the directory and all subdirectories
+	Counts   map[string]int `json:"counts"`
+	Children []*TreeNode    `json:"children,omitempty"`
+
+	// HasChildren is set when children were cut off by the depth limit
+	HasChildren bool `json:"has_children,omitempty"`
+}
+
+// buildTree aggregates a listing into a directory tree below root and returns its
+// nodes by web directory. The root node always exists.
+func buildTree(files []FileInfo, root string) map[string]*TreeNode {
+	rootNode := &TreeNode{Name: path.Base(root), Path: root, Counts: make(map[string]int)}
+	nodes := map[string]*TreeNode{root: rootNode}
+
+	// node returns the node for a directory, creating it and its parents as needed
+	var node func(dir string) *TreeNode
+	node = func(dir string) *TreeNode {
+		if n, ok := nodes[dir]; ok {
+			return n
+		}
+		n := &TreeNode{Name: path.Base(dir), Path: dir, Counts: make(map[string]int)}
+		nodes[dir] = n
+		parent := node(path.Dir(dir))
+		parent.Children = append(parent.Children, n)
+		return n
+	}
+
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	for i := range files {
+		f := &files[i]
+		if f.Dir != root && !strings.HasPrefix(f.Dir, prefix) {
+			continue
+		}
+
+		n := node(f.Dir)
+		n.Files++
+		for ; ; n = nodes[path.Dir(n.Path)] {
+			n.Total++
+			n.Size += f.Size
+			n.Counts[f.Type]++
+			if n == rootNode {
+				break
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		sort.Slice(n.Children, func(i, j int) bool {
+			return n.Children[i].Name < n.Children[j].Name
+		})
+	}
+	return nodes
+}
+
+// prune copies a node down to depth levels of children; depth < 0 copies everything
+func (n *TreeNode) prune(depth int) *TreeNode {
+	if depth < 0 {
+		return n
+	}
+	c := *n
+	c.Children = nil
+	if depth == 0 {
+		c.HasChildren = len(n.Children) > 0
+		return &c
+	}
+	for _, child := range n.Children {
+		c.Children = append(c.Children, child.prune(depth-1))
+	}
+	return &c
+}
+
+// treeCache keeps the tree of the current index version
+type treeCache struct {
+	mu      sync.Mutex
+	version uint64
+	nodes   map[string]*TreeNode // Web directory -> node, nil until first use
+}
+
+// get returns the node for a web directory, rebuilding the tree after index changes
+func (c *treeCache) get(index *MediaIndex, root, dir string) *TreeNode {
+	files, version := index.Snapshot()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.nodes == nil || c.version != version {
+		c.nodes = buildTree(files, root)
+		c.version = version
+	}
+	return c.nodes[dir]
+}
+
+// TreeHandler serves the directory hierarchy below root with per-folder counts.
+// The optional dir parameter selects a subtree and depth limits how many levels
+// of subdirectories are returned.
+func TreeHandler(index *MediaIndex, root string) http.HandlerFunc {
+	cache := &treeCache{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		values := r.URL.Query()
+		dir := root
+		if v := values.Get("dir"); v != "" {
+			if !strings.HasPrefix(v, "/") {
+				http.Error(w, fmt.Sprintf("invalid dir %q", v), http.StatusBadRequest)
+				return
+			}
+			dir = path.Clean(v)
+		}
+
+		depth := -1
+		if v := values.Get("depth"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid depth %q", v), http.StatusBadRequest)
+				return
+			}
+			depth = n
+		}
+
+		node := cache.get(index, root, dir)
+		if node == nil {
+			http.Error(w, "Directory not found", http.StatusNotFound)
+			return
+		}
+
+		// Trees are never modified after they are built, so no lock is needed here
+		writeJSON(w, node.prune(depth))
+	}
+}
@@ -0,0 +1,97 @@
+// File: tree_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildTree(t *testing.T) {
+	files, _ := testListing().Snapshot()
+	files = append(files, FileInfo{Name: "f.jpg", Path: "/other/f.jpg", Dir: "/other", Size: 1, Type: "image"})
+
+	tests := []struct {
+		name     string
+		root     string
+		dir      string
+		files    int
+		total    int
+		size     int64
+		images   int
+		children []string
+	}{
+		{"root", "/media", "/media", 2, 5, 1050, 3, []string{"x", "z"}},
+		{"subdirectory", "/media", "/media/x", 1, 2, 600, 2, []string{"y"}},
+		{"leaf", "/media", "/media/x/y", 1, 1, 400, 1, nil},
+		{"other type", "/media", "/media/z", 1, 1, 50, 0, nil},
+		{"subtree root", "/media/x", "/media/x", 1, 2, 600, 2, []string{"y"}},
+		{"empty root", "/empty", "/empty", 0, 0, 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := buildTree(files, tt.root)
+			n := nodes[tt.dir]
+			if n == nil {
+				t.Fatalf("no node for %s", tt.dir)
+			}
+			var children []string
+			for _, c := range n.Children {
+				children = append(children, c.Name)
+			}
+			if n.Files != tt.files || n.Total != tt.total || n.Size != tt.size || n.Counts["image"] != tt.images ||
+				len(children) != len(tt.children) {
+				t.Errorf("node = %+v, children %v", n, children)
+			}
+			for i := range tt.children {
+				if i < len(children) && children[i] != tt.children[i] {
+					t.Errorf("children = %v, want %v", children, tt.children)
+				}
+			}
+			// Files outside the root are left out
+			if nodes["/other"] != nil {
+				t.Error("tree contains a directory outside the root")
+			}
+		})
+	}
+}
+
+func TestTreeNodePrune(t *testing.T) {
+	files, _ := testListing().Snapshot()
+	root := buildTree(files, "/media")["/media"]
+
+	if pruned := root.prune(0); len(pruned.Children) != 0 || !pruned.HasChildren {
+		t.Errorf("prune(0) = %+v, want no children but HasChildren", pruned)
+	}
+	pruned := root.prune(1)
+	if len(pruned.Children) != 2 || len(pruned.Children[0].Children) != 0 || !pruned.Children[0].HasChildren || pruned.Children[1].HasChildren {
+		t.Errorf("prune(1) did not cut the second level: %+v", pruned.Children)
+	}
+	if len(root.Children[0].Children) != 1 {
+		t.Error("prune() modified the cached tree")
+	}
+}
+
+func TestTreeHandler(t *testing.T) {
+	handler := TreeHandler(testListing(), "/media")
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusOK},
+		{"dir=/media/x/&depth=0", http.StatusOK},
+		{"dir=/media/missing", http.StatusNotFound},
+		{"dir=media", http.StatusBadRequest},
+		{"depth=-1", http.StatusBadRequest},
+		{"depth=x", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tree?"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%q: status = %d, want %d", tt.query, rec.Code, tt.code)
+		}
+	}
+}
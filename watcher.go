@@ -0,0 +1,366 @@
+// File: watcher.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watch modes accepted by Config.Watch
+const (
+	WatchAuto = "auto" // inotify where available, polling otherwise
+	WatchPoll = "poll" // always poll
+	WatchOff  = "off"  // no live updates
+)
+
+// Batching of notifier events: a burst is collected until it has been quiet for
+// watchDebounce, but never longer than watchMaxDelay after its first event, so a
+// steady stream of events such as a long copy does not hold the index back
+const (
+	watchDebounce = 500 * time.Millisecond
+	watchMaxDelay = 5 * time.Second
+)
+
+// notifyEvent is a change reported by a platform notifier
+type notifyEvent struct {
+	Dir      string // Directory relative to the watch root
+	Overflow bool   // Events were lost, everything must be resynced
+}
+
+// notifier delivers change events for watched directories
+type notifier interface {
+	Add(relDir string) error
+	Remove(relDir string)
+	Events() <-chan notifyEvent
+}
+
+// Watcher keeps a MediaIndex in sync with changes below a root directory
+type Watcher struct {
+	opts         ScanOptions
+	index        *MediaIndex
+	pollInterval time.Duration
+	onUpdate     func(files []FileInfo)
+
+	mu         sync.Mutex
+	dirs       map[string]bool      // Known directories relative to root
+	ignoreMods map[string]time.Time // Last seen mtime of each directory's ignore file
+	visited    *dirTracker          // Directory identities, to avoid following symlink loops
+	notifier   notifier             // nil when polling
+}
+
+// StartWatcher begins watching the root in opts and calls onUpdate after each batch of
+// index changes. dirs lists the directories found by the initial scan.
+func StartWatcher(index *MediaIndex, opts ScanOptions, dirs []string, mode string, pollInterval time.Duration, onUpdate func(files []FileInfo)) (*Watcher, error) {
+	if mode == WatchOff {
+		return nil, nil
+	}
+	if mode != WatchAuto && mode != WatchPoll {
+		return nil, fmt.Errorf("unknown watch mode %q", mode)
+	}
+	if pollInterval <= 0 {
+		pollInterval = 30 * time.Second
+	}
+
+	w := &Watcher{
+		opts:         opts,
+		index:        index,
+		pollInterval: pollInterval,
+		onUpdate:     onUpdate,
+		dirs:         make(map[string]bool),
+		ignoreMods:   make(map[string]time.Time),
+		visited:      newDirTracker(),
+	}
+
+	if mode == WatchAuto {
+		n, err := newNotifier(opts.Root)
+		if err != nil {
+			log.Printf("File system notifications unavailable, polling every %s: %v", pollInterval, err)
+		} else {
+			w.notifier = n
+		}
+	}
+
+	if err := w.addDirs(dirs); err != nil {
+		if w.notifier == nil {
+			return nil, err
+		}
+		// Most likely the inotify watch limit; polling still works
+		log.Printf("Could not watch all directories, polling every %s instead: %v", pollInterval, err)
+		w.notifier = nil
+	}
+
+	if w.notifier != nil {
+		debugLog("Watching %d directories for changes", len(w.dirs))
+		go w.runNotify()
+	} else {
+		go w.runPoll()
+	}
+
+	return w, nil
+}
+
+// addDirs registers a list of already known directories
+func (w *Watcher) addDirs(dirs []string) error {
+	for _, dir := range dirs {
+		if err := w.addDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addDir starts tracking a single directory
+func (w *Watcher) addDir(relDir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.dirs[relDir] {
+		return nil
+	}
+	if w.opts.Links.following() {
+		fullPath := filepath.Join(w.opts.Root, relDir)
+		if info, err := os.Stat(fullPath); err == nil {
+			w.visited.Visit(relDir, fullPath, info)
+		}
+	}
+	if w.notifier != nil {
+		if err := w.notifier.Add(relDir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", filepath.Join(w.opts.Root, relDir), err)
+		}
+	}
+	w.dirs[relDir] = true
+	return nil
+}
+
+// removeDir forgets a directory and its subdirectories and drops their files from the index
+func (w *Watcher) removeDir(relDir string) bool {
+	w.mu.Lock()
+	prefix := relDir + string(os.PathSeparator)
+	for dir := range w.dirs {
+		if dir == relDir || strings.HasPrefix(dir, prefix) {
+			if w.notifier != nil {
+				w.notifier.Remove(dir)
+			}
+			delete(w.dirs, dir)
+		}
+	}
+	w.mu.Unlock()
+	w.visited.Forget(relDir)
+
+	return w.index.RemoveDir(w.webDir(relDir)) > 0
+}
+
+// subdirs returns the known direct children of relDir
+func (w *Watcher) subdirs(relDir string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var children []string
+	for dir := range w.dirs {
+		if dir == "" {
+			continue
+		}
+		parent := filepath.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		if parent == relDir {
+			children = append(children, dir)
+		}
+	}
+	return children
+}
+
+// webDir converts a directory relative to root to the directory part of web paths
+func (w *Watcher) webDir(relDir string) string {
+	webDir := filepath.Join(w.opts.BaseURL, relDir)
+	return strings.ReplaceAll(webDir, "\\", "/")
+}
+
+// rulesFor returns the ignore rules that apply inside relDir
+func (w *Watcher) rulesFor(relDir string) *IgnoreRules {
+	return rulesForDir(w.opts.Root, filepath.ToSlash(relDir), w.opts.Ignore)
+}
+
+// ignoreFileChanged records the mtime of relDir's ignore file and reports whether it changed
+func (w *Watcher) ignoreFileChanged(relDir string) bool {
+	modTime := ignoreFileModTime(filepath.Join(w.opts.Root, relDir))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	prev, seen := w.ignoreMods[relDir]
+	w.ignoreMods[relDir] = modTime
+	if seen {
+		return !prev.Equal(modTime)
+	}
+	return !modTime.IsZero()
+}
+
+// syncDir reconciles the index with the contents of one directory. rules are the ignore
+// rules inside relDir. With deep set every known subdirectory is synced as well,
+// otherwise only new ones.
+func (w *Watcher) syncDir(relDir string, rules *IgnoreRules, deep bool) bool {
+	fullPath := filepath.Join(w.opts.Root, relDir)
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) && relDir != "" {
+			return w.removeDir(relDir)
+		}
+		debugLog("Watcher could not read %s: %v", relDir, err)
+		return false
+	}
+
+	// New ignore rules can hide or reveal files anywhere below this directory
+	if w.ignoreFileChanged(relDir) {
+		deep = true
+	}
+
+	changed := false
+	seenFiles := make(map[string]bool)
+	seenDirs := make(map[string]bool)
+	sidecars := sidecarNames(entries)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		relPath := filepath.Join(relDir, name)
+		slashPath := filepath.ToSlash(relPath)
+
+		info, _, ok := w.opts.Links.Entry(fullPath, entry)
+		if !ok {
+			continue
+		}
+
+		if info.IsDir() {
+			if !w.opts.Recursive || rules.Ignored(slashPath, true) {
+				continue
+			}
+
+			w.mu.Lock()
+			known := w.dirs[relPath]
+			w.mu.Unlock()
+
+			if !known && w.opts.Links.following() && !w.visited.Visit(relPath, filepath.Join(fullPath, name), info) {
+				continue // Already watched under another path
+			}
+			seenDirs[relPath] = true
+
+			if !known || deep {
+				if !known {
+					if err := w.addDir(relPath); err != nil {
+						log.Printf("Watcher: %v", err)
+					}
+				}
+				patterns, _, err := readIgnoreFile(filepath.Join(fullPath, name))
+				if err != nil {
+					debugLog("Watcher could not read ignore file in %s: %v", relPath, err)
+				}
+				changed = w.syncDir(relPath, rules.With(slashPath, patterns), true) || changed
+			}
+			continue
+		}
+
+		if isSidecar(name) || rules.Ignored(slashPath, false) {
+			continue
+		}
+
+		f := newFileInfo(w.opts.Name, w.opts.BaseURL, relPath, info)
+		// Only read files that are new or changed since they were last inspected
+		if old, ok := w.index.Get(f.Path); ok && old.Size == f.Size && old.Modified.Equal(f.Modified) {
+			f = old
+		} else {
+			inspectFile(w.opts, &f, filepath.Join(fullPath, name))
+		}
+		if w.opts.Metadata {
+			attachSidecar(&f, fullPath, sidecars)
+		}
+		seenFiles[f.Path] = true
+		if w.index.Put(f) {
+			debugLog("Watcher: updated %s", f.Path)
+			changed = true
+		}
+	}
+
+	for _, p := range w.index.FilesInDir(w.webDir(relDir)) {
+		if !seenFiles[p] && w.index.Remove(p) {
+			debugLog("Watcher: removed %s", p)
+			changed = true
+		}
+	}
+
+	for _, dir := range w.subdirs(relDir) {
+		if !seenDirs[dir] {
+			changed = w.removeDir(dir) || changed
+		}
+	}
+
+	return changed
+}
+
+// publish hands the current listing to the update callback
+func (w *Watcher) publish() {
+	if w.onUpdate != nil {
+		w.onUpdate(w.index.Files())
+	}
+}
+
+// runNotify applies notifier events, batching bursts of activity
+func (w *Watcher) runNotify() {
+	pending := make(map[string]bool)
+
+	// The watches were added after the initial scan, so changes made in between,
+	// e.g. in directories that were scanned early, are found by one full pass first
+	fullSync := true
+	timer := time.NewTimer(0)
+	var first time.Time // First event of the current burst, zero when none is waiting
+
+	for {
+		select {
+		case ev := <-w.notifier.Events():
+			if ev.Overflow {
+				fullSync = true
+			} else {
+				pending[ev.Dir] = true
+			}
+			now := time.Now()
+			if first.IsZero() {
+				first = now
+			}
+			timer.Reset(max(0, min(watchDebounce, first.Add(watchMaxDelay).Sub(now))))
+
+		case <-timer.C:
+			changed := false
+			if fullSync {
+				changed = w.syncDir("", w.rulesFor(""), true)
+			} else {
+				for dir := range pending {
+					changed = w.syncDir(dir, w.rulesFor(dir), false) || changed
+				}
+			}
+			pending = make(map[string]bool)
+			fullSync = false
+			first = time.Time{}
+
+			if changed {
+				w.publish()
+			}
+		}
+	}
+}
+
+// runPoll periodically reconciles the whole tree
+func (w *Watcher) runPoll() {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if w.syncDir("", w.rulesFor(""), true) {
+			w.publish()
+		}
+	}
+}
@@ -0,0 +1,128 @@
+//go:build linux
+
+// File: watcher_linux.go
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the events that can change a directory listing
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// inotifyNotifier reports directory changes using Linux inotify
+type inotifyNotifier struct {
+	root   string
+	fd     int
+	mu     sync.Mutex
+	wds    map[int32]string // Watch descriptor -> directory relative to root
+	dirs   map[string]int32
+	events chan notifyEvent
+}
+
+func newNotifier(root string) (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &inotifyNotifier{
+		root:   root,
+		fd:     fd,
+		wds:    make(map[int32]string),
+		dirs:   make(map[string]int32),
+		events: make(chan notifyEvent, 256),
+	}
+	go n.readEvents()
+	return n, nil
+}
+
+func (n *inotifyNotifier) Add(relDir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, filepath.Join(n.root, relDir), inotifyMask)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.wds[int32(wd)] = relDir
+	n.dirs[relDir] = int32(wd)
+	n.mu.Unlock()
+	return nil
+}
+
+func (n *inotifyNotifier) Remove(relDir string) {
+	n.mu.Lock()
+	wd, ok := n.dirs[relDir]
+	if ok {
+		delete(n.dirs, relDir)
+		delete(n.wds, wd)
+	}
+	n.mu.Unlock()
+
+	if ok {
+		// Fails harmlessly when the kernel already dropped the watch with the directory
+		syscall.InotifyRmWatch(n.fd, uint32(wd))
+	}
+}
+
+func (n *inotifyNotifier) Events() <-chan notifyEvent {
+	return n.events
+}
+
+// readEvents decodes raw inotify records and forwards the affected directories
+func (n *inotifyNotifier) readEvents() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		count, err := syscall.Read(n.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || count <= 0 {
+			log.Printf("Stopped reading inotify events: %v", err)
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				n.events <- notifyEvent{Overflow: true}
+				continue
+			}
+
+			n.mu.Lock()
+			relDir, ok := n.wds[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(n.wds, raw.Wd)
+				if ok && n.dirs[relDir] == raw.Wd {
+					delete(n.dirs, relDir)
+				}
+			}
+			n.mu.Unlock()
+
+			if !ok {
+				continue
+			}
+
+			if raw.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 && relDir != "" {
+				// The parent listing tells whether the directory is gone or renamed
+				parent := filepath.Dir(relDir)
+				if parent == "." {
+					parent = ""
+				}
+				n.events <- notifyEvent{Dir: parent}
+				continue
+			}
+
+			n.events <- notifyEvent{Dir: relDir}
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+// File: watcher_other.go
+package main
+
+import "errors"
+
+// newNotifier has no native implementation on this platform, so the watcher polls
+func newNotifier(root string) (notifier, error) {
+	return nil, errors.New("not supported on this platform")
+}
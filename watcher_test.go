@@ -0,0 +1,184 @@
+// File: watcher_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeTree creates files below root from paths relative to it and their contents
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// indexPaths returns the sorted web paths in an index
+func indexPaths(index *MediaIndex) []string {
+	var paths []string
+	for _, f := range index.Files() {
+		paths = append(paths, f.Path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// newTestWatcher creates a polling watcher for root without starting it
+func newTestWatcher(root string) *Watcher {
+	return &Watcher{
+		opts:       ScanOptions{Root: root, BaseURL: "/media", Recursive: true},
+		index:      NewMediaIndex(nil),
+		dirs:       make(map[string]bool),
+		ignoreMods: make(map[string]time.Time),
+		visited:    newDirTracker(),
+	}
+}
+
+func TestWatcherSyncDir(t *testing.T) {
+	initial := map[string]string{
+		"a.jpg":          "a",
+		"sub/b.jpg":      "b",
+		"sub/deep/c.mp4": "c",
+	}
+
+	tests := []struct {
+		name     string
+		change   func(t *testing.T, root string)
+		changed  bool
+		expected []string
+	}{
+		{"nothing", func(t *testing.T, root string) {}, false,
+			[]string{"/media/a.jpg", "/media/sub/b.jpg", "/media/sub/deep/c.mp4"}},
+		{"file added", func(t *testing.T, root string) {
+			writeTree(t, root, map[string]string{"sub/new.png": "n"})
+		}, true, []string{"/media/a.jpg", "/media/sub/b.jpg", "/media/sub/deep/c.mp4", "/media/sub/new.png"}},
+		{"file removed", func(t *testing.T, root string) {
+			os.Remove(filepath.Join(root, "a.jpg"))
+		}, true, []string{"/media/sub/b.jpg", "/media/sub/deep/c.mp4"}},
+		{"file changed", func(t *testing.T, root string) {
+			writeTree(t, root, map[string]string{"a.jpg": "longer"})
+		}, true, []string{"/media/a.jpg", "/media/sub/b.jpg", "/media/sub/deep/c.mp4"}},
+		{"directory added", func(t *testing.T, root string) {
+			writeTree(t, root, map[string]string{"x/y/z.jpg": "z"})
+		}, true, []string{"/media/a.jpg", "/media/sub/b.jpg", "/media/sub/deep/c.mp4", "/media/x/y/z.jpg"}},
+		{"directory removed", func(t *testing.T, root string) {
+			os.RemoveAll(filepath.Join(root, "sub"))
+		}, true, []string{"/media/a.jpg"}},
+		{"ignore file added", func(t *testing.T, root string) {
+			writeTree(t, root, map[string]string{ignoreFileName: "deep/\n*.jpg\n!a.jpg\n"})
+		}, true, []string{"/media/a.jpg"}},
+		{"sidecar added", func(t *testing.T, root string) {
+			writeTree(t, root, map[string]string{"a.jpg.xmp": "<x/>"})
+		}, false, []string{"/media/a.jpg", "/media/sub/b.jpg", "/media/sub/deep/c.mp4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, initial)
+			w := newTestWatcher(root)
+			if err := w.addDir(""); err != nil {
+				t.Fatal(err)
+			}
+			if !w.syncDir("", w.rulesFor(""), true) {
+				t.Fatal("first sync reported no change")
+			}
+
+			tt.change(t, root)
+			if changed := w.syncDir("", w.rulesFor(""), true); changed != tt.changed {
+				t.Errorf("syncDir() = %v, want %v", changed, tt.changed)
+			}
+			if got := indexPaths(w.index); !slices.Equal(got, tt.expected) {
+				t.Errorf("index = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWatcherIgnoreFileEdited(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"sub/a.jpg": "a", "sub/b.png": "b", "sub/" + ignoreFileName: "*.jpg\n"})
+	w := newTestWatcher(root)
+	w.addDir("")
+	w.syncDir("", w.rulesFor(""), true)
+	if got := indexPaths(w.index); !slices.Equal(got, []string{"/media/sub/b.png"}) {
+		t.Fatalf("index = %v, want only b.png", got)
+	}
+
+	// An ignore file edited in place is noticed by its own mtime, and its rules
+	// replace the old ones
+	ignorePath := filepath.Join(root, "sub", ignoreFileName)
+	os.WriteFile(ignorePath, []byte("*.png\n"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(ignorePath, later, later)
+	if !w.syncDir("sub", w.rulesFor("sub"), false) {
+		t.Error("syncDir() after editing the ignore file reported no change")
+	}
+	if got := indexPaths(w.index); !slices.Equal(got, []string{"/media/sub/a.jpg"}) {
+		t.Errorf("index = %v, want only a.jpg", got)
+	}
+}
+
+// testNotifier delivers events sent by a test
+type testNotifier struct {
+	events chan notifyEvent
+}
+
+func (n *testNotifier) Add(relDir string) error    { return nil }
+func (n *testNotifier) Remove(relDir string)       {}
+func (n *testNotifier) Events() <-chan notifyEvent { return n.events }
+
+func TestWatcherMaxDelay(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the maximum event delay")
+	}
+	root := t.TempDir()
+	updates := make(chan []FileInfo, 10)
+	n := &testNotifier{events: make(chan notifyEvent)}
+	w := newTestWatcher(root)
+	w.notifier = n
+	w.onUpdate = func(files []FileInfo) { updates <- files }
+	w.addDir("")
+	writeTree(t, root, map[string]string{"a.jpg": "a"})
+	go w.runNotify()
+
+	// The initial pass finds the file created before the watcher started
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatal("no initial sync")
+	}
+
+	// Events faster than the debounce interval must not postpone the sync forever
+	writeTree(t, root, map[string]string{"b.jpg": "b"})
+	start := time.Now()
+	ticker := time.NewTicker(watchDebounce / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case files := <-updates:
+			if len(files) != 2 {
+				t.Fatalf("update with %d files, want 2", len(files))
+			}
+			if waited := time.Since(start); waited > watchMaxDelay+time.Second {
+				t.Errorf("sync took %v with a steady stream of events", waited)
+			}
+			return
+		case <-ticker.C:
+			if time.Since(start) > 2*watchMaxDelay {
+				t.Fatal("no sync while events kept arriving")
+			}
+			n.events <- notifyEvent{Dir: ""}
+		}
+	}
+}
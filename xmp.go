@@ -0,0 +1,573 @@
+// File: xmp.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XML namespaces of the sidecar properties we read and write
+const (
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXMP = "http://ns.adobe.com/xap/1.0/"
+	nsDC  = "http://purl.org/dc/elements/1.1/"
+)
+
+// sidecarExt is the extension of XMP sidecar files, matched case-insensitively
+const sidecarExt = ".xmp"
+
+// Limits for values written through the API
+const (
+	maxLabelLength   = 64
+	maxKeywordLength = 256
+	maxKeywords      = 256
+)
+
+// XMPInfo is what darktable, Lightroom and similar editors store in the XMP
+// sidecar next to a file
+type XMPInfo struct {
+	Name     string    `json:"name"`               // File name of the sidecar, in the same directory
+	Rating   int       `json:"rating"`             // Stars from 0 to 5, -1 for rejected
+	Label    string    `json:"label,omitempty"`    // Color label, e.g. Red
+	Keywords []string  `json:"keywords,omitempty"` // dc:subject
+	Modified time.Time `json:"modified"`           // Sidecar mtime, sidecars are edited in place
+}
+
+// isSidecar reports whether a file name is an XMP sidecar
+func isSidecar(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), sidecarExt)
+}
+
+// sidecarNames maps the lowercase names of the sidecars in a directory listing to their names
+func sidecarNames(entries []os.DirEntry) map[string]string {
+	var names map[string]string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && isSidecar(name) {
+			if names == nil {
+				names = make(map[string]string)
+			}
+			names[strings.ToLower(name)] = name
+		}
+	}
+	return names
+}
+
+// sidecarCandidates returns the sidecar names a file may have: darktable appends
+// .xmp to the whole name (IMG_1234.CR2.xmp), Lightroom replaces the extension (IMG_1234.xmp)
+func sidecarCandidates(name string) []string {
+	candidates := []string{name + sidecarExt}
+	if ext := filepath.Ext(name); ext != "" {
+		candidates = append(candidates, strings.TrimSuffix(name, ext)+sidecarExt)
+	}
+	return candidates
+}
+
+// attachSidecar sets the sidecar of f from the sidecars in its directory dir. An
+// unchanged sidecar is not read again.
+func attachSidecar(f *FileInfo, dir string, sidecars map[string]string) {
+	name := ""
+	for _, candidate := range sidecarCandidates(f.Name) {
+		if found, ok := sidecars[strings.ToLower(candidate)]; ok {
+			name = found
+			break
+		}
+	}
+	if name == "" {
+		f.XMP = nil
+		return
+	}
+
+	sidecarPath := filepath.Join(dir, name)
+	info, err := os.Stat(sidecarPath)
+	if err != nil {
+		f.XMP = nil
+		return
+	}
+	if f.XMP != nil && f.XMP.Name == name && f.XMP.Modified.Equal(info.ModTime()) {
+		return
+	}
+
+	xmp, err := readSidecar(sidecarPath)
+	if err != nil {
+		debugLog("Could not read sidecar %s: %v", sidecarPath, err)
+		f.XMP = nil
+		return
+	}
+	xmp.Name = name
+	xmp.Modified = info.ModTime()
+	f.XMP = xmp
+}
+
+// sidecarsChanged reports whether a sidecar of the files in a stored directory
+// record was edited since it was read
+func (record *dirRecord) sidecarsChanged(dir string) bool {
+	for i := range record.Files {
+		xmp := record.Files[i].XMP
+		if xmp == nil {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, xmp.Name))
+		if err != nil || !info.ModTime().Equal(xmp.Modified) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSidecar reports whether two entries have the same version of a sidecar
+func sameSidecar(a, b *XMPInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Name == b.Name && a.Modified.Equal(b.Modified)
+}
+
+// readSidecar parses an XMP sidecar file
+func readSidecar(fullPath string) (*XMPInfo, error) {
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseXMP(data)
+}
+
+// parseXMP reads the rating, label and keywords of an XMP packet. Properties may be
+// written as attributes of rdf:Description or as elements.
+func parseXMP(data []byte) (*XMPInfo, error) {
+	info := &XMPInfo{}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	inSubject := false
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return info, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XMP: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name {
+			case xml.Name{Space: nsRDF, Local: "Description"}:
+				for _, attr := range t.Attr {
+					if attr.Name.Space == nsXMP {
+						info.setProperty(attr.Name.Local, attr.Value)
+					}
+				}
+			case xml.Name{Space: nsXMP, Local: "Rating"}, xml.Name{Space: nsXMP, Local: "Label"}:
+				var value string
+				if err := d.DecodeElement(&value, &t); err != nil {
+					return nil, fmt.Errorf("invalid XMP: %w", err)
+				}
+				info.setProperty(t.Name.Local, value)
+			case xml.Name{Space: nsDC, Local: "subject"}:
+				inSubject = true
+			case xml.Name{Space: nsRDF, Local: "li"}:
+				if !inSubject {
+					continue
+				}
+				var keyword string
+				if err := d.DecodeElement(&keyword, &t); err != nil {
+					return nil, fmt.Errorf("invalid XMP: %w", err)
+				}
+				if keyword = strings.TrimSpace(keyword); keyword != "" && !slices.Contains(info.Keywords, keyword) {
+					info.Keywords = append(info.Keywords, keyword)
+				}
+			}
+		case xml.EndElement:
+			if t.Name == (xml.Name{Space: nsDC, Local: "subject"}) {
+				inSubject = false
+			}
+		}
+	}
+}
+
+// setProperty stores an xmp: property
+func (info *XMPInfo) setProperty(name, value string) {
+	value = strings.TrimSpace(value)
+	switch name {
+	case "Rating":
+		// Some tools write fractional ratings
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			info.Rating = max(-1, min(5, int(v)))
+		}
+	case "Label":
+		info.Label = value
+	}
+}
+
+// xmpEdit replaces the bytes from start to end of a sidecar with text
+type xmpEdit struct {
+	start, end int64
+	text       string
+}
+
+// updateXMP returns the sidecar data with the rating, label and keywords of info.
+// Everything else in the sidecar is kept as it is. The properties are written to the
+// first rdf:Description and removed from any other; an empty data creates a new packet.
+func updateXMP(data []byte, info *XMPInfo) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte(xml.Header + `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="` + nsRDF + `">
+  <rdf:Description rdf:about=""/>
+ </rdf:RDF>
+</x:xmpmeta>
+`)
+	}
+
+	// The raw tokens keep the prefixes as written, so namespaces are resolved here
+	d := xml.NewDecoder(bytes.NewReader(data))
+	scopes := []map[string]string{{"xml": "http://www.w3.org/XML/1998/namespace"}}
+	resolve := func(prefix string) string {
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if ns, ok := scopes[i][prefix]; ok {
+				return ns
+			}
+		}
+		return ""
+	}
+
+	var edits []xmpEdit
+	var first *xml.StartElement // First rdf:Description
+	var firstStart, firstEnd int64
+	firstScope := map[string]string{} // Prefixes visible at the first rdf:Description
+	firstEmpty := false               // It was written as <rdf:Description .../>
+	var skipTo xml.Name               // Raw name of an element being removed
+	var skipStart int64
+	depth, skipDepth := 0, -1
+
+	for {
+		start := d.InputOffset()
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XMP: %w", err)
+		}
+		end := d.InputOffset()
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			scope := make(map[string]string)
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns":
+					scope[attr.Name.Local] = attr.Value
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					scope[""] = attr.Value
+				}
+			}
+			scopes = append(scopes, scope)
+			if skipDepth >= 0 {
+				continue
+			}
+
+			ns := resolve(t.Name.Space)
+			switch {
+			case ns == nsRDF && t.Name.Local == "Description":
+				var kept []xml.Attr
+				for _, attr := range t.Attr {
+					if attr.Name.Space != "" && attr.Name.Space != "xmlns" && resolve(attr.Name.Space) == nsXMP &&
+						(attr.Name.Local == "Rating" || attr.Name.Local == "Label") {
+						continue
+					}
+					kept = append(kept, attr)
+				}
+				if first == nil {
+					t.Attr = kept
+					first, firstStart, firstEnd = &t, start, end
+					firstEmpty = isEmptyTag(data, end)
+					for i := range scopes {
+						for prefix, ns := range scopes[i] {
+							firstScope[prefix] = ns
+						}
+					}
+				} else if len(kept) != len(t.Attr) {
+					t.Attr = kept
+					edits = append(edits, xmpEdit{start, end, rawStartTag(t, isEmptyTag(data, end))})
+				}
+			case (ns == nsXMP && (t.Name.Local == "Rating" || t.Name.Local == "Label")) ||
+				(ns == nsDC && t.Name.Local == "subject"):
+				skipTo, skipStart, skipDepth = t.Name, start, depth
+			}
+
+		case xml.EndElement:
+			if skipDepth == depth && t.Name == skipTo {
+				edits = append(edits, xmpEdit{trimLineStart(data, skipStart), end, ""})
+				skipDepth = -1
+			}
+			scopes = scopes[:len(scopes)-1]
+			depth--
+		}
+	}
+	if first == nil {
+		return nil, fmt.Errorf("invalid XMP: no rdf:Description")
+	}
+
+	// Use the prefixes the sidecar already has, or declare the usual ones
+	prefixOf := func(ns, preferred string) string {
+		for prefix, uri := range firstScope {
+			if uri == ns && prefix != "" {
+				return prefix
+			}
+		}
+		first.Attr = append(first.Attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: preferred}, Value: ns})
+		return preferred
+	}
+	xmpPrefix := ""
+	if info.Rating != 0 || info.Label != "" {
+		xmpPrefix = prefixOf(nsXMP, "xmp")
+	}
+	if info.Rating != 0 {
+		first.Attr = append(first.Attr, xml.Attr{Name: xml.Name{Space: xmpPrefix, Local: "Rating"}, Value: strconv.Itoa(info.Rating)})
+	}
+	if info.Label != "" {
+		first.Attr = append(first.Attr, xml.Attr{Name: xml.Name{Space: xmpPrefix, Local: "Label"}, Value: info.Label})
+	}
+
+	var children strings.Builder
+	if len(info.Keywords) > 0 {
+		dcPrefix := prefixOf(nsDC, "dc")
+		rdfPrefix := first.Name.Space
+		children.WriteString("\n   <" + dcPrefix + ":subject>\n    <" + rdfPrefix + ":Bag>\n")
+		for _, keyword := range info.Keywords {
+			children.WriteString("     <" + rdfPrefix + ":li>" + escapeXML(keyword) + "</" + rdfPrefix + ":li>\n")
+		}
+		children.WriteString("    </" + rdfPrefix + ":Bag>\n   </" + dcPrefix + ":subject>")
+	}
+
+	text := rawStartTag(*first, false) + children.String()
+	if firstEmpty {
+		if children.Len() > 0 {
+			text += "\n  </" + rawName(first.Name) + ">"
+		} else {
+			text = rawStartTag(*first, true)
+		}
+	}
+	edits = append(edits, xmpEdit{firstStart, firstEnd, text})
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var out bytes.Buffer
+	pos := int64(0)
+	for _, e := range edits {
+		if e.start < pos {
+			continue // Inside an element that was already removed
+		}
+		out.Write(data[pos:e.start])
+		out.WriteString(e.text)
+		pos = e.end
+	}
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
+
+// isEmptyTag reports whether the start tag ending at end was written as <a/>
+func isEmptyTag(data []byte, end int64) bool {
+	return end >= 2 && string(data[end-2:end]) == "/>"
+}
+
+// trimLineStart moves start back over the indentation of an element on its own line
+func trimLineStart(data []byte, start int64) int64 {
+	i := start
+	for i > 0 && (data[i-1] == ' ' || data[i-1] == '\t') {
+		i--
+	}
+	if i > 0 && data[i-1] == '\n' {
+		i--
+		if i > 0 && data[i-1] == '\r' {
+			i--
+		}
+		return i
+	}
+	return start
+}
+
+// rawName formats a name as written, prefix:local
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// rawStartTag formats a start tag from a raw token
+func rawStartTag(t xml.StartElement, empty bool) string {
+	var b strings.Builder
+	b.WriteString("<" + rawName(t.Name))
+	for _, attr := range t.Attr {
+		b.WriteString(" " + rawName(attr.Name) + `="` + escapeXML(attr.Value) + `"`)
+	}
+	if empty {
+		b.WriteString("/>")
+	} else {
+		b.WriteString(">")
+	}
+	return b.String()
+}
+
+// escapeXML escapes text for use in element content and attribute values
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeSidecar updates or creates the sidecar at fullPath. The file is replaced
+// atomically so an editor never reads a half-written sidecar.
+func writeSidecar(fullPath string, info *XMPInfo) error {
+	data, err := os.ReadFile(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(fullPath); err == nil {
+		mode = st.Mode().Perm()
+	}
+
+	data, err = updateXMP(data, info)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".localpics-*.xmp.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fullPath)
+}
+
+// sidecarUpdate is the JSON body of a PUT to /sidecar/. Fields that are left out
+// keep their current value.
+type sidecarUpdate struct {
+	Rating   *int      `json:"rating"`
+	Label    *string   `json:"label"`
+	Keywords *[]string `json:"keywords"`
+}
+
+// apply validates the update and applies it to info
+func (u sidecarUpdate) apply(info *XMPInfo) error {
+	if u.Rating != nil {
+		if *u.Rating < -1 || *u.Rating > 5 {
+			return fmt.Errorf("rating must be between -1 and 5")
+		}
+		info.Rating = *u.Rating
+	}
+	if u.Label != nil {
+		label := strings.TrimSpace(*u.Label)
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("label is longer than %d bytes", maxLabelLength)
+		}
+		info.Label = label
+	}
+	if u.Keywords != nil {
+		if len(*u.Keywords) > maxKeywords {
+			return fmt.Errorf("more than %d keywords", maxKeywords)
+		}
+		keywords := make([]string, 0, len(*u.Keywords))
+		for _, keyword := range *u.Keywords {
+			keyword = strings.TrimSpace(keyword)
+			if len(keyword) > maxKeywordLength {
+				return fmt.Errorf("keyword is longer than %d bytes", maxKeywordLength)
+			}
+			if keyword != "" && !slices.Contains(keywords, keyword) {
+				keywords = append(keywords, keyword)
+			}
+		}
+		info.Keywords = keywords
+	}
+	return nil
+}
+
+// SidecarHandler writes ratings, labels and keywords to the XMP sidecar of a file.
+// The path below /sidecar/ is the same as below /media/. A file without a sidecar
+// gets a new one named like darktable does, <name>.xmp.
+func SidecarHandler(libs Libraries, index *MediaIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Only PUT method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		mediaPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/sidecar/"))
+		lib, fullPath, ok := libs.Resolve(mediaPath)
+		if !ok {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		if !lib.AllowEdit {
+			http.Error(w, "Editing is not enabled", http.StatusForbidden)
+			return
+		}
+
+		webPath := mediaURL + mediaPath
+		f, ok := index.Get(webPath)
+		if !ok || isSidecar(f.Name) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+
+		var update sidecarUpdate
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&update); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		// Start from the sidecar on disk, it may have changed since it was indexed
+		dir := filepath.Dir(fullPath)
+		info := &XMPInfo{Name: f.Name + sidecarExt}
+		if f.XMP != nil {
+			info.Name = f.XMP.Name
+			if current, err := readSidecar(filepath.Join(dir, info.Name)); err == nil {
+				current.Name = info.Name
+				info = current
+			}
+		}
+		if err := update.apply(info); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sidecarPath := filepath.Join(dir, info.Name)
+		if err := writeSidecar(sidecarPath, info); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to write sidecar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if st, err := os.Stat(sidecarPath); err == nil {
+			info.Modified = st.ModTime()
+		}
+
+		index.Update(webPath, func(cur *FileInfo) bool {
+			cur.XMP = info
+			return true
+		})
+		writeJSON(w, info)
+	}
+}
@@ -0,0 +1,212 @@
+// File: xmp_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// darktableXMP is a sidecar as darktable writes it, with properties as attributes,
+// an edit history that must survive updates and keywords as elements
+const darktableXMP = `<?xml version="1.0" encoding="UTF-8"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="XMP Core 4.4.0-Exiv2">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:darktable="http://darktable.sf.net/"
+    xmp:Rating="3"
+    xmp:Label="Red"
+    darktable:history_end="2">
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>beach</rdf:li>
+     <rdf:li>family</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <darktable:history>
+    <rdf:Seq>
+     <rdf:li darktable:operation="exposure"/>
+    </rdf:Seq>
+   </darktable:history>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+`
+
+func TestParseXMP(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected *XMPInfo // nil when an error is expected
+	}{
+		{"darktable", darktableXMP, &XMPInfo{Rating: 3, Label: "Red", Keywords: []string{"beach", "family"}}},
+		{"elements", `<rdf:RDF xmlns:rdf="` + nsRDF + `" xmlns:xmp="` + nsXMP + `"><rdf:Description>` +
+			`<xmp:Rating>4</xmp:Rating><xmp:Label> Green </xmp:Label></rdf:Description></rdf:RDF>`,
+			&XMPInfo{Rating: 4, Label: "Green"}},
+		{"other prefixes", `<a:Description xmlns:a="` + nsRDF + `" xmlns:b="` + nsXMP + `" b:Rating="2"/>`, &XMPInfo{Rating: 2}},
+		{"rejected", `<rdf:Description xmlns:rdf="` + nsRDF + `" xmlns:xmp="` + nsXMP + `" xmp:Rating="-1"/>`, &XMPInfo{Rating: -1}},
+		{"fractional rating", `<rdf:Description xmlns:rdf="` + nsRDF + `" xmlns:xmp="` + nsXMP + `" xmp:Rating="4.5"/>`, &XMPInfo{Rating: 4}},
+		{"rating out of range", `<rdf:Description xmlns:rdf="` + nsRDF + `" xmlns:xmp="` + nsXMP + `" xmp:Rating="99"/>`, &XMPInfo{Rating: 5}},
+		{"rating not a number", `<rdf:Description xmlns:rdf="` + nsRDF + `" xmlns:xmp="` + nsXMP + `" xmp:Rating="five"/>`, &XMPInfo{}},
+		{"duplicate and blank keywords", `<r:RDF xmlns:r="` + nsRDF + `" xmlns:dc="` + nsDC + `"><dc:subject><r:Bag>` +
+			`<r:li>a</r:li><r:li> </r:li><r:li>a</r:li><r:li>b</r:li></r:Bag></dc:subject></r:RDF>`,
+			&XMPInfo{Keywords: []string{"a", "b"}}},
+		{"list outside subject", `<r:RDF xmlns:r="` + nsRDF + `"><r:Seq><r:li>x</r:li></r:Seq></r:RDF>`, &XMPInfo{}},
+		{"empty", "", &XMPInfo{}},
+		{"truncated", darktableXMP[:len(darktableXMP)/2], nil},
+		{"unclosed element value", `<x xmlns:xmp="` + nsXMP + `"><xmp:Rating>3`, nil},
+		{"mismatched tags", `<a><b></a>`, nil},
+		{"not XML", "\x00\x01\x02", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseXMP([]byte(tt.data))
+			if tt.expected == nil {
+				if err == nil {
+					t.Errorf("parseXMP() = %+v, want an error", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseXMP() error = %v", err)
+			}
+			if info.Rating != tt.expected.Rating || info.Label != tt.expected.Label || !slices.Equal(info.Keywords, tt.expected.Keywords) {
+				t.Errorf("parseXMP() = %+v, want %+v", info, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUpdateXMP(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		info XMPInfo
+	}{
+		{"new sidecar", "", XMPInfo{Rating: 5, Label: "Blue", Keywords: []string{"sea", "a < b"}}},
+		{"darktable", darktableXMP, XMPInfo{Rating: 1, Keywords: []string{"beach"}}},
+		{"clear everything", darktableXMP, XMPInfo{}},
+		{"empty description", `<rdf:RDF xmlns:rdf="` + nsRDF + `"><rdf:Description rdf:about=""/></rdf:RDF>`,
+			XMPInfo{Rating: -1, Keywords: []string{"k"}}},
+		{"elements and second description", `<rdf:RDF xmlns:rdf="` + nsRDF + `" xmlns:xmp="` + nsXMP + `">` +
+			`<rdf:Description><xmp:Rating>2</xmp:Rating></rdf:Description>` +
+			`<rdf:Description xmp:Label="Red"/></rdf:RDF>`,
+			XMPInfo{Label: "Purple"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := updateXMP([]byte(tt.data), &tt.info)
+			if err != nil {
+				t.Fatalf("updateXMP() error = %v", err)
+			}
+			got, err := parseXMP(data)
+			if err != nil {
+				t.Fatalf("parseXMP() of the update error = %v\n%s", err, data)
+			}
+			if got.Rating != tt.info.Rating || got.Label != tt.info.Label || !slices.Equal(got.Keywords, tt.info.Keywords) {
+				t.Errorf("round trip = %+v, want %+v\n%s", got, tt.info, data)
+			}
+			// Properties of other tools are kept
+			if strings.Contains(tt.data, "darktable:history") && !strings.Contains(string(data), `<rdf:li darktable:operation="exposure"/>`) {
+				t.Errorf("updateXMP() dropped the darktable history\n%s", data)
+			}
+		})
+	}
+
+	for _, data := range []string{"<x/>", "<a><b></a>", darktableXMP[:len(darktableXMP)/2]} {
+		if _, err := updateXMP([]byte(data), &XMPInfo{Rating: 1}); err == nil {
+			t.Errorf("updateXMP(%q) returned no error", data)
+		}
+	}
+}
+
+func TestSidecarRoundTrip(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "IMG_1234.CR2.xmp")
+	if err := os.WriteFile(p, []byte(darktableXMP), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := readSidecar(p)
+	if err != nil {
+		t.Fatalf("readSidecar() error = %v", err)
+	}
+	rating, label, keywords := 5, " Green ", []string{"family", " beach ", "family", "", "new"}
+	update := sidecarUpdate{Rating: &rating, Label: &label, Keywords: &keywords}
+	if err := update.apply(info); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if err := writeSidecar(p, info); err != nil {
+		t.Fatalf("writeSidecar() error = %v", err)
+	}
+
+	got, err := readSidecar(p)
+	if err != nil {
+		t.Fatalf("readSidecar() after writing error = %v", err)
+	}
+	if got.Rating != 5 || got.Label != "Green" || !slices.Equal(got.Keywords, []string{"family", "beach", "new"}) {
+		t.Errorf("readSidecar() after writing = %+v", got)
+	}
+	if st, err := os.Stat(p); err != nil {
+		t.Error(err)
+	} else if st.Mode().Perm() != 0600 {
+		t.Errorf("sidecar mode = %v, want the mode it had", st.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(p)); len(entries) != 1 {
+		t.Errorf("temporary files were left behind: %v", entries)
+	}
+
+	// Writing the same values again changes nothing
+	before, _ := os.ReadFile(p)
+	if err := writeSidecar(p, got); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(p); string(after) != string(before) {
+		t.Errorf("writing unchanged values changed the sidecar:\n%s\n---\n%s", before, after)
+	}
+
+	// A missing sidecar is created
+	created := filepath.Join(filepath.Dir(p), "IMG_1235.xmp")
+	if err := writeSidecar(created, &XMPInfo{Rating: 2}); err != nil {
+		t.Fatalf("writeSidecar() of a new file error = %v", err)
+	}
+	if got, err := readSidecar(created); err != nil || got.Rating != 2 {
+		t.Errorf("readSidecar() of a new file = %+v, %v", got, err)
+	}
+}
+
+func TestSidecarUpdateApply(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	strPtr := func(v string) *string { return &v }
+	long := strings.Repeat("x", maxKeywordLength+1)
+	tooMany := make([]string, maxKeywords+1)
+
+	tests := []struct {
+		name   string
+		update sidecarUpdate
+		valid  bool
+	}{
+		{"nothing", sidecarUpdate{}, true},
+		{"rejected", sidecarUpdate{Rating: intPtr(-1)}, true},
+		{"rating too low", sidecarUpdate{Rating: intPtr(-2)}, false},
+		{"rating too high", sidecarUpdate{Rating: intPtr(6)}, false},
+		{"label too long", sidecarUpdate{Label: strPtr(strings.Repeat("x", maxLabelLength+1))}, false},
+		{"keyword too long", sidecarUpdate{Keywords: &[]string{long}}, false},
+		{"too many keywords", sidecarUpdate{Keywords: &tooMany}, false},
+	}
+	for _, tt := range tests {
+		info := &XMPInfo{Rating: 3, Label: "Red", Keywords: []string{"a"}}
+		err := tt.update.apply(info)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: apply() error = %v, want valid %v", tt.name, err, tt.valid)
+		}
+		if tt.update == (sidecarUpdate{}) && (info.Rating != 3 || info.Label != "Red" || len(info.Keywords) != 1) {
+			t.Errorf("%s: apply() changed fields that were left out: %+v", tt.name, info)
+		}
+	}
+}